@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/einherij/pilot/pkg/audit"
+)
+
+const defaultAuditLog = "./audit.jsonl"
+
+// auditCommand prints audit records in the time range, e.g. "pilot audit -from 2h" or
+// "pilot audit -from 2023-01-01T12:00:00Z -to 2023-01-01T13:00:00Z -json".
+func auditCommand(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("file", auditLogPath(), "audit log file")
+	fromFlag := fs.String("from", "", "start of the range, RFC3339 time or duration ago like 30m")
+	toFlag := fs.String("to", "", "end of the range, RFC3339 time or duration ago")
+	asJSON := fs.Bool("json", false, "print records as JSON lines")
+	_ = fs.Parse(args)
+
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		return fmt.Errorf("error parsing -from: %w", err)
+	}
+	to, err := parseTime(*toFlag)
+	if err != nil {
+		return fmt.Errorf("error parsing -to: %w", err)
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	enc := json.NewEncoder(os.Stdout)
+	return audit.Query(f, from, to, func(r audit.Record) error {
+		if *asJSON {
+			return enc.Encode(r)
+		}
+		who := r.Operator
+		if who == "" {
+			who = r.Session
+		}
+		_, err := fmt.Printf("%s %-10s %-8s %-6s %-4s %-40q %s pos=(%.2f %.2f %.2f) yaw=%d bat=%d%%\n",
+			r.Time.Format(time.RFC3339Nano), r.Kind, who, r.Role, r.Command, r.Action, r.Outcome,
+			r.State.X, r.State.Y, r.State.Z, r.State.Yaw, r.State.Battery)
+		return err
+	})
+}
+
+func auditLogPath() string {
+	if path := os.Getenv("PILOT_AUDIT_LOG"); path != "" {
+		return path
+	}
+	return defaultAuditLog
+}
+
+// parseTime parses RFC3339 time or a duration before now, empty string is zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither time nor duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
@@ -1,30 +1,105 @@
 package main
 
 import (
-	"context"
+	"fmt"
 	"github.com/SMerrony/tello"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/einherij/enterprise"
 	"github.com/einherij/enterprise/utils"
+	"github.com/einherij/pilot/pkg/audit"
 	"github.com/einherij/pilot/pkg/controller"
 	"github.com/einherij/pilot/pkg/flymap"
 	"github.com/einherij/pilot/pkg/flymap/flysend"
 	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/operator"
+	"github.com/einherij/pilot/pkg/photo"
+	"github.com/einherij/pilot/pkg/recorder"
+	"github.com/einherij/pilot/pkg/telemetry"
 	"github.com/einherij/pilot/pkg/videosender"
+	"github.com/einherij/pilot/pkg/vision"
+	"github.com/einherij/pilot/pkg/webui"
 	"github.com/einherij/pilot/pkg/wsclient"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "audit":
+			err = auditCommand(os.Args[2:])
+		case "vision":
+			err = visionCommand(os.Args[2:])
+		case "map":
+			err = mapCommand(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
 	handlerHostURL := os.Getenv("HANDLER_HOST_URL")
+	uiAddr := os.Getenv("PILOT_UI_ADDR")
+	wsAddr := os.Getenv("PILOT_WS_ADDR")
+	serverMode := os.Getenv("PILOT_WS_MODE") == "server"
+	credentials := utils.Must(wsclient.ParseCredentials(os.Getenv("PILOT_OPERATORS")))
+	auth := wsclient.NewAuth(os.Getenv("PILOT_SECRET"), credentials...)
+	if !serverMode {
+		// operators' credentials alone would leave the connection to the handler server signed with an empty key
+		utils.PanicOnError(auth.CheckClient())
+	}
+	tlsConfig := utils.Must(wsclient.LoadTLSConfig(
+		os.Getenv("PILOT_TLS_CA"),
+		os.Getenv("PILOT_TLS_CERT"),
+		os.Getenv("PILOT_TLS_KEY"),
+		serverMode,
+	))
 
 	app := enterprise.NewApplication()
 
+	// embedded web ui, replaces the handler server when it isn't set
+	var ui *webui.Server
+	// commands without a session come from the handler server
+	legacyRole := operator.RoleAdmin
+	if uiAddr != "" {
+		ui = webui.New(uiAddr)
+		app.RegisterRunner(ui)
+		if handlerHostURL == "" {
+			handlerHostURL = ui.URL()
+			if !serverMode {
+				// the relay forwards unsigned messages of any browser reaching the page, so they can only watch
+				if auth != nil {
+					logrus.Fatalf("web ui relay can't sign commands, use PILOT_WS_MODE=server with PILOT_SECRET")
+				}
+				logrus.Warnf("web ui relay is for watching only, use PILOT_WS_MODE=server to fly from the web ui")
+				legacyRole = operator.RoleViewer
+			}
+		}
+	}
+
 	// connect to interface
-	wsClient := wsclient.New(handlerHostURL)
-	app.RegisterRunner(wsClient)
+	var wsClient wsclient.Messenger
+	if serverMode {
+		// accept UI connections instead of dialing the handler server
+		wsServer := wsclient.NewServer(wsAddr, auth, tlsConfig)
+		if ui != nil {
+			ui.HandleUI(wsServer)
+		}
+		app.RegisterRunner(wsServer)
+		wsClient = wsServer
+	} else {
+		client := wsclient.New(handlerHostURL, auth, tlsConfig)
+		app.RegisterRunner(client)
+		wsClient = client
+	}
 
 	d := new(tello.Tello)
 
@@ -35,29 +110,76 @@ func main() {
 	})
 
 	// Video
-	videoStream := utils.Must(d.VideoConnectDefault())
+	droneVideo := utils.Must(d.VideoConnectDefault())
 	app.RegisterOnShutdown(func() {
 		d.VideoDisconnect()
 		logrus.Warnf("video disconnected")
 	})
-	d.SetVideoWide()
+	// every consumer of the video gets its own subscription, key frames are requested when consumers need them
+	videos := videosender.NewBroadcaster(droneVideo, d.GetVideoSpsPps)
+	app.RegisterRunner(videos)
+	videoStats := videos.Stats()
+	app.RegisterRunner(videosender.NewStatsPublisher(wsClient, videoStats, 0))
+	if ui != nil {
+		ui.HandleMetrics(videoStats)
+	}
+	if metricsAddr := os.Getenv("PILOT_METRICS_ADDR"); metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, videoStats); err != nil {
+				logrus.Error(fmt.Errorf("error serving metrics: %w", err))
+			}
+		}()
+	}
 	d.SetSportsMode(true)
 
-	// send video key frames
-	app.RegisterRunner(runnerFunc(func(ctx context.Context) {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(500 * time.Millisecond):
-				d.GetVideoSpsPps()
-			}
+	videoStream := videos.Subscribe(0).Frames()
+	var (
+		videoSender  enterprise.Runner
+		videoSignals func(wsclient.Message) // WebRTC negotiation, handled by the controller's loop
+		encoders     []videosender.Encoder  // restarted when video settings change
+	)
+	switch transport := os.Getenv("PILOT_VIDEO_TRANSPORT"); transport {
+	case "webrtc":
+		webRTC := utils.Must(videosender.NewWebRTC(wsClient, videoStream, splitList(os.Getenv("PILOT_WEBRTC_ICE"))))
+		webRTC.OnKeyFrameNeeded(videos.RequestKeyFrame)
+		videoSignals = webRTC.HandleSignal
+		videoSender = webRTC
+		if ui != nil {
+			ui.HandleVideo(transport, nil)
 		}
-	}))
-
-	videoSender := videosender.New(handlerHostURL, videoStream, videosender.StreamPipe, false)
+	case "h264", "mjpeg":
+		// served by the web ui, or on its own address for other pages
+		streamServer := videosender.NewStreamServer(os.Getenv("PILOT_VIDEO_ADDR"), videoStream, videosender.Format(transport))
+		streamServer.OnKeyFrameNeeded(videos.RequestKeyFrame)
+		if ui != nil {
+			ui.HandleVideo(transport, streamServer)
+		}
+		if transport == "mjpeg" {
+			app.RegisterRunner(videosender.NewHealthPublisher(wsClient, streamServer, 0))
+			encoders = append(encoders, streamServer)
+		}
+		videoSender = streamServer
+	default:
+		// the stream is packaged without transcoding, ffmpeg is a fallback for handlers needing other formats
+		if os.Getenv("PILOT_VIDEO_ENCODER") == "ffmpeg" {
+			ffmpeg := videosender.New(handlerHostURL, videoStream, videosender.StreamPipe, false)
+			ffmpeg.OnKeyFrameNeeded(videos.RequestKeyFrame)
+			app.RegisterRunner(videosender.NewHealthPublisher(wsClient, ffmpeg, 0))
+			encoders = append(encoders, ffmpeg)
+			videoSender = ffmpeg
+		} else {
+			packager := videosender.NewPackager(handlerHostURL, videoStream, 0)
+			packager.ReportTo(videoStats)
+			packager.OnKeyFrameNeeded(videos.RequestKeyFrame)
+			videoSender = packager
+		}
+	}
 	app.RegisterRunner(videoSender)
 
+	// camera and encoder settings changed by operators
+	videoTuner := videosender.NewTuner(wsClient, d, videosender.DefaultSettings, 0, encoders...)
+	app.RegisterRunner(videoTuner)
+
 	// FlightData
 	fdStream := utils.Must(d.StreamFlightData(false, 100))
 
@@ -65,22 +187,162 @@ func main() {
 	nav := navigator.NewNavigator(fdStream)
 	app.RegisterRunner(nav)
 
-	// map
-	flyMap := flymap.New("FlyMap", "map.mtl")
-	app.RegisterOnShutdown(func() { _ = flymap.SaveMap("./maps/map.obj", flyMap) })
+	// markers at known positions of the map correct the drift of positions measured by the drone
+	if markersPath := os.Getenv("PILOT_VISION_MARKERS"); markersPath != "" {
+		markers := utils.Must(vision.LoadMarkers(markersPath))
+		app.RegisterRunner(vision.New(
+			videos,
+			nav,
+			vision.NewDecoder(vision.DefaultWidth, vision.DefaultHeight, vision.DefaultFPS),
+			vision.NewLocator(visionFOV(), markers),
+			0,
+		))
+	}
+
+	// maps of previous flights, one per room, the active one is aligned with the current flight by operators
+	maps := utils.Must(flymap.OpenStore(mapsDir(), os.Getenv("PILOT_MAP")))
+	app.RegisterOnShutdown(func() { _ = maps.Save() })
+	flyMap := maps.Map()
+	aligner := flymap.NewAligner(flyMap, nav)
+	mapRecorder := flymap.NewRecorder(flyMap, nav, 0, recordOptions(), os.Getenv("PILOT_MAP_RECORD") == "auto")
+	app.RegisterRunner(mapRecorder)
+	maps.OnSwitch(func(string) {
+		// checkpoints of the previous map mean nothing in the new one
+		aligner.Reset()
+		_ = mapRecorder.Branch(0)
+	})
+	planner := flymap.NewPlanner(flyMap, geofence(), planOptions())
 
 	mapSender := flysend.New(wsClient, flyMap, nav)
 	app.RegisterRunner(mapSender)
 
-	cmdHandler := controller.New(wsClient, d, flyMap)
+	// flight recordings, started by operators or on take off
+	recordings := recorder.New(
+		envOr("PILOT_RECORDINGS_DIR", "./recordings"),
+		videos,
+		nav,
+		0,
+		os.Getenv("PILOT_RECORD") == "auto",
+	)
+	app.RegisterRunner(recordings)
+
+	// photos tagged with the pose, uploaded to the handler server if asked
+	var photoUploadURL string
+	if os.Getenv("PILOT_PHOTO_UPLOAD") == "true" {
+		photoUploadURL = handlerHostURL
+	}
+	photos := photo.New(envOr("PILOT_PHOTOS_DIR", "./photos"), d, nav, wsClient, photoUploadURL)
+
+	// telemetry dashboard
+	telemetryPublisher := telemetry.New(wsClient, nav, telemetryPeriod(os.Getenv("TELEMETRY_RATE_HZ")))
+	app.RegisterRunner(telemetryPublisher)
+
+	auditLog := utils.Must(audit.Open(auditLogPath()))
+	app.RegisterOnShutdown(func() { _ = auditLog.Close() })
+
+	// commands of a handler server without sessions support are executed with admin role
+	arbiter := operator.NewArbiter(legacyRole)
+	cmdHandler := controller.New(wsClient, d, nav, flyMap, mapRecorder, planner, arbiter, auditLog)
+	cmdHandler.Command("rec", operator.PermControl, recordings.Command)
+	cmdHandler.Command("photo", operator.PermControl, photos.Command)
+	cmdHandler.Command("video", operator.PermSettings, videoTuner.Command)
+	cmdHandler.Command("align", operator.PermMapEdit, aligner.Command)
+	cmdHandler.Command("autorec", operator.PermMapEdit, mapRecorder.Command)
+	cmdHandler.Command("branch", operator.PermMapEdit, mapRecorder.BranchCommand)
+	cmdHandler.Command("obstacle", operator.PermMapEdit, flyMap.ObstacleCommand)
+	cmdHandler.Command("map", operator.PermMapEdit, maps.Command)
+	if os.Getenv("PILOT_PHOTO_WAYPOINTS") == "true" {
+		cmdHandler.OnArrival(func(target string) {
+			if err := photos.Trigger(target); err != nil {
+				logrus.Warnf("photo at %s isn't taken: %v", target, err)
+			}
+		})
+	}
+	if videoSignals != nil {
+		cmdHandler.Handle(wsclient.MTWebRTC, videoSignals)
+	}
 	app.RegisterRunner(cmdHandler)
 
 	app.Run()
 }
 
-// TODO: add runnerFunc to enterprise
-type runnerFunc func(ctx context.Context)
+// telemetryPeriod converts the rate in Hz to the publishing period, zero means the default one.
+func telemetryPeriod(rateHz string) time.Duration {
+	if rateHz == "" {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(rateHz, 64)
+	if err != nil || rate <= 0 {
+		logrus.Warnf("wrong telemetry rate %q, using default", rateHz)
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// recordOptions returns the default options of the map recorder with distances set by the environment.
+func recordOptions() flymap.RecordOptions {
+	options := flymap.DefaultRecordOptions
+	for key, option := range map[string]*float64{
+		"PILOT_MAP_LINK_RADIUS":    &options.LinkRadius,
+		"PILOT_MAP_MERGE_DISTANCE": &options.MergeDistance,
+	} {
+		s := os.Getenv(key)
+		if s == "" {
+			continue
+		}
+		distance, err := strconv.ParseFloat(s, 64)
+		if err != nil || distance < 0 {
+			logrus.Warnf("wrong %s %q, using default", key, s)
+			continue
+		}
+		*option = distance
+	}
+	return options
+}
 
-func (r runnerFunc) Run(ctx context.Context) {
-	r(ctx)
+// geofence returns the bounds set by the environment, nil means the area around the map.
+func geofence() *flymap.Bounds {
+	s := os.Getenv("PILOT_GEOFENCE")
+	if s == "" {
+		return nil
+	}
+	bounds, err := flymap.ParseBounds(s)
+	if err != nil {
+		logrus.Warnf("wrong PILOT_GEOFENCE %q, flying around the map: %v", s, err)
+		return nil
+	}
+	return &bounds
+}
+
+// planOptions returns the default options of the path planner with the clearance set by the environment.
+func planOptions() flymap.PlanOptions {
+	options := flymap.DefaultPlanOptions
+	if s := os.Getenv("PILOT_PLAN_CLEARANCE"); s != "" {
+		clearance, err := strconv.ParseFloat(s, 64)
+		if err != nil || clearance < 0 {
+			logrus.Warnf("wrong PILOT_PLAN_CLEARANCE %q, using default", s)
+		} else {
+			options.Clearance = clearance
+		}
+	}
+	return options
+}
+
+// envOr returns the environment variable or the default value if it's empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList splits comma separated values, skipping empty ones.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
 }
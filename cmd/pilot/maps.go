@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/einherij/pilot/pkg/flymap"
+)
+
+const defaultMapsDir = "./maps"
+
+// mapCommand manages the maps like the "map" command of operators, e.g. "pilot map list" or
+// "pilot map load kitchen" to fly the kitchen map on the next start.
+func mapCommand(args []string) error {
+	fs := flag.NewFlagSet("map", flag.ExitOnError)
+	dir := fs.String("dir", mapsDir(), "directory of maps")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: pilot map list|save-as <name>|load <name>|new <name>|delete <name>")
+	}
+
+	store, err := flymap.OpenStore(*dir, os.Getenv("PILOT_MAP"))
+	if err != nil {
+		return err
+	}
+	info, err := store.Command(strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+	fmt.Println(info)
+	return nil
+}
+
+func mapsDir() string {
+	if dir := os.Getenv("PILOT_MAPS_DIR"); dir != "" {
+		return dir
+	}
+	return defaultMapsDir
+}
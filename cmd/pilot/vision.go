@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/vision"
+)
+
+// visionCommand prints locations measured by markers in a recorded video, e.g.
+// "pilot vision -markers markers.json recordings/flight-20230101-120000.h264".
+func visionCommand(args []string) error {
+	fs := flag.NewFlagSet("vision", flag.ExitOnError)
+	markersPath := fs.String("markers", os.Getenv("PILOT_VISION_MARKERS"), "JSON file of markers")
+	fov := fs.Float64("fov", visionFOV(), "horizontal field of view of the camera, degrees")
+	fps := fs.Int("fps", vision.DefaultFPS, "frames per second to look for markers in")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *markersPath == "" {
+		return fmt.Errorf("usage: pilot vision -markers markers.json video.h264")
+	}
+
+	markers, err := vision.LoadMarkers(*markersPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error opening video: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	locator := vision.NewLocator(*fov, markers)
+	decoder := vision.NewDecoder(vision.DefaultWidth, vision.DefaultHeight, *fps)
+	enc := json.NewEncoder(os.Stdout)
+	frame := 0
+	return decoder.Decode(context.Background(), f, func(img *image.Gray) {
+		defer func() { frame++ }()
+		loc, ok := locator.Locate(img)
+		if !ok {
+			return
+		}
+		err := enc.Encode(struct {
+			Frame   int     `json:"frame"`
+			Seconds float64 `json:"seconds"`
+			vision.Location
+		}{Frame: frame, Seconds: float64(frame) / float64(*fps), Location: loc})
+		if err != nil {
+			logrus.Error(fmt.Errorf("error writing location: %w", err))
+		}
+	})
+}
+
+// visionFOV returns the field of view of the camera set by PILOT_VISION_FOV, zero means the default one.
+func visionFOV() float64 {
+	s := os.Getenv("PILOT_VISION_FOV")
+	if s == "" {
+		return 0
+	}
+	fov, err := strconv.ParseFloat(s, 64)
+	if err != nil || fov <= 0 || fov >= 180 {
+		logrus.Warnf("wrong field of view %q, using default", s)
+		return 0
+	}
+	return fov
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SMerrony/tello"
+	"github.com/sirupsen/logrus"
+)
+
+type Kind string
+
+const (
+	KindCommand    Kind = "command"    // command received from an operator
+	KindControl    Kind = "control"    // control request of an operator
+	KindAutonomous Kind = "autonomous" // action the pilot took on its own, e.g. autoflight step
+)
+
+// Outcomes of the recorded actions, errors are recorded as "error: ...".
+const (
+	OutcomeExecuted = "executed"
+	OutcomeRejected = "rejected"
+)
+
+// Record is a single line of the audit log.
+type Record struct {
+	Time     time.Time
+	Kind     Kind
+	Session  string `json:",omitempty"`
+	Operator string `json:",omitempty"`
+	Role     string `json:",omitempty"`
+	Command  string `json:",omitempty"` // raw command
+	Action   string // decoded action
+	State    State
+	Outcome  string
+}
+
+// State of the drone when the action was executed.
+type State struct {
+	X, Y, Z  float32 // MVO position
+	Yaw      int16
+	Height   int16 // decimetres
+	Battery  int8
+	Flying   bool
+	OnGround bool
+}
+
+func NewState(fd tello.FlightData) State {
+	return State{
+		X:        fd.MVO.PositionX,
+		Y:        fd.MVO.PositionY,
+		Z:        fd.MVO.PositionZ,
+		Yaw:      fd.IMU.Yaw,
+		Height:   fd.Height,
+		Battery:  fd.BatteryPercentage,
+		Flying:   fd.Flying,
+		OnGround: fd.OnGround,
+	}
+}
+
+// Log is an append-only JSON lines audit log, nil Log doesn't record anything.
+type Log struct {
+	mux sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	return &Log{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Write appends the record, the time is set if it's zero.
+func (l *Log) Write(r Record) {
+	if l == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if err := l.enc.Encode(r); err != nil {
+		logrus.Error(fmt.Errorf("error writing audit log: %w", err))
+	}
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.f.Close()
+}
+
+// Query reads records with time in [from, to) range, zero from or to aren't limiting.
+func Query(src io.Reader, from, to time.Time, f func(Record) error) error {
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			logrus.Warnf("broken audit record on line %d", line)
+			continue
+		}
+		if !from.IsZero() && r.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !r.Time.Before(to) {
+			continue
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+	return nil
+}
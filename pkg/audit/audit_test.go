@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AuditSuite struct {
+	suite.Suite
+}
+
+func TestAuditSuite(t *testing.T) {
+	suite.Run(t, new(AuditSuite))
+}
+
+func (s *AuditSuite) TestWriteAndQuery() {
+	path := filepath.Join(s.T().TempDir(), "audit.jsonl")
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	log, err := Open(path)
+	s.Require().NoError(err)
+	for i, action := range []string{"take off", "forward", "land"} {
+		log.Write(Record{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Kind:    KindCommand,
+			Action:  action,
+			Outcome: OutcomeExecuted,
+		})
+	}
+	s.NoError(log.Close())
+
+	log, err = Open(path)
+	s.Require().NoError(err)
+	log.Write(Record{Time: start.Add(3 * time.Minute), Kind: KindAutonomous, Action: "autoflight"})
+	s.NoError(log.Close())
+
+	f, err := os.Open(path)
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+	var actions []string
+	s.NoError(Query(f, start.Add(time.Minute), start.Add(3*time.Minute), func(r Record) error {
+		actions = append(actions, r.Action)
+		return nil
+	}))
+	s.Equal([]string{"forward", "land"}, actions)
+
+	_, _ = f.Seek(0, 0)
+	var count int
+	s.NoError(Query(f, time.Time{}, time.Time{}, func(Record) error { count++; return nil }))
+	s.Equal(4, count, "appended to the existing log")
+}
+
+func (s *AuditSuite) TestNilLog() {
+	var log *Log
+	log.Write(Record{Action: "nothing"})
+	s.NoError(log.Close())
+}
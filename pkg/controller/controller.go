@@ -2,33 +2,112 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/SMerrony/tello"
+	"github.com/einherij/pilot/pkg/audit"
 	"github.com/einherij/pilot/pkg/flymap"
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/operator"
 	"github.com/einherij/pilot/pkg/vector"
 	"github.com/einherij/pilot/pkg/wsclient"
 	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
 )
 
+// Frame maps positions measured by the drone to the map, e.g. navigator.Navigator corrected by vision.
+type Frame interface {
+	Transform() navigator.Transform
+}
+
+// Autopilot flies the drone to positions measured by it, e.g. *tello.Tello. A leg is done when its channel
+// receives, a cancelled leg stops the drone first.
+type Autopilot interface {
+	AutoFlyToXY(x, y float32) (chan bool, error)
+	AutoFlyToHeight(dm int16) (chan bool, error)
+	CancelAutoFlyToXY()
+	CancelAutoFlyToHeight()
+}
+
 type Controller struct {
-	wsClient *wsclient.Client
-	drone    *tello.Tello
-	flyMap   *flymap.FlyMap
+	wsClient  wsclient.Messenger
+	drone     *tello.Tello
+	autopilot Autopilot
+	frame     Frame
+	flyMap    *flymap.FlyMap
+	recorder  *flymap.Recorder
+	planner   *flymap.Planner
+	arbiter   *operator.Arbiter
+	audit     *audit.Log
+	handlers  map[wsclient.MessageType]func(wsclient.Message)
+	commands  map[string]command
+	arrived   []func(target string)
+
+	// accessed only from Run
+	home     vector.V3D // measured by the drone, the map may be aligned after it's set
+	homeYaw  int16
+	planning context.CancelFunc // of the path being planned or flown, nil if there is none
+	planned  chan struct{}      // closed when the path is flown or the planning stops
 }
 
-func New(wsClient *wsclient.Client, drone *tello.Tello, flyMap *flymap.FlyMap) *Controller {
-	return &Controller{
-		wsClient: wsClient,
-		drone:    drone,
-		flyMap:   flyMap,
+func New(
+	wsClient wsclient.Messenger,
+	drone *tello.Tello,
+	frame Frame,
+	flyMap *flymap.FlyMap,
+	recorder *flymap.Recorder,
+	planner *flymap.Planner,
+	arbiter *operator.Arbiter,
+	auditLog *audit.Log,
+) *Controller {
+	h := &Controller{
+		wsClient:  wsClient,
+		drone:     drone,
+		autopilot: drone,
+		frame:     frame,
+		flyMap:    flyMap,
+		recorder:  recorder,
+		planner:   planner,
+		arbiter:   arbiter,
+		audit:     auditLog,
+		handlers:  make(map[wsclient.MessageType]func(wsclient.Message)),
+		commands:  make(map[string]command),
 	}
+	h.Command("goto", operator.PermAutoFly, h.gotoCommand)
+	return h
+}
+
+// CommandFunc executes a registered command, args is the rest of the command after its name.
+// The returned info is logged for operators, errors are reported as the command's outcome.
+type CommandFunc func(args string) (info string, err error)
+
+type command struct {
+	perm    operator.Permission
+	handler CommandFunc
+}
+
+// Handle makes messages of the type passed to the handler, e.g. WebRTC signaling to the video sender.
+// Handlers are called from Run and must be registered before it starts.
+func (h *Controller) Handle(t wsclient.MessageType, handler func(wsclient.Message)) {
+	h.handlers[t] = handler
+}
+
+// Command registers a command of other components, e.g. "rec start" of the recorder, the first word of
+// the command is its name. The command is authorized and audited like the built-in ones.
+// Commands are executed from Run and must be registered before it starts.
+func (h *Controller) Command(name string, perm operator.Permission, handler CommandFunc) {
+	h.commands[name] = command{perm: perm, handler: handler}
+}
+
+// OnArrival registers a function called when an autoflight reaches its target, e.g. "checkpoint 1",
+// to take a photo at every waypoint. It's called from the autoflight goroutine and must not block.
+func (h *Controller) OnArrival(f func(target string)) {
+	h.arrived = append(h.arrived, f)
 }
 
 func (h *Controller) Run(ctx context.Context) {
 	logrus.Warnf("started drone controller")
-	var home vector.V3D
-	var homeYaw int16
-	var lastCheckpoint int
 	for {
 		select {
 		case <-ctx.Done():
@@ -36,173 +115,474 @@ func (h *Controller) Run(ctx context.Context) {
 			return
 		default:
 			msg := h.wsClient.ReceiveMessage(ctx)
-			if msg.Type != wsclient.MTCmd {
-				continue
-			}
-			fd := h.drone.GetFlightData()
-			var info string
-			switch string(msg.Content) {
-			case "Dq":
-				info = "Started Turning Left"
-				h.drone.TurnLeft(100)
-			case "Uq":
-				info = "Stopped Turning Left"
-				h.drone.Hover()
-			case "De":
-				info = "Started Turning Right"
-				h.drone.TurnRight(100)
-			case "Ue":
-				info = "Stopped Turning Right"
-				h.drone.Hover()
-			case "Dw":
-				info = "Started Going Forward"
-				h.drone.Forward(100)
-			case "Uw":
-				info = "Stopped Going Forward"
-				h.drone.Hover()
-			case "Ds":
-				info = "Started Going Backward"
-				h.drone.Backward(100)
-			case "Us":
-				info = "Stopped Going Backward"
-				h.drone.Hover()
-			case "Da":
-				info = "Started Going Left"
-				h.drone.Left(100)
-			case "Ua":
-				info = "Stopped Going Left"
-				h.drone.Hover()
-			case "Dd":
-				info = "Started Going Right"
-				h.drone.Right(100)
-			case "Ud":
-				info = "Stopped Going Up"
-				h.drone.Hover()
-			case "Dr":
-				info = "Started Going Up"
-				h.drone.Up(100)
-			case "Ur":
-				info = "Stopped Going Up"
-				h.drone.Hover()
-			case "Df":
-				info = "Started Going Down"
-				h.drone.Down(100)
-			case "Uf":
-				info = "Stopped Going Down"
-				h.drone.Hover()
-			case "Du":
-				info = "Started Take Off"
-				h.drone.TakeOff()
-			case "Dl":
-				info = "Started Land"
-				h.drone.Land()
-			case "Uh":
-				info = "Home set"
-				if err := h.drone.SetHome(); err != nil {
-					logrus.Error(err)
-				}
-				home = vector.V3D{
-					float64(fd.MVO.PositionX),
-					float64(fd.MVO.PositionY),
-					float64(fd.MVO.PositionZ),
-				}
-				homeYaw = fd.IMU.Yaw
-			case "U0":
-				h.autoFlyTo(home, home, homeYaw)
-			case "Un":
-				fd := h.drone.GetFlightData()
-				id := h.flyMap.AddCheckpoint(
-					float64(fd.MVO.PositionX),
-					float64(fd.MVO.PositionY),
-					float64(fd.MVO.PositionZ),
-				)
-				if lastCheckpoint != 0 {
-					h.flyMap.LinkCheckpoint(lastCheckpoint, id)
-				}
-				lastCheckpoint = id
-			case "U1":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(1), home, homeYaw)
-			case "U2":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(2), home, homeYaw)
-			case "U3":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(3), home, homeYaw)
-			case "U4":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(4), home, homeYaw)
-			case "U5":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(5), home, homeYaw)
-			case "U6":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(6), home, homeYaw)
-			case "U7":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(7), home, homeYaw)
-			case "U8":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(8), home, homeYaw)
-			case "U9":
-				h.autoFlyTo(h.flyMap.GetCheckpoint(9), home, homeYaw)
+			switch msg.Type {
+			case wsclient.MTSession:
+				h.handleSession(msg)
+			case wsclient.MTControl:
+				h.handleControl(msg)
+			case wsclient.MTCmd:
+				h.handleCommand(msg)
 			default:
-				info = string(msg.Content)
+				if handler, ok := h.handlers[msg.Type]; ok {
+					handler(msg)
+				}
 			}
+		}
+	}
+}
 
-			info += fmt.Sprintf(" BatPrc: %d; LgtStr: %d", fd.BatteryPercentage, fd.LightStrength)
-			if fd.BatteryLow {
-				info += " BatteryLow"
-			}
-			if fd.BatteryCritical {
-				info += " BatteryCritical"
-			}
-			if fd.DownVisualState {
-				info += " DownVisualState"
-			}
-			if fd.ErrorState {
-				info += " ErrorState"
-			}
+func (h *Controller) handleSession(msg wsclient.Message) {
+	var event operator.Event
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		logrus.Warnf("broken session message")
+		return
+	}
+	switch {
+	case event.Reset:
+		h.arbiter.Reset()
+	case event.Left:
+		if h.arbiter.Leave(event.ID) {
+			h.sendControl()
+		}
+	default:
+		h.arbiter.Join(event.Session)
+		controller, _ := h.arbiter.Controller()
+		h.wsClient.SendMessage(wsclient.Message{
+			Type:    wsclient.MTControl,
+			Session: event.ID,
+			Content: []byte(controller),
+		})
+	}
+}
 
-			h.wsClient.SendMessage(wsclient.Message{
-				Type:    wsclient.MTLog,
-				Content: []byte("Command " + info),
-			})
+func (h *Controller) handleControl(msg wsclient.Message) {
+	request := string(msg.Content)
+	switch request {
+	case "take", "force":
+		if err := h.arbiter.TakeControl(msg.Session, request == "force"); err != nil {
+			h.reply(msg.Session, "Control isn't taken: "+err.Error())
+			h.record(audit.KindControl, msg.Session, request, "Take control", audit.OutcomeRejected+": "+err.Error())
+			return
+		}
+		h.record(audit.KindControl, msg.Session, request, "Take control", audit.OutcomeExecuted)
+	case "release":
+		if !h.arbiter.ReleaseControl(msg.Session) {
+			return
 		}
+		h.record(audit.KindControl, msg.Session, request, "Release control", audit.OutcomeExecuted)
+	default:
+		logrus.Warnf("unknown control request %q", request)
+		return
 	}
+	h.sendControl()
+}
+
+// sendControl tells every session which one holds control.
+func (h *Controller) sendControl() {
+	controller, _ := h.arbiter.Controller()
+	h.wsClient.SendMessage(wsclient.Message{
+		Type:    wsclient.MTControl,
+		Content: []byte(controller),
+	})
 }
 
-func (h *Controller) autoFlyTo(p vector.V3D, home vector.V3D, homeYaw int16) {
-	p = p.Sub(home)
+// reply sends the log message to the session only.
+func (h *Controller) reply(session, text string) {
 	h.wsClient.SendMessage(wsclient.Message{
 		Type:    wsclient.MTLog,
-		Content: []byte("Going home XY"),
+		Session: session,
+		Content: []byte(text),
 	})
+}
+
+// commandPermission returns the permission required to execute the command.
+func commandPermission(cmd string) operator.Permission {
+	switch cmd {
+	case "Du", "Dl":
+		return operator.PermTakeOff
+	case "Un":
+		return operator.PermMapEdit
+	case "Uh", "U0", "U1", "U2", "U3", "U4", "U5", "U6", "U7", "U8", "U9":
+		return operator.PermAutoFly
+	default:
+		return operator.PermFly
+	}
+}
+
+func (h *Controller) handleCommand(msg wsclient.Message) {
+	name, args, _ := strings.Cut(string(msg.Content), " ")
+	registered, isRegistered := h.commands[name]
+	perm := commandPermission(string(msg.Content))
+	if isRegistered {
+		perm = registered.perm
+	}
+	if err := h.arbiter.Authorize(msg.Session, perm); err != nil {
+		h.reply(msg.Session, "Command "+string(msg.Content)+" rejected: "+err.Error())
+		h.record(audit.KindCommand, msg.Session, string(msg.Content), "", audit.OutcomeRejected+": "+err.Error())
+		return
+	}
+	switch perm {
+	case operator.PermFly, operator.PermTakeOff, operator.PermAutoFly:
+		// the operator takes over the planned flight
+		h.cancelPlanning()
+	}
+
+	fd := h.drone.GetFlightData()
+	var info string
+	outcome := audit.OutcomeExecuted
+	switch string(msg.Content) {
+	case "Dq":
+		info = "Started Turning Left"
+		h.drone.TurnLeft(100)
+	case "Uq":
+		info = "Stopped Turning Left"
+		h.drone.Hover()
+	case "De":
+		info = "Started Turning Right"
+		h.drone.TurnRight(100)
+	case "Ue":
+		info = "Stopped Turning Right"
+		h.drone.Hover()
+	case "Dw":
+		info = "Started Going Forward"
+		h.drone.Forward(100)
+	case "Uw":
+		info = "Stopped Going Forward"
+		h.drone.Hover()
+	case "Ds":
+		info = "Started Going Backward"
+		h.drone.Backward(100)
+	case "Us":
+		info = "Stopped Going Backward"
+		h.drone.Hover()
+	case "Da":
+		info = "Started Going Left"
+		h.drone.Left(100)
+	case "Ua":
+		info = "Stopped Going Left"
+		h.drone.Hover()
+	case "Dd":
+		info = "Started Going Right"
+		h.drone.Right(100)
+	case "Ud":
+		info = "Stopped Going Up"
+		h.drone.Hover()
+	case "Dr":
+		info = "Started Going Up"
+		h.drone.Up(100)
+	case "Ur":
+		info = "Stopped Going Up"
+		h.drone.Hover()
+	case "Df":
+		info = "Started Going Down"
+		h.drone.Down(100)
+	case "Uf":
+		info = "Stopped Going Down"
+		h.drone.Hover()
+	case "Du":
+		info = "Started Take Off"
+		h.drone.TakeOff()
+	case "Dl":
+		info = "Started Land"
+		h.drone.Land()
+	case "Uh":
+		info = "Home set"
+		if err := h.drone.SetHome(); err != nil {
+			logrus.Error(err)
+			outcome = "error: " + err.Error()
+		}
+		h.home = vector.V3D{
+			float64(fd.MVO.PositionX),
+			float64(fd.MVO.PositionY),
+			float64(fd.MVO.PositionZ),
+		}
+		h.homeYaw = fd.IMU.Yaw
+	case "U0":
+		info = "Autoflight to home"
+		outcome = h.autoFlyTo("home", h.frame.Transform().Apply(h.home), h.home, h.homeYaw)
+	case "Un":
+		if id, added := h.recorder.Add(h.currentPos()); added {
+			info = fmt.Sprintf("Checkpoint %d added", id)
+		} else {
+			info = fmt.Sprintf("Checkpoint %d reached", id)
+		}
+	case "U1":
+		info = "Autoflight to checkpoint 1"
+		outcome = h.autoFlyToCheckpoint(1)
+	case "U2":
+		info = "Autoflight to checkpoint 2"
+		outcome = h.autoFlyToCheckpoint(2)
+	case "U3":
+		info = "Autoflight to checkpoint 3"
+		outcome = h.autoFlyToCheckpoint(3)
+	case "U4":
+		info = "Autoflight to checkpoint 4"
+		outcome = h.autoFlyToCheckpoint(4)
+	case "U5":
+		info = "Autoflight to checkpoint 5"
+		outcome = h.autoFlyToCheckpoint(5)
+	case "U6":
+		info = "Autoflight to checkpoint 6"
+		outcome = h.autoFlyToCheckpoint(6)
+	case "U7":
+		info = "Autoflight to checkpoint 7"
+		outcome = h.autoFlyToCheckpoint(7)
+	case "U8":
+		info = "Autoflight to checkpoint 8"
+		outcome = h.autoFlyToCheckpoint(8)
+	case "U9":
+		info = "Autoflight to checkpoint 9"
+		outcome = h.autoFlyToCheckpoint(9)
+	default:
+		info = string(msg.Content)
+		if isRegistered {
+			var err error
+			if info, err = registered.handler(args); err != nil {
+				logrus.Error(fmt.Errorf("error executing %s: %w", msg.Content, err))
+				info = fmt.Sprintf("%s failed: %v", msg.Content, err)
+				outcome = "error: " + err.Error()
+			}
+		}
+	}
+	h.record(audit.KindCommand, msg.Session, string(msg.Content), info, outcome)
+
+	info += fmt.Sprintf(" BatPrc: %d; LgtStr: %d", fd.BatteryPercentage, fd.LightStrength)
+	if fd.BatteryLow {
+		info += " BatteryLow"
+	}
+	if fd.BatteryCritical {
+		info += " BatteryCritical"
+	}
+	if fd.DownVisualState {
+		info += " DownVisualState"
+	}
+	if fd.ErrorState {
+		info += " ErrorState"
+	}
+
+	h.wsClient.SendMessage(wsclient.Message{
+		Type:    wsclient.MTLog,
+		Content: []byte("Command " + info),
+	})
+	h.wsClient.SendMessage(wsclient.Message{
+		Type:    wsclient.MTAck,
+		Session: msg.Session,
+		Content: msg.Content,
+	})
+}
+
+// gotoCommand flies to the point of the map on operator's "goto x y z" command along the path planned
+// around obstacles.
+func (h *Controller) gotoCommand(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("goto needs x y z")
+	}
+	var p vector.V3D
+	for i, f := range fields {
+		var err error
+		if p[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return "", fmt.Errorf("wrong number %q", f)
+		}
+	}
+	target := fmt.Sprintf("point %.2f %.2f %.2f", p.X(), p.Y(), p.Z())
+	h.planAndFly(target, h.currentPos(), p, "")
+	return "Planning path to " + target, nil
+}
+
+// currentPos returns the position of the drone in the frame of the map.
+func (h *Controller) currentPos() vector.V3D {
+	fd := h.drone.GetFlightData()
+	return h.frame.Transform().Apply(vector.V3D{
+		float64(fd.MVO.PositionX),
+		float64(fd.MVO.PositionY),
+		float64(fd.MVO.PositionZ),
+	})
+}
+
+// toDrone returns the target of the drone's autopilot for the point of the map, the drone flies
+// relative to its home in its own frame.
+func (h *Controller) toDrone(p vector.V3D, home vector.V3D) vector.V3D {
+	return h.frame.Transform().Invert().Apply(p).Sub(home)
+}
+
+// autoFlyToCheckpoint starts the autoflight to the checkpoint of the map, it's rejected if the map has no such
+// checkpoint, e.g. after switching to another map.
+func (h *Controller) autoFlyToCheckpoint(id int) string {
+	target := fmt.Sprintf("checkpoint %d", id)
+	p, ok := h.flyMap.Checkpoint(id)
+	if !ok {
+		outcome := audit.OutcomeRejected + ": " + target + " isn't found"
+		h.autoStep("Autoflight to "+target+" rejected, the map has no such checkpoint", outcome)
+		return outcome
+	}
+	return h.autoFlyTo(target, p, h.home, h.homeYaw)
+}
+
+// autoFlyTo starts the autoflight to the target and returns the outcome of the command. The drone flies
+// horizontally and then vertically, if the path crosses an obstacle of the map the drone flies along
+// the path planned around obstacles, the flight is rejected if there is no such path.
+func (h *Controller) autoFlyTo(target string, p vector.V3D, home vector.V3D, homeYaw int16) string {
+	current := h.currentPos()
+	turn := vector.V3D{p.X(), p.Y(), current.Z()}
+	for _, segment := range [][2]vector.V3D{{current, turn}, {turn, p}} {
+		obstacle, blocked := h.flyMap.Blocked(segment[0], segment[1])
+		if !blocked {
+			continue
+		}
+		h.autoStep("Autoflight to "+target+" crosses obstacle "+obstacle+", planning path around it",
+			audit.OutcomeExecuted)
+		h.planAndFly(target, current, p, obstacle)
+		return audit.OutcomeExecuted
+	}
+
+	p = h.toDrone(p, home)
+	h.autoStep("Going home XY", audit.OutcomeExecuted)
 	doneXY, err := h.drone.AutoFlyToXY(float32(p.X()), float32(p.Y()))
 	if err != nil {
 		logrus.Error(err)
-		return
+		h.autoStep("Autoflight to XY", "error: "+err.Error())
+		return "error: " + err.Error()
 	}
 	go func() {
 		<-doneXY
-		h.wsClient.SendMessage(wsclient.Message{
-			Type:    wsclient.MTLog,
-			Content: []byte("Autoflight to XY done, Going home Yaw"),
-		})
+		h.autoStep("Autoflight to XY done, Going home Yaw", audit.OutcomeExecuted)
 		doneYaw, err := h.drone.AutoTurnToYaw(homeYaw)
 		if err != nil {
 			logrus.Error(err)
+			h.autoStep("Autoflight to home Yaw", "error: "+err.Error())
 			return
 		}
 		go func() {
 			<-doneYaw
-			h.wsClient.SendMessage(wsclient.Message{
-				Type:    wsclient.MTLog,
-				Content: []byte("Autoflight to home Yaw done, Going home Z"),
-			})
+			h.autoStep("Autoflight to home Yaw done, Going home Z", audit.OutcomeExecuted)
 			doneZ, err := h.drone.AutoFlyToHeight(int16(p.Z() / 10.))
 			if err != nil {
 				logrus.Error(err)
+				h.autoStep("Autoflight to Z", "error: "+err.Error())
 			}
 			go func() {
 				<-doneZ
-				h.wsClient.SendMessage(wsclient.Message{
-					Type:    wsclient.MTLog,
-					Content: []byte("Autoflight to Z done"),
-				})
+				h.autoStep("Autoflight to Z done", audit.OutcomeExecuted)
+				for _, f := range h.arrived {
+					f(target)
+				}
 			}()
 		}()
 	}()
+	return audit.OutcomeExecuted
+}
+
+// planAndFly plans the path to the target and flies it in a goroutine, so commands of operators, e.g. land,
+// are read while the path is searched. Commands moving the drone cancel the planning and the flight.
+// The obstacle is the one on the direct path, empty if it's unknown.
+func (h *Controller) planAndFly(target string, from, p vector.V3D, obstacle string) {
+	h.cancelPlanning()
+	ctx, cancel := context.WithCancel(context.Background())
+	planned := make(chan struct{})
+	h.planning, h.planned = cancel, planned
+	home := h.home
+	go func() {
+		defer close(planned)
+		waypoints, err := h.planner.Plan(ctx, from, p)
+		switch {
+		case ctx.Err() != nil:
+			h.autoStep("Planning path to "+target+" cancelled", audit.OutcomeRejected+": cancelled")
+			return
+		case err != nil && obstacle != "":
+			outcome := audit.OutcomeRejected + ": path to " + target + " crosses obstacle " + obstacle + ", " + err.Error()
+			h.autoStep("Autoflight to "+target+" rejected, the path crosses obstacle "+obstacle, outcome)
+			return
+		case err != nil:
+			h.autoStep("Autoflight to "+target+" rejected", audit.OutcomeRejected+": "+err.Error())
+			return
+		}
+		h.autoStep(fmt.Sprintf("Autoflight to %s by %d waypoints", target, len(waypoints)), audit.OutcomeExecuted)
+		h.flyPath(ctx, target, waypoints, home)
+	}()
+}
+
+// cancelPlanning cancels the path being planned or flown. It waits until the autopilot stops the drone,
+// so the autopilot doesn't override the command of the operator taking over, it takes a period of
+// the autopilot at most.
+func (h *Controller) cancelPlanning() {
+	if h.planning != nil {
+		h.planning()
+		<-h.planned
+		h.planning, h.planned = nil, nil
+	}
+}
+
+// flyPath flies through the waypoints of the map one by one, each of them horizontally and then vertically,
+// until the context is done, then the leg being flown is cancelled.
+func (h *Controller) flyPath(ctx context.Context, target string, waypoints []vector.V3D, home vector.V3D) {
+	for i, waypoint := range waypoints {
+		if ctx.Err() != nil {
+			h.autoStep("Autoflight to "+target+" cancelled", audit.OutcomeRejected+": cancelled")
+			return
+		}
+		step := fmt.Sprintf("waypoint %d/%d to %s", i+1, len(waypoints), target)
+		p := h.toDrone(waypoint, home)
+		h.autoStep("Going to "+step, audit.OutcomeExecuted)
+		doneXY, err := h.autopilot.AutoFlyToXY(float32(p.X()), float32(p.Y()))
+		if err != nil {
+			logrus.Error(fmt.Errorf("error flying to %s: %w", step, err))
+			h.autoStep("Autoflight to XY of "+step, "error: "+err.Error())
+			return
+		}
+		if !waitLeg(ctx, doneXY, h.autopilot.CancelAutoFlyToXY) {
+			h.autoStep("Autoflight to "+target+" cancelled at XY of "+step, audit.OutcomeRejected+": cancelled")
+			return
+		}
+		doneZ, err := h.autopilot.AutoFlyToHeight(int16(p.Z() / 10.))
+		if err != nil {
+			logrus.Error(fmt.Errorf("error flying to %s: %w", step, err))
+			h.autoStep("Autoflight to Z of "+step, "error: "+err.Error())
+			return
+		}
+		if !waitLeg(ctx, doneZ, h.autopilot.CancelAutoFlyToHeight) {
+			h.autoStep("Autoflight to "+target+" cancelled at Z of "+step, audit.OutcomeRejected+": cancelled")
+			return
+		}
+	}
+	h.autoStep("Autoflight to "+target+" done", audit.OutcomeExecuted)
+	for _, f := range h.arrived {
+		f(target)
+	}
+}
+
+// waitLeg waits until the leg of the autopilot is done, false if the context is done first, then the leg
+// is cancelled and the autopilot stops the drone.
+func waitLeg(ctx context.Context, done chan bool, cancel func()) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return false
+	}
+}
+
+// autoStep reports a step of an autonomous action to operators and the audit log.
+func (h *Controller) autoStep(action, outcome string) {
+	h.wsClient.SendMessage(wsclient.Message{
+		Type:    wsclient.MTLog,
+		Content: []byte(action),
+	})
+	h.record(audit.KindAutonomous, "", "", action, outcome)
+}
+
+func (h *Controller) record(kind audit.Kind, session, cmd, action, outcome string) {
+	s, _ := h.arbiter.Session(session)
+	h.audit.Write(audit.Record{
+		Kind:     kind,
+		Session:  session,
+		Operator: s.Operator,
+		Role:     string(s.Role),
+		Command:  cmd,
+		Action:   action,
+		State:    audit.NewState(h.drone.GetFlightData()),
+		Outcome:  outcome,
+	})
 }
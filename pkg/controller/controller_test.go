@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SMerrony/tello"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/flymap"
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/operator"
+	"github.com/einherij/pilot/pkg/vector"
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+type ControllerSuite struct {
+	suite.Suite
+
+	messenger  *testMessenger
+	autopilot  *testAutopilot
+	controller *Controller
+}
+
+func TestControllerSuite(t *testing.T) {
+	suite.Run(t, new(ControllerSuite))
+}
+
+func (s *ControllerSuite) SetupTest() {
+	flyMap := flymap.New("test", "map.mtl")
+	s.messenger = new(testMessenger)
+	s.autopilot = &testAutopilot{started: make(chan string, 4)}
+	s.controller = New(
+		s.messenger,
+		new(tello.Tello),
+		testFrame{},
+		flyMap,
+		nil,
+		flymap.NewPlanner(flyMap, nil, flymap.DefaultPlanOptions),
+		operator.NewArbiter(operator.RoleAdmin),
+		nil,
+	)
+	s.controller.autopilot = s.autopilot
+}
+
+func (s *ControllerSuite) TestCancelPlannedFlight() {
+	s.controller.planAndFly("checkpoint 1", vector.V3D{}, vector.V3D{2, 0, 10}, "")
+	select {
+	case leg := <-s.autopilot.started:
+		s.Equal("xy", leg)
+	case <-time.After(time.Second):
+		s.FailNow("leg isn't started")
+	}
+
+	// the operator takes over in the middle of the leg
+	s.controller.cancelPlanning()
+	s.True(s.autopilot.cancelled("xy"), "the leg is cancelled before the operator's command")
+	s.Len(s.autopilot.started, 0, "the next leg isn't started")
+	s.Contains(s.messenger.logs(), "Autoflight to checkpoint 1 cancelled at XY of waypoint 1/2 to checkpoint 1")
+}
+
+type testFrame struct{}
+
+func (testFrame) Transform() navigator.Transform {
+	return navigator.Transform{}
+}
+
+// testAutopilot starts legs that are done only when cancelled.
+type testAutopilot struct {
+	started chan string
+
+	mux  sync.Mutex
+	legs map[string]chan bool
+	done []string
+}
+
+func (a *testAutopilot) start(leg string) (chan bool, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.legs == nil {
+		a.legs = make(map[string]chan bool)
+	}
+	done := make(chan bool, 1)
+	a.legs[leg] = done
+	a.started <- leg
+	return done, nil
+}
+
+func (a *testAutopilot) cancel(leg string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if done, ok := a.legs[leg]; ok {
+		done <- true
+		delete(a.legs, leg)
+		a.done = append(a.done, leg)
+	}
+}
+
+func (a *testAutopilot) cancelled(leg string) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, l := range a.done {
+		if l == leg {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *testAutopilot) AutoFlyToXY(float32, float32) (chan bool, error) { return a.start("xy") }
+func (a *testAutopilot) AutoFlyToHeight(int16) (chan bool, error)        { return a.start("z") }
+func (a *testAutopilot) CancelAutoFlyToXY()                              { a.cancel("xy") }
+func (a *testAutopilot) CancelAutoFlyToHeight()                          { a.cancel("z") }
+
+type testMessenger struct {
+	mux      sync.Mutex
+	messages []wsclient.Message
+}
+
+func (m *testMessenger) SendMessage(message wsclient.Message) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.messages = append(m.messages, message)
+}
+
+func (m *testMessenger) ReceiveMessage(ctx context.Context) wsclient.Message {
+	<-ctx.Done()
+	return wsclient.Message{}
+}
+
+func (m *testMessenger) Accepts(wsclient.MessageType) bool {
+	return true
+}
+
+func (m *testMessenger) logs() string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	var logs []string
+	for _, msg := range m.messages {
+		if msg.Type == wsclient.MTLog {
+			logs = append(logs, string(msg.Content))
+		}
+	}
+	return strings.Join(logs, "\n")
+}
@@ -0,0 +1,141 @@
+package flymap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// minAlignSpread is the distance between aligned checkpoints needed to measure the rotation of the map,
+// closer checkpoints only move it.
+const minAlignSpread = 0.5
+
+// Frame maps positions measured by the drone to the map, e.g. navigator.Navigator.
+type Frame interface {
+	GetRawPos() navigator.Position
+	Transform() navigator.Transform
+	SetTransform(t navigator.Transform)
+}
+
+// Aligner aligns the frame of the drone with a map of a previous flight. Every flight starts measuring
+// from the take off point, so the operator hovers over known checkpoints and tells which ones they are,
+// the transform best matching the drone's positions to the checkpoints is applied to the frame.
+type Aligner struct {
+	flyMap *FlyMap
+	frame  Frame
+
+	mux   sync.Mutex
+	pairs []alignPair
+}
+
+// alignPair is a checkpoint of the map and the position measured by the drone over it.
+type alignPair struct {
+	id       int
+	measured vector.V3D
+	mapped   vector.V3D
+}
+
+func NewAligner(flyMap *FlyMap, frame Frame) *Aligner {
+	return &Aligner{
+		flyMap: flyMap,
+		frame:  frame,
+	}
+}
+
+// Command aligns the map on operator's "align" command: "align 3" when the drone is over checkpoint 3,
+// "align reset" to forget the checkpoints and the alignment. See controller.Controller.Command.
+func (a *Aligner) Command(args string) (string, error) {
+	args = strings.TrimSpace(args)
+	if args == "reset" {
+		a.Reset()
+		return "Map alignment reset", nil
+	}
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return "", fmt.Errorf("checkpoint isn't set")
+	}
+	t, n, rms, err := a.Align(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Map aligned by %d checkpoints: yaw %.1f, offset %.2f %.2f, error %.2f",
+		n, t.Yaw, t.Offset.X(), t.Offset.Y(), rms), nil
+}
+
+// Align adds the checkpoint the drone is over and applies the transform fitted to all added checkpoints.
+// It returns the transform, the number of checkpoints and the root mean square distance between them
+// and the aligned positions.
+func (a *Aligner) Align(id int) (navigator.Transform, int, float64, error) {
+	checkpoint, ok := a.flyMap.Checkpoint(id)
+	if !ok {
+		return navigator.Transform{}, 0, 0, fmt.Errorf("checkpoint %d isn't found", id)
+	}
+	measured := a.frame.GetRawPos().Location
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	// the drone is over the checkpoint again, the latest measurement is the one to trust
+	for i := 0; i < len(a.pairs); i++ {
+		if a.pairs[i].id == id {
+			a.pairs = append(a.pairs[:i], a.pairs[i+1:]...)
+			i--
+		}
+	}
+	a.pairs = append(a.pairs, alignPair{id: id, measured: measured, mapped: checkpoint})
+	measuredPoints := make([]vector.V3D, len(a.pairs))
+	mapPoints := make([]vector.V3D, len(a.pairs))
+	for i, p := range a.pairs {
+		measuredPoints[i], mapPoints[i] = p.measured, p.mapped
+	}
+	t := fitTransform(measuredPoints, mapPoints, a.frame.Transform().Yaw)
+	a.frame.SetTransform(t)
+
+	var sum float64
+	for i := range measuredPoints {
+		d := t.Apply(measuredPoints[i]).Sub(mapPoints[i])
+		sum += d.X()*d.X() + d.Y()*d.Y()
+	}
+	return t, len(a.pairs), math.Sqrt(sum / float64(len(a.pairs))), nil
+}
+
+// Reset forgets the checkpoints and returns the frame to the positions measured by the drone.
+func (a *Aligner) Reset() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.pairs = nil
+	a.frame.SetTransform(navigator.Transform{})
+}
+
+// fitTransform returns the rotation around Z and the translation in XY mapping the measured points to the map
+// points with the least squares. The rotation can't be measured by points close to each other, then yaw is kept.
+func fitTransform(measured, mapped []vector.V3D, yaw float64) navigator.Transform {
+	var cm, cp vector.V3D
+	for i := range measured {
+		cm = cm.Add(measured[i])
+		cp = cp.Add(mapped[i])
+	}
+	cm, cp = cm.Scale(1/float64(len(measured))), cp.Scale(1/float64(len(mapped)))
+
+	var dot, cross, spread float64
+	for i := range measured {
+		m, p := measured[i].Sub(cm), mapped[i].Sub(cp)
+		dot += m.X()*p.X() + m.Y()*p.Y()
+		cross += m.X()*p.Y() - m.Y()*p.X()
+		spread = math.Max(spread, math.Hypot(m.X(), m.Y()))
+	}
+	if 2*spread >= minAlignSpread {
+		yaw = math.Atan2(cross, dot) * 180 / math.Pi
+	}
+	rotated := cm.RotateZ(yaw)
+	return navigator.Transform{
+		Yaw:    yaw,
+		Offset: vector.V3D{cp.X() - rotated.X(), cp.Y() - rotated.Y(), 0},
+	}
+}
@@ -0,0 +1,64 @@
+package flymap
+
+import (
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+type frame struct {
+	raw       vector.V3D
+	transform navigator.Transform
+}
+
+func (f *frame) GetRawPos() navigator.Position {
+	return navigator.Position{Location: f.raw}
+}
+
+func (f *frame) Transform() navigator.Transform {
+	return f.transform
+}
+
+func (f *frame) SetTransform(t navigator.Transform) {
+	f.transform = t
+}
+
+func (s *MapSuite) TestAlign() {
+	m := New("FlyMap", "map.mtl")
+	m.AddCheckpoint(1, 0, 1)
+	m.AddCheckpoint(3, 0, 1)
+	m.AddCheckpoint(3, 2, 1)
+	// the drone measures from another take off point, turned by 30 degrees
+	truth := navigator.Transform{Yaw: 30, Offset: vector.V3D{2, -1, 0}}
+	f := new(frame)
+	a := NewAligner(m, f)
+
+	f.raw = truth.Invert().Apply(m.GetCheckpoint(1))
+	_, err := a.Command("1")
+	s.Require().NoError(err)
+	s.Zero(f.transform.Yaw, "one checkpoint can't measure the rotation")
+	s.InDelta(0, m.GetCheckpoint(1).Distance(f.transform.Apply(f.raw)), 1e-9)
+
+	f.raw = truth.Invert().Apply(m.GetCheckpoint(2))
+	_, n, rms, err := a.Align(2)
+	s.Require().NoError(err)
+	s.Equal(2, n)
+	s.InDelta(0, rms, 1e-9)
+	s.InDelta(truth.Yaw, f.transform.Yaw, 1e-9)
+	s.InDelta(0, truth.Offset.Distance(f.transform.Offset), 1e-9)
+
+	// noisy measurements are averaged
+	f.raw = truth.Invert().Apply(m.GetCheckpoint(3)).Add(vector.V3D{0.1, 0, 0})
+	info, err := a.Command("3")
+	s.Require().NoError(err)
+	s.Contains(info, "Map aligned by 3 checkpoints")
+	s.InDelta(truth.Yaw, f.transform.Yaw, 2)
+
+	_, err = a.Command("4")
+	s.Error(err)
+	_, err = a.Command("")
+	s.Error(err)
+
+	_, err = a.Command("reset")
+	s.Require().NoError(err)
+	s.Equal(navigator.Transform{}, f.transform)
+}
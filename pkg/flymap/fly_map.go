@@ -19,6 +19,7 @@ type FlyMap struct {
 	Name        string
 	MtlLib      string
 	checkpoints map[int]*Checkpoint
+	obstacles   []Obstacle
 }
 
 type Checkpoint struct {
@@ -35,6 +36,28 @@ func New(name, mtlLib string) *FlyMap {
 	}
 }
 
+// Replace replaces the contents of the map with the other map, components holding the map see the other one.
+// The other map must not be used after it.
+func (fm *FlyMap) Replace(other *FlyMap) {
+	other.mux.RLock()
+	name, mtlLib, checkpoints, obstacles := other.Name, other.MtlLib, other.checkpoints, other.obstacles
+	other.mux.RUnlock()
+
+	fm.mux.Lock()
+	defer fm.mux.Unlock()
+
+	fm.Name, fm.MtlLib = name, mtlLib
+	fm.checkpoints, fm.obstacles = checkpoints, obstacles
+}
+
+// Rename sets the name written to the OBJ file of the map.
+func (fm *FlyMap) Rename(name string) {
+	fm.mux.Lock()
+	defer fm.mux.Unlock()
+
+	fm.Name = name
+}
+
 func (fm *FlyMap) AddCheckpoint(x, y, z float64) (id int) {
 	fm.mux.Lock()
 	defer fm.mux.Unlock()
@@ -58,6 +81,18 @@ func (fm *FlyMap) GetCheckpoint(id int) vector.V3D {
 	return fm.checkpoints[id].Position
 }
 
+// Checkpoint returns the position of the checkpoint if it exists.
+func (fm *FlyMap) Checkpoint(id int) (vector.V3D, bool) {
+	fm.mux.RLock()
+	defer fm.mux.RUnlock()
+
+	checkpoint, ok := fm.checkpoints[id]
+	if !ok {
+		return vector.V3D{}, false
+	}
+	return checkpoint.Position, true
+}
+
 func (fm *FlyMap) LinkCheckpoint(fromID, toID int) {
 	fm.mux.Lock()
 	defer fm.mux.Unlock()
@@ -72,10 +107,44 @@ func (fm *FlyMap) LinkCheckpoint(fromID, toID int) {
 		logrus.Warnf("checkpoints %d isn't found", toID)
 		return
 	}
+	if from == to {
+		return
+	}
+	for _, next := range from.Next {
+		if next == to {
+			return // already linked
+		}
+	}
 	from.Next = append(from.Next, to)
 	to.Next = append(to.Next, from)
 }
 
+// Nearest returns the checkpoint nearest to the position within the radius, except the excluded ones.
+func (fm *FlyMap) Nearest(p vector.V3D, radius float64, exclude ...int) (id int, ok bool) {
+	fm.mux.RLock()
+	defer fm.mux.RUnlock()
+
+	best := radius
+	for _, checkpoint := range fm.checkpoints {
+		if d := checkpoint.Position.Distance(p); d <= best && !contains(exclude, checkpoint.ID) {
+			if d == best && ok && checkpoint.ID > id {
+				continue // the same answer for the same map
+			}
+			id, best, ok = checkpoint.ID, d, true
+		}
+	}
+	return id, ok
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 func LoadMap(path string) (*FlyMap, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -107,6 +176,20 @@ func ReadMap(src io.Reader) (*FlyMap, error) {
 	var m = &FlyMap{
 		checkpoints: make(map[int]*Checkpoint),
 	}
+	// objects after the first one are obstacles, vertices are numbered through all objects
+	var (
+		obstacle      *Obstacle
+		vertices      int
+		obstacleStart int // number of vertices before the obstacle
+	)
+	addObstacle := func() {
+		if obstacle == nil {
+			return
+		}
+		if err := m.AddObstacle(*obstacle); err != nil {
+			logrus.Warnf("broken obstacle: %v", err)
+		}
+	}
 	br := bufio.NewReader(src)
 	for {
 		line, _, err := br.ReadLine()
@@ -127,7 +210,12 @@ func ReadMap(src io.Reader) (*FlyMap, error) {
 				logrus.Warnf("broken name line")
 				continue
 			}
-			m.Name = lineArr[1]
+			if m.Name == "" {
+				m.Name = lineArr[1]
+				continue
+			}
+			addObstacle()
+			obstacle, obstacleStart = &Obstacle{Name: lineArr[1]}, vertices
 		case "mtllib":
 			if len(lineArr) < 2 {
 				logrus.Warnf("broken matlib line")
@@ -139,6 +227,11 @@ func ReadMap(src io.Reader) (*FlyMap, error) {
 				logrus.Warnf("broken matlib line")
 				continue
 			}
+			vertices++
+			if obstacle != nil {
+				obstacle.Vertices = append(obstacle.Vertices, vector.V3D{atof(lineArr[1]), atof(lineArr[2]), atof(lineArr[3])})
+				continue
+			}
 			m.AddCheckpoint(atof(lineArr[1]), atof(lineArr[2]), atof(lineArr[3]))
 		case "l":
 			if len(lineArr) < 3 {
@@ -146,12 +239,25 @@ func ReadMap(src io.Reader) (*FlyMap, error) {
 				continue
 			}
 			m.LinkCheckpoint(atoi(lineArr[1]), atoi(lineArr[2]))
+		case "f":
+			if obstacle == nil || len(lineArr) < 4 {
+				logrus.Warnf("broken face line")
+				continue
+			}
+			var face []int
+			for _, vertex := range lineArr[1:] {
+				// vertex/texture/normal
+				index, _, _ := strings.Cut(vertex, "/")
+				face = append(face, atoi(index)-1-obstacleStart)
+			}
+			obstacle.Faces = append(obstacle.Faces, face)
 		case "":
 		default:
 			logrus.Warnf("unknown command")
 			continue
 		}
 	}
+	addObstacle()
 	return m, nil
 }
 
@@ -180,7 +286,9 @@ func WriteMap(dest io.Writer, m *FlyMap) error {
 	if err != nil {
 		return fmt.Errorf("error writing name: %w", err)
 	}
+	var vertices int
 	err = m.forEach(func(checkpoint *Checkpoint) error {
+		vertices++
 		_, err = dest.Write([]byte(fmt.Sprintf("v %f %f %f\n", checkpoint.Position[0], checkpoint.Position[1], checkpoint.Position[2])))
 		if err != nil {
 			return fmt.Errorf("error writing name: %w", err)
@@ -195,7 +303,7 @@ func WriteMap(dest io.Writer, m *FlyMap) error {
 		to   int
 	}
 	var linked = make(map[key]struct{})
-	return m.forEach(func(checkpoint *Checkpoint) error {
+	err = m.forEach(func(checkpoint *Checkpoint) error {
 		for _, next := range checkpoint.Next {
 			from, to := checkpoint.ID, next.ID
 			if from > to {
@@ -214,6 +322,33 @@ func WriteMap(dest io.Writer, m *FlyMap) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return writeObstacles(dest, m.obstacles, vertices)
+}
+
+// writeObstacles writes obstacles as objects of faces following the vertices of checkpoints.
+func writeObstacles(dest io.Writer, obstacles []Obstacle, vertices int) error {
+	for _, o := range obstacles {
+		var buf bytes.Buffer
+		buf.WriteString("o " + o.Name + "\n")
+		for _, v := range o.Vertices {
+			buf.WriteString(fmt.Sprintf("v %f %f %f\n", v[0], v[1], v[2]))
+		}
+		for _, face := range o.Faces {
+			buf.WriteString("f")
+			for _, i := range face {
+				buf.WriteString(" " + strconv.Itoa(vertices+i+1))
+			}
+			buf.WriteString("\n")
+		}
+		if _, err := dest.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("error writing obstacle: %w", err)
+		}
+		vertices += len(o.Vertices)
+	}
+	return nil
 }
 
 func (fm *FlyMap) forEach(f func(checkpoint *Checkpoint) error) error {
@@ -12,12 +12,12 @@ import (
 )
 
 type Sender struct {
-	wsClient *wsclient.Client
+	wsClient wsclient.Messenger
 	flyMap   *flymap.FlyMap
 	nav      *navigator.Navigator
 }
 
-func New(wsClient *wsclient.Client, flyMap *flymap.FlyMap, nav *navigator.Navigator) *Sender {
+func New(wsClient wsclient.Messenger, flyMap *flymap.FlyMap, nav *navigator.Navigator) *Sender {
 	return &Sender{
 		wsClient: wsClient,
 		flyMap:   flyMap,
@@ -37,10 +37,18 @@ func (s *Sender) Run(ctx context.Context) {
 				Content: s.flyMap.GetOBJ(),
 			})
 		case <-posTicker.C:
-			s.wsClient.SendMessage(wsclient.Message{
-				Type:    wsclient.MTPos,
-				Content: s.nav.GetPos().GetOBJ(),
-			})
+			if s.wsClient.Accepts(wsclient.MTPose) {
+				s.wsClient.SendMessage(wsclient.Message{
+					Type:    wsclient.MTPose,
+					Payload: s.nav.GetPose().JSON(),
+				})
+			}
+			if s.wsClient.Accepts(wsclient.MTPos) {
+				s.wsClient.SendMessage(wsclient.Message{
+					Type:    wsclient.MTPos,
+					Content: s.nav.GetPos().GetOBJ(),
+				})
+			}
 		case <-ctx.Done():
 			logrus.Warnf("stopped fly map sender")
 			return
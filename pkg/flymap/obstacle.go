@@ -0,0 +1,252 @@
+package flymap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// cylinderSides is the number of sides of the prism approximating a cylinder
+const cylinderSides = 16
+
+var ErrObstacleExists = errors.New("obstacle already exists")
+
+// Obstacle is a volume the drone must not fly through, e.g. furniture or a no-fly zone. It's a mesh
+// of convex faces, which is closed for boxes and cylinders, faces read from OBJ files may be open,
+// e.g. a wall.
+type Obstacle struct {
+	Name     string
+	Vertices []vector.V3D
+	Faces    [][]int // indices of vertices
+}
+
+// NewBox returns the box between the opposite corners.
+func NewBox(name string, a, b vector.V3D) Obstacle {
+	x0, x1 := math.Min(a.X(), b.X()), math.Max(a.X(), b.X())
+	y0, y1 := math.Min(a.Y(), b.Y()), math.Max(a.Y(), b.Y())
+	z0, z1 := math.Min(a.Z(), b.Z()), math.Max(a.Z(), b.Z())
+	return Obstacle{
+		Name: name,
+		Vertices: []vector.V3D{
+			{x0, y0, z0}, {x1, y0, z0}, {x1, y1, z0}, {x0, y1, z0},
+			{x0, y0, z1}, {x1, y0, z1}, {x1, y1, z1}, {x0, y1, z1},
+		},
+		Faces: [][]int{
+			{0, 3, 2, 1}, {4, 5, 6, 7}, // bottom and top
+			{0, 1, 5, 4}, {1, 2, 6, 5}, {2, 3, 7, 6}, {3, 0, 4, 7},
+		},
+	}
+}
+
+// NewCylinder returns the vertical cylinder standing on the center of its base, a negative height
+// goes down.
+func NewCylinder(name string, base vector.V3D, radius, height float64) Obstacle {
+	o := Obstacle{Name: name}
+	bottom, top := make([]int, cylinderSides), make([]int, cylinderSides)
+	for i := 0; i < cylinderSides; i++ {
+		angle := 2 * math.Pi * float64(i) / cylinderSides
+		x, y := base.X()+radius*math.Cos(angle), base.Y()+radius*math.Sin(angle)
+		o.Vertices = append(o.Vertices, vector.V3D{x, y, base.Z()}, vector.V3D{x, y, base.Z() + height})
+		bottom[cylinderSides-1-i], top[i] = 2*i, 2*i+1
+	}
+	o.Faces = append(o.Faces, bottom, top)
+	for i := 0; i < cylinderSides; i++ {
+		j := (i + 1) % cylinderSides
+		o.Faces = append(o.Faces, []int{2 * i, 2 * j, 2*j + 1, 2*i + 1})
+	}
+	return o
+}
+
+// Intersects tells if the segment a-b crosses a face of the obstacle or lies inside it.
+func (o Obstacle) Intersects(a, b vector.V3D) bool {
+	hit := false
+	o.forEachTriangle(func(v0, v1, v2 vector.V3D) {
+		if !hit {
+			_, hit = segmentTriangle(a, b, v0, v1, v2)
+		}
+	})
+	return hit || o.Contains(a)
+}
+
+// Contains tells if the point is inside the closed mesh of the obstacle: a ray from it crosses the
+// faces an odd number of times.
+func (o Obstacle) Contains(p vector.V3D) bool {
+	// a direction unlikely to hit edges exactly
+	far := p.Add(vector.V3D{0.5377, 0.6123, 0.5791}.Scale(1e6))
+	crossings := 0
+	o.forEachTriangle(func(v0, v1, v2 vector.V3D) {
+		if _, hit := segmentTriangle(p, far, v0, v1, v2); hit {
+			crossings++
+		}
+	})
+	return crossings%2 == 1
+}
+
+// forEachTriangle splits convex faces into triangles.
+func (o Obstacle) forEachTriangle(f func(v0, v1, v2 vector.V3D)) {
+	for _, face := range o.Faces {
+		for i := 2; i < len(face); i++ {
+			f(o.Vertices[face[0]], o.Vertices[face[i-1]], o.Vertices[face[i]])
+		}
+	}
+}
+
+// segmentTriangle returns where along the segment a-b it crosses the triangle (Moller-Trumbore algorithm).
+func segmentTriangle(a, b, v0, v1, v2 vector.V3D) (float64, bool) {
+	const eps = 1e-12
+	dir := b.Sub(a)
+	e1, e2 := v1.Sub(v0), v2.Sub(v0)
+	p := cross(dir, e2)
+	det := dot(e1, p)
+	if math.Abs(det) < eps {
+		return 0, false // parallel
+	}
+	t := a.Sub(v0)
+	u := dot(t, p) / det
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+	q := cross(t, e1)
+	v := dot(dir, q) / det
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+	along := dot(e2, q) / det
+	return along, along >= 0 && along <= 1
+}
+
+func cross(a, b vector.V3D) vector.V3D {
+	return vector.V3D{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// AddObstacle adds the obstacle, its name must be unique and have no spaces.
+func (fm *FlyMap) AddObstacle(o Obstacle) error {
+	if o.Name == "" || strings.ContainsAny(o.Name, " \t") {
+		return fmt.Errorf("wrong obstacle name %q", o.Name)
+	}
+	for _, face := range o.Faces {
+		for _, i := range face {
+			if i < 0 || i >= len(o.Vertices) {
+				return fmt.Errorf("face of obstacle %s has no vertex %d", o.Name, i)
+			}
+		}
+	}
+	fm.mux.Lock()
+	defer fm.mux.Unlock()
+
+	for _, existing := range fm.obstacles {
+		if existing.Name == o.Name {
+			return fmt.Errorf("%w: %s", ErrObstacleExists, o.Name)
+		}
+	}
+	fm.obstacles = append(fm.obstacles, o)
+	return nil
+}
+
+// RemoveObstacle removes the obstacle, it returns false if there is no such obstacle.
+func (fm *FlyMap) RemoveObstacle(name string) bool {
+	fm.mux.Lock()
+	defer fm.mux.Unlock()
+
+	for i, o := range fm.obstacles {
+		if o.Name == name {
+			fm.obstacles = append(fm.obstacles[:i], fm.obstacles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Obstacles returns the obstacles of the map.
+func (fm *FlyMap) Obstacles() []Obstacle {
+	fm.mux.RLock()
+	defer fm.mux.RUnlock()
+
+	return append([]Obstacle(nil), fm.obstacles...)
+}
+
+// Blocked returns the first obstacle the segment a-b intersects.
+func (fm *FlyMap) Blocked(a, b vector.V3D) (name string, blocked bool) {
+	fm.mux.RLock()
+	defer fm.mux.RUnlock()
+
+	for _, o := range fm.obstacles {
+		if o.Intersects(a, b) {
+			return o.Name, true
+		}
+	}
+	return "", false
+}
+
+// ObstacleCommand edits obstacles on operator's "obstacle" command:
+// "obstacle box <name> x1 y1 z1 x2 y2 z2" adds the box between the corners,
+// "obstacle cylinder <name> x y z radius height" adds the vertical cylinder standing on the point,
+// "obstacle remove <name>" removes it and "obstacle list" lists them. See controller.Controller.Command.
+func (fm *FlyMap) ObstacleCommand(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("obstacle command isn't set")
+	}
+	numbers := func(n int) ([]float64, error) {
+		if len(fields) != n+2 {
+			return nil, fmt.Errorf("obstacle %s needs a name and %d numbers", fields[0], n)
+		}
+		values := make([]float64, n)
+		for i := range values {
+			var err error
+			if values[i], err = strconv.ParseFloat(fields[i+2], 64); err != nil {
+				return nil, fmt.Errorf("wrong number %q", fields[i+2])
+			}
+		}
+		return values, nil
+	}
+	switch fields[0] {
+	case "box":
+		v, err := numbers(6)
+		if err != nil {
+			return "", err
+		}
+		if err = fm.AddObstacle(NewBox(fields[1], vector.V3D{v[0], v[1], v[2]}, vector.V3D{v[3], v[4], v[5]})); err != nil {
+			return "", err
+		}
+		return "Obstacle " + fields[1] + " added", nil
+	case "cylinder":
+		v, err := numbers(5)
+		if err != nil {
+			return "", err
+		}
+		if v[3] <= 0 {
+			return "", fmt.Errorf("wrong radius %v", v[3])
+		}
+		if err = fm.AddObstacle(NewCylinder(fields[1], vector.V3D{v[0], v[1], v[2]}, v[3], v[4])); err != nil {
+			return "", err
+		}
+		return "Obstacle " + fields[1] + " added", nil
+	case "remove":
+		if len(fields) != 2 {
+			return "", fmt.Errorf("obstacle name isn't set")
+		}
+		if !fm.RemoveObstacle(fields[1]) {
+			return "", fmt.Errorf("obstacle %s isn't found", fields[1])
+		}
+		return "Obstacle " + fields[1] + " removed", nil
+	case "list":
+		var names []string
+		for _, o := range fm.Obstacles() {
+			names = append(names, o.Name)
+		}
+		sort.Strings(names)
+		return "Obstacles: " + strings.Join(names, ", "), nil
+	default:
+		return "", fmt.Errorf("unknown obstacle command %q", fields[0])
+	}
+}
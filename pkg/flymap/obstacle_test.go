@@ -0,0 +1,65 @@
+package flymap
+
+import (
+	"bytes"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+func (s *MapSuite) TestObstacles() {
+	box := NewBox("table", vector.V3D{1, 1, 0}, vector.V3D{2, 2, 1})
+	s.True(box.Contains(vector.V3D{1.5, 1.5, 0.5}))
+	s.False(box.Contains(vector.V3D{2.5, 1.5, 0.5}))
+	s.True(box.Intersects(vector.V3D{0, 1.5, 0.5}, vector.V3D{3, 1.5, 0.5}), "through")
+	s.True(box.Intersects(vector.V3D{1.2, 1.2, 0.2}, vector.V3D{1.8, 1.8, 0.8}), "inside")
+	s.False(box.Intersects(vector.V3D{0, 1.5, 1.5}, vector.V3D{3, 1.5, 1.5}), "over")
+	s.False(box.Intersects(vector.V3D{0, 0, 0.5}, vector.V3D{0.9, 3, 0.5}), "beside")
+
+	cylinder := NewCylinder("lamp", vector.V3D{0, 0, 0}, 0.5, 2)
+	s.True(cylinder.Contains(vector.V3D{0.3, 0.3, 1}))
+	s.False(cylinder.Contains(vector.V3D{0.4, 0.4, 1}))
+	s.True(cylinder.Intersects(vector.V3D{-1, 0.2, 1}, vector.V3D{1, 0.2, 1}))
+	s.False(cylinder.Intersects(vector.V3D{-1, 0.2, 2.5}, vector.V3D{1, 0.2, 2.5}))
+
+	m := New("FlyMap", "map.mtl")
+	m.AddCheckpoint(0, 0, 0)
+	m.AddCheckpoint(3, 0, 0)
+	m.LinkCheckpoint(1, 2)
+	s.Require().NoError(m.AddObstacle(box))
+	s.ErrorIs(m.AddObstacle(box), ErrObstacleExists)
+	_, err := m.ObstacleCommand("cylinder lamp 0 3 0 0.5 2")
+	s.Require().NoError(err)
+
+	name, blocked := m.Blocked(vector.V3D{1.5, 0, 0.5}, vector.V3D{1.5, 3, 0.5})
+	s.True(blocked)
+	s.Equal("table", name)
+	_, blocked = m.Blocked(vector.V3D{0, 0, 0.5}, vector.V3D{3, 0, 0.5})
+	s.False(blocked)
+
+	// obstacles are objects of faces following the checkpoints
+	obj := string(m.GetOBJ())
+	s.Contains(obj, "l 1 2\no table\nv 1.000000 1.000000 0.000000\n")
+	s.Contains(obj, "f 3 6 5 4\n")
+	read, err := ReadMap(bytes.NewBufferString(obj))
+	s.Require().NoError(err)
+	s.Equal("FlyMap", read.Name)
+	s.Require().Len(read.Obstacles(), 2)
+	s.Equal(box, read.Obstacles()[0])
+	s.Equal(m.Obstacles()[1].Faces, read.Obstacles()[1].Faces)
+	s.Equal(vector.V3D{3, 0, 0}, read.GetCheckpoint(2))
+	s.Equal(obj, string(read.GetOBJ()))
+
+	info, err := m.ObstacleCommand("list")
+	s.Require().NoError(err)
+	s.Equal("Obstacles: lamp, table", info)
+	_, err = m.ObstacleCommand("remove table")
+	s.Require().NoError(err)
+	_, blocked = m.Blocked(vector.V3D{1.5, 0, 0.5}, vector.V3D{1.5, 3, 0.5})
+	s.False(blocked)
+	_, err = m.ObstacleCommand("remove table")
+	s.Error(err)
+	_, err = m.ObstacleCommand("box chair 1 2 3")
+	s.Error(err)
+	_, err = m.ObstacleCommand("cylinder pole 0 0 0 0 1")
+	s.Error(err)
+}
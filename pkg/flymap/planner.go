@@ -0,0 +1,417 @@
+package flymap
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// maxPlanNodes limits the grid searched by the planner, it's about 100x100x25 m at the default resolution.
+const maxPlanNodes = 4000000
+
+var (
+	ErrNoPath     = errors.New("no path found")
+	ErrPlanBudget = errors.New("path search budget exceeded")
+)
+
+// Bounds is the box the drone must stay in, e.g. the walls, the floor and the ceiling of a room.
+type Bounds struct {
+	Min, Max vector.V3D
+}
+
+// ParseBounds parses "x1,y1,z1,x2,y2,z2" of the opposite corners.
+func ParseBounds(s string) (Bounds, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 6 {
+		return Bounds{}, fmt.Errorf("bounds need 6 numbers, got %q", s)
+	}
+	var v [6]float64
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return Bounds{}, fmt.Errorf("wrong number %q", f)
+		}
+	}
+	var b Bounds
+	for i := 0; i < 3; i++ {
+		b.Min[i], b.Max[i] = math.Min(v[i], v[i+3]), math.Max(v[i], v[i+3])
+	}
+	return b, nil
+}
+
+// Contains tells if the point is inside the bounds or on them.
+func (b Bounds) Contains(p vector.V3D) bool {
+	for i := 0; i < 3; i++ {
+		if p[i] < b.Min[i] || p[i] > b.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// extend returns the bounds containing the point as well.
+func (b Bounds) extend(p vector.V3D) Bounds {
+	for i := 0; i < 3; i++ {
+		b.Min[i], b.Max[i] = math.Min(b.Min[i], p[i]), math.Max(b.Max[i], p[i])
+	}
+	return b
+}
+
+// PlanOptions tell how fine the search is and how far from obstacles the drone flies.
+type PlanOptions struct {
+	Resolution float64 // step of the grid searched for the path
+	Clearance  float64 // from obstacles to the path, the drone is about 0.2 m wide
+	// without a geofence the drone stays within the margin around checkpoints, obstacles and the path ends
+	Margin float64
+	// nodes expanded by the search before it gives up, a node takes about 20 us, so the default budget
+	// is about a second, enough for the whole room of 10x10x3 m at the default resolution
+	MaxNodes int
+}
+
+// DefaultPlanOptions suit flights in a room.
+var DefaultPlanOptions = PlanOptions{Resolution: 0.25, Clearance: 0.3, Margin: 1, MaxNodes: 50000}
+
+// Planner finds paths around obstacles of the map within the geofence. Paths are searched with A* on a grid
+// starting at the current position, the drone moves horizontally or vertically between neighbour nodes,
+// then the path is shortened by skipping waypoints while the straight line is still clear. Every leg of
+// a path is horizontal or vertical, so the drone flying to XY and then to the height follows it exactly.
+type Planner struct {
+	flyMap   *FlyMap
+	geofence *Bounds
+	options  PlanOptions
+}
+
+// NewPlanner creates a planner, a nil geofence is the area around the map.
+func NewPlanner(flyMap *FlyMap, geofence *Bounds, options PlanOptions) *Planner {
+	if options.Resolution <= 0 {
+		options.Resolution = DefaultPlanOptions.Resolution
+	}
+	if options.MaxNodes <= 0 {
+		options.MaxNodes = DefaultPlanOptions.MaxNodes
+	}
+	return &Planner{
+		flyMap:   flyMap,
+		geofence: geofence,
+		options:  options,
+	}
+}
+
+// Plan returns the waypoints from the position to the target, the target is the last one. The search
+// stops when the context is done or MaxNodes are expanded.
+func (p *Planner) Plan(ctx context.Context, from, to vector.V3D) ([]vector.V3D, error) {
+	bounds, err := p.bounds(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if name, blocked := p.flyMap.Blocked(to, to); blocked {
+		return nil, fmt.Errorf("target is inside obstacle %s", name)
+	}
+	g, err := newPlanGrid(p, bounds, from)
+	if err != nil {
+		return nil, err
+	}
+	goal := g.nearest(to)
+	// the target may be closer to obstacles than the clearance, e.g. a checkpoint over a table,
+	// the last legs only must not cross them
+	above := vector.V3D{to.X(), to.Y(), g.position(goal).Z()}
+	if _, blocked := p.flyMap.Blocked(g.position(goal), above); blocked {
+		return nil, fmt.Errorf("%w to %v", ErrNoPath, to)
+	}
+	if _, blocked := p.flyMap.Blocked(above, to); blocked {
+		return nil, fmt.Errorf("%w to %v", ErrNoPath, to)
+	}
+	nodes, err := g.search(ctx, goal)
+	if err != nil {
+		return nil, fmt.Errorf("%w to %v: %w", ErrNoPath, to, err)
+	}
+	path := make([]vector.V3D, 0, len(nodes)+2)
+	for _, n := range nodes {
+		path = append(path, g.position(n))
+	}
+	path = append(path, above, to)
+	return p.shorten(path, bounds), nil
+}
+
+// bounds returns the geofence or the area around the map, the path ends must be inside.
+func (p *Planner) bounds(from, to vector.V3D) (Bounds, error) {
+	if p.geofence != nil {
+		if !p.geofence.Contains(from) {
+			return Bounds{}, fmt.Errorf("position %v is outside the geofence", from)
+		}
+		if !p.geofence.Contains(to) {
+			return Bounds{}, fmt.Errorf("target %v is outside the geofence", to)
+		}
+		return *p.geofence, nil
+	}
+	b := Bounds{Min: from, Max: from}.extend(to)
+	p.flyMap.mux.RLock()
+	_ = p.flyMap.forEach(func(checkpoint *Checkpoint) error {
+		b = b.extend(checkpoint.Position)
+		return nil
+	})
+	p.flyMap.mux.RUnlock()
+	for _, o := range p.flyMap.Obstacles() {
+		for _, v := range o.Vertices {
+			b = b.extend(v)
+		}
+	}
+	margin := vector.V3D{p.options.Margin, p.options.Margin, p.options.Margin}
+	return Bounds{Min: b.Min.Sub(margin), Max: b.Max.Add(margin)}, nil
+}
+
+// clear tells if the segment and the parallel ones at the clearance around it are free of obstacles.
+// A point is checked by the segments through it along the axes.
+func (p *Planner) clear(a, b vector.V3D) bool {
+	c := p.options.Clearance
+	if _, blocked := p.flyMap.Blocked(a, b); blocked {
+		return false
+	}
+	if c <= 0 {
+		return true
+	}
+	if a == b {
+		for _, axis := range []vector.V3D{{c, 0, 0}, {0, c, 0}, {0, 0, c}} {
+			if _, blocked := p.flyMap.Blocked(a.Sub(axis), a.Add(axis)); blocked {
+				return false
+			}
+		}
+		return true
+	}
+	dir := b.Sub(a)
+	u := cross(dir, vector.V3D{0, 0, 1})
+	if dot(u, u) == 0 {
+		u = vector.V3D{1, 0, 0}
+	}
+	u = u.Scale(c / math.Sqrt(dot(u, u)))
+	v := cross(dir, u)
+	v = v.Scale(c / math.Sqrt(dot(v, v)))
+	for _, offset := range []vector.V3D{u, u.Scale(-1), v, v.Scale(-1)} {
+		if _, blocked := p.flyMap.Blocked(a.Add(offset), b.Add(offset)); blocked {
+			return false
+		}
+	}
+	return true
+}
+
+// shorten skips waypoints while the legs from the previous one stay clear. The drone may fly to
+// a skipped-to waypoint horizontally and vertically or vertically and horizontally, then the corner
+// is a waypoint too.
+func (p *Planner) shorten(path []vector.V3D, bounds Bounds) []vector.V3D {
+	var shortened []vector.V3D
+	for i := 0; i+1 < len(path); {
+		next, legs := i+1, []vector.V3D{path[i+1]}
+		for j := len(path) - 1; j > i+1; j-- {
+			if corners, ok := p.legs(path[i], path[j], bounds); ok {
+				next, legs = j, corners
+				break
+			}
+		}
+		for _, waypoint := range legs {
+			if len(shortened) == 0 && waypoint == path[0] || len(shortened) > 0 && waypoint == shortened[len(shortened)-1] {
+				continue
+			}
+			shortened = append(shortened, waypoint)
+		}
+		i = next
+	}
+	return shortened
+}
+
+// legs returns the waypoints of clear straight legs from a to b, false if there are no such legs.
+func (p *Planner) legs(a, b vector.V3D, bounds Bounds) ([]vector.V3D, bool) {
+	if straightLeg(a, b) {
+		return []vector.V3D{b}, p.clear(a, b)
+	}
+	for _, corner := range []vector.V3D{{b.X(), b.Y(), a.Z()}, {a.X(), a.Y(), b.Z()}} {
+		if bounds.Contains(corner) && p.clear(a, corner) && p.clear(corner, b) {
+			return []vector.V3D{corner, b}, true
+		}
+	}
+	return nil, false
+}
+
+// straightLeg tells if the drone flies from a to b either horizontally or vertically.
+func straightLeg(a, b vector.V3D) bool {
+	return a.Z() == b.Z() || (a.X() == b.X() && a.Y() == b.Y())
+}
+
+// planGrid is the grid of the search, the start position is the node of zero indices.
+type planGrid struct {
+	planner    *Planner
+	origin     vector.V3D
+	min, size  [3]int // of node indices along the axes
+	resolution float64
+	free       map[int]bool // checked nodes
+}
+
+func newPlanGrid(p *Planner, bounds Bounds, from vector.V3D) (*planGrid, error) {
+	g := &planGrid{
+		planner:    p,
+		origin:     from,
+		resolution: p.options.Resolution,
+		free:       make(map[int]bool),
+	}
+	nodes := 1
+	for i := 0; i < 3; i++ {
+		g.min[i] = int(math.Ceil((bounds.Min[i] - from[i]) / g.resolution))
+		g.size[i] = int(math.Floor((bounds.Max[i]-from[i])/g.resolution)) - g.min[i] + 1
+		nodes *= g.size[i]
+		if nodes > maxPlanNodes {
+			return nil, fmt.Errorf("planning area is too large for resolution %v", g.resolution)
+		}
+	}
+	return g, nil
+}
+
+// node returns the node of the indices relative to the start, false if it's outside the grid.
+func (g *planGrid) node(i [3]int) (int, bool) {
+	n := 0
+	for axis := 2; axis >= 0; axis-- {
+		k := i[axis] - g.min[axis]
+		if k < 0 || k >= g.size[axis] {
+			return 0, false
+		}
+		n = n*g.size[axis] + k
+	}
+	return n, true
+}
+
+func (g *planGrid) indices(n int) [3]int {
+	var i [3]int
+	for axis := 0; axis < 3; axis++ {
+		i[axis] = n%g.size[axis] + g.min[axis]
+		n /= g.size[axis]
+	}
+	return i
+}
+
+func (g *planGrid) position(n int) vector.V3D {
+	i := g.indices(n)
+	return g.origin.Add(vector.V3D{float64(i[0]), float64(i[1]), float64(i[2])}.Scale(g.resolution))
+}
+
+// nearest returns the node closest to the point, which is inside the bounds.
+func (g *planGrid) nearest(p vector.V3D) int {
+	var i [3]int
+	for axis := 0; axis < 3; axis++ {
+		k := int(math.Round((p[axis] - g.origin[axis]) / g.resolution))
+		i[axis] = minInt(maxInt(k, g.min[axis]), g.min[axis]+g.size[axis]-1)
+	}
+	n, _ := g.node(i)
+	return n
+}
+
+// isFree tells if the drone may be at the node. The start and the goal are free unless they are
+// inside obstacles, the drone may start or finish closer to obstacles than the clearance.
+func (g *planGrid) isFree(n int, start, goal int) bool {
+	free, ok := g.free[n]
+	if !ok {
+		if n == start || n == goal {
+			_, blocked := g.planner.flyMap.Blocked(g.position(n), g.position(n))
+			free = !blocked
+		} else {
+			free = g.planner.clear(g.position(n), g.position(n))
+		}
+		g.free[n] = free
+	}
+	return free
+}
+
+// planMoves are the moves to neighbour nodes, horizontal or vertical.
+var planMoves = [][3]int{
+	{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0},
+	{1, 1, 0}, {1, -1, 0}, {-1, 1, 0}, {-1, -1, 0},
+	{0, 0, 1}, {0, 0, -1},
+}
+
+// search returns the nodes of the shortest path from the start to the goal with A*.
+func (g *planGrid) search(ctx context.Context, goal int) ([]int, error) {
+	start, _ := g.node([3]int{})
+	goalPosition := g.position(goal)
+	cost := map[int]float64{start: 0}
+	previous := map[int]int{}
+	closed := map[int]bool{}
+	queue := &planQueue{{node: start, priority: g.position(start).Distance(goalPosition)}}
+	for queue.Len() > 0 {
+		n := heap.Pop(queue).(planItem).node
+		if n == goal {
+			nodes := []int{n}
+			for n != start {
+				n = previous[n]
+				nodes = append(nodes, n)
+			}
+			for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+				nodes[i], nodes[j] = nodes[j], nodes[i]
+			}
+			return nodes, nil
+		}
+		if closed[n] {
+			continue
+		}
+		closed[n] = true
+		if len(closed) > g.planner.options.MaxNodes {
+			return nil, fmt.Errorf("%w: %d nodes", ErrPlanBudget, g.planner.options.MaxNodes)
+		}
+		if len(closed)%1000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		i := g.indices(n)
+		position := g.position(n)
+		for _, move := range planMoves {
+			neighbour, ok := g.node([3]int{i[0] + move[0], i[1] + move[1], i[2] + move[2]})
+			if !ok || closed[neighbour] || !g.isFree(neighbour, start, goal) {
+				continue
+			}
+			neighbourPosition := g.position(neighbour)
+			c := cost[n] + position.Distance(neighbourPosition)
+			if known, ok := cost[neighbour]; ok && known <= c {
+				continue
+			}
+			if _, blocked := g.planner.flyMap.Blocked(position, neighbourPosition); blocked {
+				continue
+			}
+			cost[neighbour], previous[neighbour] = c, n
+			heap.Push(queue, planItem{node: neighbour, priority: c + neighbourPosition.Distance(goalPosition)})
+		}
+	}
+	return nil, errors.New("no way around obstacles")
+}
+
+type planItem struct {
+	node     int
+	priority float64
+}
+
+// planQueue is the priority queue of A*, see container/heap.
+type planQueue []planItem
+
+func (q planQueue) Len() int           { return len(q) }
+func (q planQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q planQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *planQueue) Push(x any)        { *q = append(*q, x.(planItem)) }
+func (q *planQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
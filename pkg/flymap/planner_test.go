@@ -0,0 +1,71 @@
+package flymap
+
+import (
+	"context"
+	"time"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+func (s *MapSuite) TestPlan() {
+	m := New("FlyMap", "map.mtl")
+	s.Require().NoError(m.AddObstacle(NewBox("wall", vector.V3D{1, -2, 0}, vector.V3D{1.2, 2, 3})))
+	from, to := vector.V3D{0, 0, 1}, vector.V3D{2.5, 0.3, 1.5}
+
+	geofence := Bounds{Min: vector.V3D{-1, -3, 0}, Max: vector.V3D{4, 3, 2.5}}
+	planner := NewPlanner(m, &geofence, DefaultPlanOptions)
+	path, err := planner.Plan(context.Background(), from, to)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(path)
+	s.Equal(to, path[len(path)-1])
+	previous := from
+	for _, p := range path {
+		s.True(straightLeg(previous, p), "leg %v - %v", previous, p)
+		s.True(geofence.Contains(p))
+		_, blocked := m.Blocked(previous, p)
+		s.False(blocked, "leg %v - %v", previous, p)
+		previous = p
+	}
+	s.LessOrEqual(len(path), 6, "shortened %v", path)
+
+	// the ceiling is too low to fly over the wall and the wall reaches the geofence on the sides
+	closed := Bounds{Min: vector.V3D{-1, -2, 0}, Max: vector.V3D{4, 2, 2.5}}
+	_, err = NewPlanner(m, &closed, DefaultPlanOptions).Plan(context.Background(), from, to)
+	s.ErrorIs(err, ErrNoPath)
+
+	_, err = planner.Plan(context.Background(), from, vector.V3D{5, 0, 1})
+	s.Error(err, "outside the geofence")
+	_, err = planner.Plan(context.Background(), from, vector.V3D{1.1, 0, 1})
+	s.Error(err, "inside the wall")
+
+	// without the geofence the drone may fly over the wall
+	path, err = NewPlanner(m, nil, DefaultPlanOptions).Plan(context.Background(), from, to)
+	s.Require().NoError(err)
+	s.Equal(to, path[len(path)-1])
+
+	// nothing in the way
+	path, err = planner.Plan(context.Background(), from, vector.V3D{0.5, 1, 1})
+	s.Require().NoError(err)
+	s.Equal([]vector.V3D{{0.5, 1, 1}}, path)
+}
+
+func (s *MapSuite) TestPlanBudget() {
+	m := New("FlyMap", "map.mtl")
+	s.Require().NoError(m.AddObstacle(NewBox("wall", vector.V3D{10, -15, 0}, vector.V3D{10.2, 15, 3})))
+	from, to := vector.V3D{0, 0, 1}, vector.V3D{12, 0, 1}
+	// the wall closes the geofence, the whole big room would be searched without the budget
+	geofence := Bounds{Min: vector.V3D{-15, -15, 0}, Max: vector.V3D{15, 15, 3}}
+
+	options := DefaultPlanOptions
+	options.MaxNodes = 2000
+	started := time.Now()
+	_, err := NewPlanner(m, &geofence, options).Plan(context.Background(), from, to)
+	s.ErrorIs(err, ErrNoPath)
+	s.ErrorIs(err, ErrPlanBudget)
+	s.Less(time.Since(started), time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewPlanner(m, &geofence, DefaultPlanOptions).Plan(ctx, from, to)
+	s.ErrorIs(err, context.Canceled)
+}
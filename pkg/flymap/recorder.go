@@ -0,0 +1,285 @@
+package flymap
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// DefaultSamplePeriod is used when the recorder is created with non-positive period.
+const DefaultSamplePeriod = 100 * time.Millisecond
+
+// sampleStep is the distance the drone moves between points of the track, smaller moves are hovering
+const sampleStep = 0.05
+
+// RecordOptions tell where checkpoints are added automatically and how they are linked.
+type RecordOptions struct {
+	Distance  float64 // along the track between checkpoints on straight lines
+	TurnAngle float64 // degrees, turns sharper than it get a checkpoint
+	Tolerance float64 // deviations of the track from straight lines ignored as noise
+	// a new checkpoint is also linked to the nearest one within the radius, so routes join, zero disables it
+	LinkRadius float64
+	// a checkpoint closer than it to an existing one is the existing one, so routes share checkpoints,
+	// zero disables it
+	MergeDistance float64
+}
+
+// DefaultRecordOptions suit flights in a room.
+var DefaultRecordOptions = RecordOptions{Distance: 1, TurnAngle: 30, Tolerance: 0.15, LinkRadius: 0.7, MergeDistance: 0.3}
+
+// PoseSource provides the latest pose, e.g. navigator.Navigator.
+type PoseSource interface {
+	GetPose() navigator.Pose
+}
+
+// Recorder adds checkpoints of flights to the map, every checkpoint is linked to the previous one and to
+// the nearest one around, checkpoints close to existing ones are merged with them. A new branch starts
+// from any checkpoint, so the map becomes a graph of routes.
+//
+// Checkpoints are added by operators or, in auto mode, from the track of the drone: on take off and
+// landing, at turns and every Distance on straight lines. The track is simplified with Douglas-Peucker
+// algorithm first, so the noise of positions doesn't look like turns.
+type Recorder struct {
+	flyMap  *FlyMap
+	poses   PoseSource
+	period  time.Duration
+	options RecordOptions
+
+	mux    sync.Mutex
+	last   int // checkpoint, 0 if there is none
+	auto   bool
+	flying bool
+	track  []vector.V3D // since the last checkpoint, which is the first point
+}
+
+func NewRecorder(flyMap *FlyMap, poses PoseSource, period time.Duration, options RecordOptions, auto bool) *Recorder {
+	if period <= 0 {
+		period = DefaultSamplePeriod
+	}
+	return &Recorder{
+		flyMap:  flyMap,
+		poses:   poses,
+		period:  period,
+		options: options,
+		auto:    auto,
+	}
+}
+
+// Add adds the checkpoint at the position and returns its id, added is false if it's merged
+// with an existing one.
+func (r *Recorder) Add(p vector.V3D) (id int, added bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.add(p)
+}
+
+func (r *Recorder) add(p vector.V3D) (id int, added bool) {
+	previous := r.last
+	if r.options.MergeDistance > 0 {
+		id, merged := r.flyMap.Nearest(p, r.options.MergeDistance)
+		if merged {
+			// the map may be switched meanwhile, the track starts from the position then
+			checkpoint, ok := r.flyMap.Checkpoint(id)
+			if !ok {
+				checkpoint = p
+			}
+			r.link(previous, id)
+			r.last = id
+			r.track = []vector.V3D{checkpoint}
+			return id, false
+		}
+	}
+	id = r.flyMap.AddCheckpoint(p.X(), p.Y(), p.Z())
+	r.link(previous, id)
+	if r.options.LinkRadius > 0 {
+		if nearest, ok := r.flyMap.Nearest(p, r.options.LinkRadius, id, previous); ok {
+			r.flyMap.LinkCheckpoint(nearest, id)
+		}
+	}
+	r.last = id
+	r.track = []vector.V3D{p}
+	return id, true
+}
+
+func (r *Recorder) link(from, to int) {
+	if from != 0 && from != to {
+		r.flyMap.LinkCheckpoint(from, to)
+	}
+}
+
+// Branch makes the next checkpoint linked to the checkpoint instead of the previous one,
+// zero starts a route not linked to anything.
+func (r *Recorder) Branch(id int) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.flyMap.Checkpoint(id); id != 0 && !ok {
+		return fmt.Errorf("checkpoint %d isn't found", id)
+	}
+	r.last = id
+	// the track goes on from the current position in auto mode
+	r.flying = false
+	return nil
+}
+
+// BranchCommand starts a branch on operator's "branch" command: "branch 3" links the next checkpoint
+// to checkpoint 3, "branch" starts a new route. See controller.Controller.Command.
+func (r *Recorder) BranchCommand(args string) (string, error) {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return "New route started", r.Branch(0)
+	}
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return "", fmt.Errorf("wrong checkpoint %q", args)
+	}
+	if err = r.Branch(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Branch started from checkpoint %d", id), nil
+}
+
+// Command switches auto mode on operator's "autorec on" and "autorec off" commands.
+// See controller.Controller.Command.
+func (r *Recorder) Command(args string) (string, error) {
+	switch strings.TrimSpace(args) {
+	case "on":
+		r.SetAuto(true)
+		return "Checkpoints are recorded automatically", nil
+	case "off":
+		r.SetAuto(false)
+		return "Checkpoints aren't recorded automatically", nil
+	default:
+		return "", fmt.Errorf("unknown autorec mode %q", args)
+	}
+}
+
+// SetAuto switches auto mode, the track of a flight in progress is recorded from the current position.
+func (r *Recorder) SetAuto(auto bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.auto = auto
+	r.flying = false
+}
+
+func (r *Recorder) Run(ctx context.Context) {
+	logrus.Warnf("started map recorder")
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sample(r.poses.GetPose())
+		case <-ctx.Done():
+			logrus.Warnf("stopped map recorder")
+			return
+		}
+	}
+}
+
+// sample adds the pose to the track in auto mode and adds checkpoints where they are needed.
+func (r *Recorder) sample(pose navigator.Pose) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if !r.auto {
+		return
+	}
+	// checkpoints keep Z of the drone like the ones added by the controller
+	p := vector.V3D{pose.X, pose.Y, -pose.Z}
+	flying := pose.Flags&navigator.FlagFlying != 0
+	switch {
+	case flying && !r.flying:
+		r.add(p)
+	case !flying && r.flying:
+		if len(r.track) > 1 && r.track[0].Distance(p) > r.options.Tolerance {
+			r.add(p)
+		}
+		r.track = nil
+	case flying && r.track[len(r.track)-1].Distance(p) >= sampleStep:
+		r.track = append(r.track, p)
+		r.checkTrack()
+	}
+	r.flying = flying
+}
+
+// checkTrack adds checkpoints at turns of the simplified track and at its end if it's long enough.
+func (r *Recorder) checkTrack() {
+	simplified := simplify(r.track, r.options.Tolerance)
+	for i := 1; i+1 < len(simplified); i++ {
+		if turnAngle(simplified[i-1], simplified[i], simplified[i+1]) < r.options.TurnAngle {
+			continue
+		}
+		// the track goes on from the turn
+		var rest []vector.V3D
+		for j, p := range r.track {
+			if p == simplified[i] {
+				rest = r.track[j+1:]
+				break
+			}
+		}
+		r.add(simplified[i])
+		r.track = append(r.track, rest...)
+	}
+	var length float64
+	for i := 1; i < len(r.track); i++ {
+		length += r.track[i-1].Distance(r.track[i])
+	}
+	if length >= r.options.Distance {
+		r.add(r.track[len(r.track)-1])
+	}
+}
+
+// simplify returns the points of the track that deviate from straight lines more than the tolerance,
+// with the first and the last ones (Douglas-Peucker algorithm).
+func simplify(track []vector.V3D, tolerance float64) []vector.V3D {
+	if len(track) < 3 {
+		return track
+	}
+	first, last := track[0], track[len(track)-1]
+	farthest, distance := 0, 0.
+	for i := 1; i+1 < len(track); i++ {
+		if d := segmentDistance(track[i], first, last); d > distance {
+			farthest, distance = i, d
+		}
+	}
+	if distance <= tolerance {
+		return []vector.V3D{first, last}
+	}
+	left := simplify(track[:farthest+1], tolerance)
+	right := simplify(track[farthest:], tolerance)
+	return append(left[:len(left)-1:len(left)-1], right...)
+}
+
+// segmentDistance returns the distance from the point to the segment a-b.
+func segmentDistance(p, a, b vector.V3D) float64 {
+	ab, ap := b.Sub(a), p.Sub(a)
+	lengthSq := dot(ab, ab)
+	if lengthSq == 0 {
+		return p.Distance(a)
+	}
+	t := math.Max(0, math.Min(1, dot(ap, ab)/lengthSq))
+	return p.Distance(a.Add(ab.Scale(t)))
+}
+
+// turnAngle returns the angle in degrees between the directions a-b and b-c.
+func turnAngle(a, b, c vector.V3D) float64 {
+	ab, bc := b.Sub(a), c.Sub(b)
+	cos := dot(ab, bc) / math.Sqrt(dot(ab, ab)*dot(bc, bc))
+	return math.Acos(math.Max(-1, math.Min(1, cos))) * 180 / math.Pi
+}
+
+func dot(a, b vector.V3D) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
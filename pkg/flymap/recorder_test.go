@@ -0,0 +1,108 @@
+package flymap
+
+import (
+	"math"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+func (s *MapSuite) TestSimplify() {
+	var line []vector.V3D
+	for i := 0; i <= 10; i++ {
+		// noise smaller than the tolerance
+		line = append(line, vector.V3D{float64(i) / 10, 0.05 * math.Sin(float64(i)), 0})
+	}
+	s.Equal([]vector.V3D{line[0], line[10]}, simplify(line, 0.1))
+
+	corner := []vector.V3D{{0, 0, 0}, {0.5, 0, 0}, {1, 0, 0}, {1, 0.5, 0}, {1, 1, 0}}
+	s.Equal([]vector.V3D{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}}, simplify(corner, 0.1))
+}
+
+func (s *MapSuite) TestRecorder() {
+	m := New("FlyMap", "map.mtl")
+	r := NewRecorder(m, nil, 0, DefaultRecordOptions, false)
+
+	pose := func(x, y float64, flying bool) navigator.Pose {
+		p := navigator.Pose{X: x, Y: y}
+		if flying {
+			p.Flags = navigator.FlagFlying
+		}
+		return p
+	}
+	r.sample(pose(0, 0, true))
+	s.Empty(m.checkpoints, "nothing is recorded out of auto mode")
+
+	_, err := r.Command("on")
+	s.Require().NoError(err)
+	r.sample(pose(0, 0, false))
+	// take off, fly 2.5 along x with noise, turn left and land after 0.8 along y
+	for i := 0; i <= 25; i++ {
+		r.sample(pose(float64(i)/10, 0.03*math.Sin(float64(i)), true))
+	}
+	for i := 1; i <= 8; i++ {
+		r.sample(pose(2.5, float64(i)/10, true))
+	}
+	r.sample(pose(2.5, 0.8, false))
+
+	s.Require().Len(m.checkpoints, 5)
+	expected := []vector.V3D{{0, 0}, {1, 0}, {2, 0}, {2.5, 0}, {2.5, 0.8}}
+	for i, p := range expected {
+		checkpoint := m.checkpoints[i+1]
+		s.InDelta(0, p.Distance(checkpoint.Position), 0.1, "checkpoint %d", i+1)
+		if i > 0 {
+			s.Equal([]*Checkpoint{m.checkpoints[i]}, checkpoint.Next[:1], "linked to the previous one")
+		}
+	}
+
+	// checkpoints added by operators are linked the same way
+	id, added := r.Add(vector.V3D{3, 1, 0})
+	s.True(added)
+	s.Equal(6, id)
+	s.Equal([]*Checkpoint{m.checkpoints[5]}, m.checkpoints[6].Next)
+
+	_, err = r.Command("sideways")
+	s.Error(err)
+}
+
+func (s *MapSuite) TestRecorderBranches() {
+	m := New("FlyMap", "map.mtl")
+	r := NewRecorder(m, nil, 0, DefaultRecordOptions, false)
+	links := func(id int) []int {
+		var ids []int
+		for _, next := range m.checkpoints[id].Next {
+			ids = append(ids, next.ID)
+		}
+		return ids
+	}
+
+	r.Add(vector.V3D{0, 0, 0})
+	r.Add(vector.V3D{1, 0, 0})
+	r.Add(vector.V3D{2, 0, 0})
+	info, err := r.BranchCommand("2")
+	s.Require().NoError(err)
+	s.Equal("Branch started from checkpoint 2", info)
+	id, _ := r.Add(vector.V3D{1, 1, 0})
+	s.Equal(4, id)
+	s.Equal([]int{2}, links(4))
+
+	// the route joins the nearest checkpoint within the radius
+	id, _ = r.Add(vector.V3D{2, 0.5, 0})
+	s.Equal([]int{4, 3}, links(id))
+
+	// a checkpoint at an existing one is the existing one
+	id, added := r.Add(vector.V3D{2.1, 0.1, 0})
+	s.False(added)
+	s.Equal(3, id)
+	s.Equal([]int{2, 5}, links(3), "links aren't doubled")
+
+	_, err = r.BranchCommand("")
+	s.Require().NoError(err)
+	id, _ = r.Add(vector.V3D{5, 5, 0})
+	s.Empty(links(id))
+
+	_, err = r.BranchCommand("42")
+	s.Error(err)
+	_, err = r.BranchCommand("first")
+	s.Error(err)
+}
@@ -0,0 +1,293 @@
+package flymap
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultMapName is the map flown when no other one was chosen.
+const DefaultMapName = "map"
+
+const (
+	mapExt     = ".obj"
+	activeFile = "active" // name of the map active last time
+)
+
+var (
+	ErrMapNotFound = errors.New("map isn't found")
+	ErrMapExists   = errors.New("map already exists")
+	ErrMapActive   = errors.New("map is active")
+)
+
+var mapNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Store keeps named maps in a directory as <name>.obj files, e.g. one map per room. One of the maps is
+// active: it's flown and edited. Switching maps replaces the contents of the active map in place, so
+// components holding it see the new one without restarting.
+type Store struct {
+	dir    string
+	active *FlyMap
+
+	mux      sync.Mutex
+	name     string
+	switched []func(name string)
+}
+
+// OpenStore opens the store in the directory and loads the named map, an empty name is the map active
+// last time or DefaultMapName. The map is created if it doesn't exist.
+func OpenStore(dir, name string) (*Store, error) {
+	if name == "" {
+		name = DefaultMapName
+		if b, err := os.ReadFile(filepath.Join(dir, activeFile)); err == nil && mapNameRe.Match(b) {
+			name = string(b)
+		}
+	}
+	if err := checkMapName(name); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, name: name}
+	m, err := LoadMap(s.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		m, err = New(name, "map.mtl"), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading map %s: %w", name, err)
+	}
+	s.active = m
+	return s, nil
+}
+
+func checkMapName(name string) error {
+	if !mapNameRe.MatchString(name) {
+		return fmt.Errorf("wrong map name %q, use letters, digits, - and _", name)
+	}
+	return nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+mapExt)
+}
+
+func (s *Store) exists(name string) bool {
+	_, err := os.Stat(s.path(name))
+	return err == nil
+}
+
+// Map returns the active map, it stays the same object when maps are switched.
+func (s *Store) Map() *FlyMap {
+	return s.active
+}
+
+// Name returns the name of the active map.
+func (s *Store) Name() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.name
+}
+
+// OnSwitch registers a function called when another map becomes active, e.g. to forget checkpoints
+// of the previous one.
+func (s *Store) OnSwitch(f func(name string)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.switched = append(s.switched, f)
+}
+
+// List returns the names of the maps in the directory, the active one is there even if it isn't saved yet.
+func (s *Store) List() ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading maps: %w", err)
+	}
+	names := []string{s.name}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), mapExt)
+		if !f.IsDir() && strings.HasSuffix(f.Name(), mapExt) && mapNameRe.MatchString(name) && name != s.name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Save saves the active map.
+func (s *Store) Save() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.save()
+}
+
+func (s *Store) save() error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("error creating maps directory: %w", err)
+	}
+	if err := SaveMap(s.path(s.name), s.active); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, activeFile), []byte(s.name), 0o644)
+}
+
+// SaveAs saves the active map under the new name, which becomes the name of the active map.
+func (s *Store) SaveAs(name string) error {
+	if err := checkMapName(name); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if name != s.name && s.exists(name) {
+		return fmt.Errorf("%w: %s", ErrMapExists, name)
+	}
+	previous := s.name
+	s.name = name
+	s.active.Rename(name)
+	if err := s.save(); err != nil {
+		s.name = previous
+		s.active.Rename(previous)
+		return err
+	}
+	return nil
+}
+
+// Load saves the active map and makes the named one active, loading the active map only saves it.
+func (s *Store) Load(name string) error {
+	if err := checkMapName(name); err != nil {
+		return err
+	}
+	if name == s.Name() {
+		return s.Save()
+	}
+	return s.activate(name, func() (*FlyMap, error) {
+		m, err := LoadMap(s.path(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrMapNotFound, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading map %s: %w", name, err)
+		}
+		return m, nil
+	})
+}
+
+// Create saves the active map and makes the new empty one active.
+func (s *Store) Create(name string) error {
+	if err := checkMapName(name); err != nil {
+		return err
+	}
+	return s.activate(name, func() (*FlyMap, error) {
+		if s.exists(name) {
+			return nil, fmt.Errorf("%w: %s", ErrMapExists, name)
+		}
+		return New(name, s.active.MtlLib), nil
+	})
+}
+
+// activate saves the active map, then the loaded map becomes active. Maps are loaded after saving,
+// so the file of the active map has its latest changes.
+func (s *Store) activate(name string, load func() (*FlyMap, error)) error {
+	s.mux.Lock()
+	if err := s.save(); err != nil {
+		s.mux.Unlock()
+		return fmt.Errorf("error saving map %s: %w", s.name, err)
+	}
+	m, err := load()
+	if err != nil {
+		s.mux.Unlock()
+		return err
+	}
+	s.active.Replace(m)
+	s.name = name
+	err = s.save()
+	switched := s.switched
+	s.mux.Unlock()
+
+	for _, f := range switched {
+		f(name)
+	}
+	return err
+}
+
+// Delete deletes the map, the active map can't be deleted.
+func (s *Store) Delete(name string) error {
+	if err := checkMapName(name); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if name == s.name {
+		return fmt.Errorf("%w: %s", ErrMapActive, name)
+	}
+	if err := os.Remove(s.path(name)); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrMapNotFound, name)
+	} else if err != nil {
+		return fmt.Errorf("error deleting map %s: %w", name, err)
+	}
+	return nil
+}
+
+// Command manages maps on operator's "map" command: "map list", "map save", "map save-as <name>",
+// "map load <name>", "map new <name>" and "map delete <name>". See controller.Controller.Command.
+func (s *Store) Command(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("map command isn't set")
+	}
+	if len(fields) > 2 || (len(fields) == 2) != (fields[0] != "list" && fields[0] != "save") {
+		return "", fmt.Errorf("wrong map command %q", args)
+	}
+	switch fields[0] {
+	case "list":
+		names, err := s.List()
+		if err != nil {
+			return "", err
+		}
+		active := s.Name()
+		for i, name := range names {
+			if name == active {
+				names[i] = name + " (active)"
+			}
+		}
+		return "Maps: " + strings.Join(names, ", "), nil
+	case "save":
+		if err := s.Save(); err != nil {
+			return "", err
+		}
+		return "Map " + s.Name() + " saved", nil
+	case "save-as":
+		if err := s.SaveAs(fields[1]); err != nil {
+			return "", err
+		}
+		return "Map saved as " + fields[1], nil
+	case "load":
+		if err := s.Load(fields[1]); err != nil {
+			return "", err
+		}
+		return "Map " + fields[1] + " loaded", nil
+	case "new":
+		if err := s.Create(fields[1]); err != nil {
+			return "", err
+		}
+		return "Map " + fields[1] + " created", nil
+	case "delete":
+		if err := s.Delete(fields[1]); err != nil {
+			return "", err
+		}
+		return "Map " + fields[1] + " deleted", nil
+	default:
+		return "", fmt.Errorf("unknown map command %q", fields[0])
+	}
+}
@@ -0,0 +1,63 @@
+package flymap
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+func (s *MapSuite) TestStore() {
+	dir := s.T().TempDir()
+	store, err := OpenStore(dir, "")
+	s.Require().NoError(err)
+	s.Equal(DefaultMapName, store.Name())
+	m := store.Map()
+	m.AddCheckpoint(1, 2, 3)
+
+	var switched []string
+	store.OnSwitch(func(name string) { switched = append(switched, name) })
+
+	_, err = store.Command("new kitchen")
+	s.Require().NoError(err)
+	s.Same(m, store.Map(), "components keep the map")
+	_, ok := m.Checkpoint(1)
+	s.False(ok, "new map is empty")
+	s.Equal("kitchen", m.Name)
+	m.AddCheckpoint(4, 5, 6)
+	s.Require().NoError(store.SaveAs("hall"))
+	s.Equal("hall", m.Name, "the map is saved under the new name")
+	saved, err := LoadMap(filepath.Join(dir, "hall.obj"))
+	s.Require().NoError(err)
+	s.Equal("hall", saved.Name)
+	s.ErrorIs(store.SaveAs("map"), ErrMapExists)
+
+	info, err := store.Command("list")
+	s.Require().NoError(err)
+	s.Equal("Maps: hall (active), kitchen, map", info)
+
+	s.Require().NoError(store.Load("map"))
+	s.Equal(vector.V3D{1, 2, 3}, m.GetCheckpoint(1))
+	s.ErrorIs(store.Load("garage"), ErrMapNotFound)
+	s.Error(store.Load("../map"))
+	s.ErrorIs(store.Delete("map"), ErrMapActive)
+	s.Require().NoError(store.Delete("kitchen"))
+	s.ErrorIs(store.Delete("kitchen"), ErrMapNotFound)
+	s.Equal([]string{"kitchen", "map"}, switched)
+
+	// loading the active map keeps its unsaved changes
+	s.Require().NoError(store.Load("hall"))
+	m.AddCheckpoint(7, 8, 9)
+	s.Require().NoError(store.Load("hall"))
+	s.Equal(vector.V3D{7, 8, 9}, m.GetCheckpoint(2))
+	s.Equal([]string{"kitchen", "map", "hall"}, switched)
+
+	// the map active last time is loaded on start
+	reopened, err := OpenStore(dir, "")
+	s.Require().NoError(err)
+	s.Equal("hall", reopened.Name())
+	s.Equal(vector.V3D{4, 5, 6}, reopened.Map().GetCheckpoint(1))
+	s.Equal(vector.V3D{7, 8, 9}, reopened.Map().GetCheckpoint(2))
+	_, err = os.Stat(filepath.Join(dir, "kitchen.obj"))
+	s.ErrorIs(err, os.ErrNotExist)
+}
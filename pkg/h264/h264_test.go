@@ -0,0 +1,80 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type H264Suite struct {
+	suite.Suite
+}
+
+func TestH264Suite(t *testing.T) {
+	suite.Run(t, new(H264Suite))
+}
+
+// Tello 960x720 parameter sets
+var (
+	testSPS = []byte{0x67, 0x4d, 0x40, 0x28, 0x95, 0xa0, 0x3c, 0x05, 0xb9}
+	testPPS = []byte{0x68, 0xee, 0x38, 0x80}
+)
+
+func (s *H264Suite) TestParseSPS() {
+	sps, err := ParseSPS(testSPS)
+	s.Require().NoError(err)
+	s.Equal(960, sps.Width)
+	s.Equal(720, sps.Height)
+	s.Equal("avc1.4d4028", sps.Codec())
+
+	_, err = ParseSPS(testSPS[:5])
+	s.Error(err)
+	_, err = ParseSPS(testPPS)
+	s.Error(err)
+}
+
+func (s *H264Suite) TestUnescape() {
+	s.Equal([]byte{0x65, 0, 0, 1, 0, 0, 3}, Unescape([]byte{0x65, 0, 0, 3, 1, 0, 0, 3, 3}))
+}
+
+func (s *H264Suite) TestSplitAndAssemble() {
+	idr := []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	slice1 := []byte{0x41, 0x9a, 0x02}
+	slice2 := []byte{0x41, 0x9a, 0x04, 0x00} // trailing zero is stripped
+	var stream []byte
+	stream = append(stream, 0xff, 0x00) // garbage before the first start code
+	for _, nalu := range [][]byte{testSPS, testPPS, idr, slice1, slice2} {
+		stream = append(stream, 0, 0, 0, 1)
+		stream = append(stream, nalu...)
+	}
+
+	// the stream is cut into single bytes to test start codes split between blocks
+	var (
+		splitter Splitter
+		nalus    [][]byte
+	)
+	for i := range stream {
+		nalus = append(nalus, splitter.Write(stream[i:i+1])...)
+	}
+	nalus = append(nalus, splitter.Flush())
+	s.Equal([][]byte{testSPS, testPPS, idr, slice1, bytes.TrimRight(slice2, "\x00")}, nalus)
+
+	var (
+		assembler Assembler
+		units     []AccessUnit
+	)
+	for _, nalu := range nalus {
+		if au, ok := assembler.Push(nalu); ok {
+			units = append(units, au)
+		}
+	}
+	s.Require().Len(units, 2)
+	s.True(units[0].Key)
+	s.Equal([][]byte{testSPS, testPPS, idr}, units[0].NALUs)
+	s.False(units[1].Key)
+	s.Equal([][]byte{slice1}, units[1].NALUs)
+	last, ok := assembler.Flush()
+	s.True(ok)
+	s.Equal([][]byte{bytes.TrimRight(slice2, "\x00")}, last.NALUs)
+}
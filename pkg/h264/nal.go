@@ -0,0 +1,162 @@
+// Package h264 parses H.264 Annex B byte streams as sent by the Tello into NAL units and access units.
+package h264
+
+import (
+	"bytes"
+)
+
+// NAL unit types used by the pilot.
+const (
+	NALSlice = 1
+	NALIDR   = 5
+	NALSEI   = 6
+	NALSPS   = 7
+	NALPPS   = 8
+	NALAUD   = 9
+)
+
+// Type returns the type of the NAL unit.
+func Type(nalu []byte) int {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return int(nalu[0] & 0x1f)
+}
+
+// IsVCL reports whether the NAL unit carries picture data.
+func IsVCL(nalu []byte) bool {
+	t := Type(nalu)
+	return t >= NALSlice && t <= NALIDR
+}
+
+// firstSlice reports whether the VCL NAL unit starts a new picture, i.e. its first_mb_in_slice is 0.
+func firstSlice(nalu []byte) bool {
+	return len(nalu) > 1 && nalu[1]&0x80 != 0
+}
+
+var startCode = []byte{0, 0, 1}
+
+// Splitter splits an Annex B byte stream into NAL units. The stream may be cut into blocks anywhere.
+type Splitter struct {
+	buf     []byte
+	started bool // a start code was found, buf holds the current NAL unit
+}
+
+// Write consumes the next block of the stream and returns NAL units completed by it, without start codes.
+// The returned slices aren't modified by later calls.
+func (s *Splitter) Write(block []byte) [][]byte {
+	from := len(s.buf) - len(startCode) + 1
+	if from < 0 {
+		from = 0
+	}
+	s.buf = append(s.buf, block...)
+
+	var nalus [][]byte
+	start := 0
+	for {
+		i := bytes.Index(s.buf[from:], startCode)
+		if i < 0 {
+			break
+		}
+		i += from
+		if s.started {
+			if nalu := bytes.TrimRight(s.buf[start:i], "\x00"); len(nalu) > 0 {
+				nalus = append(nalus, nalu)
+			}
+		}
+		s.started = true
+		start = i + len(startCode)
+		from = start
+	}
+	if !s.started {
+		// garbage before the first start code, keep only bytes that may begin it
+		start = len(s.buf) - len(startCode) + 1
+		if start < 0 {
+			start = 0
+		}
+	}
+	s.buf = append([]byte(nil), s.buf[start:]...)
+	return nalus
+}
+
+// Flush returns the last NAL unit of the stream.
+func (s *Splitter) Flush() []byte {
+	var nalu []byte
+	if s.started {
+		nalu = bytes.TrimRight(s.buf, "\x00")
+	}
+	s.buf, s.started = nil, false
+	return nalu
+}
+
+// Split returns NAL units of a complete Annex B buffer, e.g. an access unit.
+func Split(data []byte) [][]byte {
+	var s Splitter
+	nalus := s.Write(data)
+	if nalu := s.Flush(); len(nalu) > 0 {
+		nalus = append(nalus, nalu)
+	}
+	return nalus
+}
+
+// AccessUnit is the NAL units of a single picture.
+type AccessUnit struct {
+	NALUs [][]byte
+	Key   bool // contains an IDR slice
+}
+
+// Assembler groups NAL units into access units.
+type Assembler struct {
+	cur    AccessUnit
+	hasVCL bool
+}
+
+// Push adds the NAL unit and returns the previous access unit if the NAL unit starts a new one.
+func (a *Assembler) Push(nalu []byte) (AccessUnit, bool) {
+	var (
+		done AccessUnit
+		ok   bool
+	)
+	if a.hasVCL && a.starts(nalu) {
+		done, ok = a.cur, true
+		a.cur, a.hasVCL = AccessUnit{}, false
+	}
+	a.cur.NALUs = append(a.cur.NALUs, nalu)
+	if IsVCL(nalu) {
+		a.hasVCL = true
+		a.cur.Key = a.cur.Key || Type(nalu) == NALIDR
+	}
+	return done, ok
+}
+
+func (a *Assembler) starts(nalu []byte) bool {
+	switch Type(nalu) {
+	case NALAUD, NALSPS, NALPPS, NALSEI:
+		return true
+	case NALSlice, NALIDR:
+		return firstSlice(nalu)
+	default:
+		return false
+	}
+}
+
+// AnnexB returns the access unit as an Annex B byte stream with 4 byte start codes.
+func (au AccessUnit) AnnexB() []byte {
+	size := 0
+	for _, nalu := range au.NALUs {
+		size += 4 + len(nalu)
+	}
+	data := make([]byte, 0, size)
+	for _, nalu := range au.NALUs {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, nalu...)
+	}
+	return data
+}
+
+// Flush returns the last access unit of the stream.
+func (a *Assembler) Flush() (AccessUnit, bool) {
+	done, ok := a.cur, a.hasVCL
+	a.cur, a.hasVCL = AccessUnit{}, false
+	return done, ok
+}
@@ -0,0 +1,175 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errShortSPS = errors.New("sps is too short")
+
+// SPS is the part of the sequence parameter set needed to describe the stream in a container.
+type SPS struct {
+	Profile       byte
+	Compatibility byte
+	Level         byte
+	Width         int
+	Height        int
+}
+
+// Codec returns the RFC 6381 codec string, e.g. "avc1.4d401f".
+func (s SPS) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", s.Profile, s.Compatibility, s.Level)
+}
+
+// Unescape removes emulation prevention bytes from the NAL unit.
+func Unescape(nalu []byte) []byte {
+	out := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// highProfiles have chroma format and scaling lists in their SPS.
+var highProfiles = map[byte]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true,
+	118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// ParseSPS parses the SPS NAL unit.
+func ParseSPS(nalu []byte) (SPS, error) {
+	if Type(nalu) != NALSPS || len(nalu) < 4 {
+		return SPS{}, errShortSPS
+	}
+	data := Unescape(nalu)
+	sps := SPS{Profile: data[1], Compatibility: data[2], Level: data[3]}
+	r := &bitReader{data: data[4:]}
+
+	r.ue() // seq_parameter_set_id
+	chromaFormat := uint(1)
+	if highProfiles[sps.Profile] {
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bits(1) // separate_colour_plane_flag
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.bits(1) // qpprime_y_zero_transform_bypass_flag
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					r.skipScalingList(size)
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se() // offset_for_ref_frame
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.bits(1) // gaps_in_frame_num_value_allowed_flag
+	widthMBs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMBsOnly := r.bits(1)
+	if frameMBsOnly == 0 {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return SPS{}, fmt.Errorf("error parsing sps: %w", r.err)
+	}
+
+	cropUnitX, cropUnitY := uint(1), 2-frameMBsOnly
+	switch chromaFormat {
+	case 1:
+		cropUnitX, cropUnitY = 2, 2*(2-frameMBsOnly)
+	case 2:
+		cropUnitX = 2
+	}
+	sps.Width = int(widthMBs*16 - cropUnitX*(cropLeft+cropRight))
+	sps.Height = int((2-frameMBsOnly)*heightMapUnits*16 - cropUnitY*(cropTop+cropBottom))
+	return sps, nil
+}
+
+// bitReader reads the RBSP bit by bit, the first error is kept in err and further reads return zeros.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = errShortSPS
+			return 0
+		}
+		v = v<<1 | uint(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros > 31 {
+			r.err = errShortSPS
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb code.
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := 8, 8
+	for j := 0; j < size && r.err == nil; j++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
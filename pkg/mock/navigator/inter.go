@@ -47,3 +47,17 @@ func (mr *MockNavMockRecorder) GetPos() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPos", reflect.TypeOf((*MockNav)(nil).GetPos))
 }
+
+// GetPose mocks base method.
+func (m *MockNav) GetPose() navigator.Pose {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPose")
+	ret0, _ := ret[0].(navigator.Pose)
+	return ret0
+}
+
+// GetPose indicates an expected call of GetPose.
+func (mr *MockNavMockRecorder) GetPose() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPose", reflect.TypeOf((*MockNav)(nil).GetPose))
+}
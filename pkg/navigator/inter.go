@@ -2,4 +2,5 @@ package navigator
 
 type Nav interface {
 	GetPos() Position
+	GetPose() Pose
 }
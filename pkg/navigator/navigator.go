@@ -7,6 +7,7 @@ import (
 	"github.com/einherij/pilot/pkg/vector"
 	"github.com/sirupsen/logrus"
 	"sync/atomic"
+	"time"
 )
 
 type Position struct {
@@ -16,7 +17,11 @@ type Position struct {
 
 type Navigator struct {
 	flightData <-chan tello.FlightData
-	currentPos atomic.Pointer[Position] // Position
+	currentPos atomic.Pointer[Position] // Position in the frame of the map
+	rawPos     atomic.Pointer[Position] // Position measured by the drone
+	transform  atomic.Pointer[Transform]
+	lastFD     atomic.Pointer[tello.FlightData]
+	lastUpdate atomic.Pointer[time.Time]
 }
 
 func NewNavigator(flightData <-chan tello.FlightData) *Navigator {
@@ -27,6 +32,11 @@ func NewNavigator(flightData <-chan tello.FlightData) *Navigator {
 		Location: vector.V3D{0, 0, 0},
 		Rotation: vector.V3D{1, 0, 0},
 	})
+	n.rawPos.Store(n.currentPos.Load())
+	n.transform.Store(new(Transform))
+	n.lastFD.Store(new(tello.FlightData))
+	now := time.Now()
+	n.lastUpdate.Store(&now)
 	return n
 }
 
@@ -35,15 +45,19 @@ func (n *Navigator) Run(ctx context.Context) {
 	for {
 		select {
 		case fd := <-n.flightData:
-			currentPos := *(n.currentPos.Load())
-			currentPos.Location = vector.V3D{
+			var rawPos Position
+			rawPos.Location = vector.V3D{
 				float64(fd.MVO.PositionX),
 				float64(fd.MVO.PositionY),
 				float64(-fd.MVO.PositionZ),
 			}
 			singleVector := vector.V3D{1., 0., 0.}
-			currentPos.Rotation = singleVector.RotateZ(float64(fd.IMU.Yaw))
-			n.currentPos.Store(&currentPos)
+			rawPos.Rotation = singleVector.RotateZ(float64(fd.IMU.Yaw))
+			n.rawPos.Store(&rawPos)
+			n.currentPos.Store(n.transform.Load().position(rawPos))
+			n.lastFD.Store(&fd)
+			now := time.Now()
+			n.lastUpdate.Store(&now)
 		case <-ctx.Done():
 			logrus.Warnf("stopped navigation")
 			return
@@ -51,11 +65,49 @@ func (n *Navigator) Run(ctx context.Context) {
 	}
 }
 
+// Transform returns the transform of positions measured by the drone to the map.
+func (n *Navigator) Transform() Transform {
+	return *(n.transform.Load())
+}
+
+// SetTransform replaces the transform of positions measured by the drone to the map.
+func (n *Navigator) SetTransform(t Transform) {
+	n.transform.Store(&t)
+	n.currentPos.Store(t.position(*(n.rawPos.Load())))
+}
+
+// Correct sets the transform so the latest measured position maps to the position x, y of the map
+// with the yaw in degrees, e.g. measured by vision.
+func (n *Navigator) Correct(x, y, yaw float64) {
+	rawPos := *(n.rawPos.Load())
+	rotation := normalizeYaw(yaw - headingYaw(rawPos.Rotation))
+	rotated := rawPos.Location.RotateZ(rotation)
+	n.SetTransform(Transform{
+		Yaw:    rotation,
+		Offset: vector.V3D{x - rotated.X(), y - rotated.Y(), 0},
+	})
+}
+
+// GetRawPos returns the position measured by the drone, before the transform to the map.
+func (n *Navigator) GetRawPos() Position {
+	return *(n.rawPos.Load())
+}
+
 func (n *Navigator) GetPos() Position {
 	pos := *(n.currentPos.Load())
 	return pos
 }
 
+// GetFlightData returns the latest flight data received from the drone.
+func (n *Navigator) GetFlightData() tello.FlightData {
+	return *(n.lastFD.Load())
+}
+
+// GetPose returns the compact telemetry of the latest flight data.
+func (n *Navigator) GetPose() Pose {
+	return newPose(n.GetPos(), n.GetFlightData(), *(n.lastUpdate.Load()))
+}
+
 func (p Position) GetOBJ() []byte {
 	dirLeft := p.Rotation.RotateZ(-135).Add(p.Location)
 	dirRight := p.Rotation.RotateZ(135).Add(p.Location)
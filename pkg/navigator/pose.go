@@ -0,0 +1,73 @@
+package navigator
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/SMerrony/tello"
+)
+
+// Pose flags.
+const (
+	FlagFlying = 1 << iota
+	FlagOnGround
+	FlagHover
+	FlagBatteryLow
+	FlagBatteryCritical
+	FlagDownVisualState
+	FlagErrorState
+)
+
+// Pose is a compact telemetry message sent to peers negotiated it instead of the OBJ position.
+type Pose struct {
+	Time    int64   `json:"time"` // unix milliseconds
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Z       float64 `json:"z"`
+	Yaw     int16   `json:"yaw"` // degrees
+	VX      int16   `json:"vx"`
+	VY      int16   `json:"vy"`
+	VZ      int16   `json:"vz"`
+	Battery int8    `json:"battery"` // percents
+	Flags   int     `json:"flags"`
+}
+
+func newPose(pos Position, fd tello.FlightData, t time.Time) Pose {
+	var flags int
+	for flag, set := range map[int]bool{
+		FlagFlying:          fd.Flying,
+		FlagOnGround:        fd.OnGround,
+		FlagHover:           fd.DroneHover,
+		FlagBatteryLow:      fd.BatteryLow,
+		FlagBatteryCritical: fd.BatteryCritical,
+		FlagDownVisualState: fd.DownVisualState,
+		FlagErrorState:      fd.ErrorState,
+	} {
+		if set {
+			flags |= flag
+		}
+	}
+	return Pose{
+		Time:    t.UnixMilli(),
+		X:       round(pos.Location.X()),
+		Y:       round(pos.Location.Y()),
+		Z:       round(pos.Location.Z()),
+		Yaw:     int16(math.Round(headingYaw(pos.Rotation))), // corrected IMU yaw
+		VX:      fd.MVO.VelocityX,
+		VY:      fd.MVO.VelocityY,
+		VZ:      fd.MVO.VelocityZ,
+		Battery: fd.BatteryPercentage,
+		Flags:   flags,
+	}
+}
+
+// round keeps millimetres, there is no point in sending more digits.
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+func (p Pose) JSON() []byte {
+	data, _ := json.Marshal(p)
+	return data
+}
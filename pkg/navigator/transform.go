@@ -0,0 +1,59 @@
+package navigator
+
+import (
+	"math"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// Transform maps positions measured by the drone to the map: rotation by Yaw degrees around Z, then
+// translation by Offset. The drone measures from where it was switched on, so every flight has its own
+// frame, the transform keeps positions of different flights in the frame of the map. Heights aren't
+// changed, the drone measures them from the ground.
+type Transform struct {
+	Yaw    float64    // degrees
+	Offset vector.V3D // Z is always zero
+}
+
+// Apply maps the position measured by the drone to the map.
+func (t Transform) Apply(v vector.V3D) vector.V3D {
+	return v.RotateZ(t.Yaw).Add(t.Offset)
+}
+
+// Rotate maps the direction measured by the drone to the map, e.g. the heading.
+func (t Transform) Rotate(v vector.V3D) vector.V3D {
+	return v.RotateZ(t.Yaw)
+}
+
+// Invert returns the transform mapping positions of the map to the frame of the drone.
+func (t Transform) Invert() Transform {
+	return Transform{
+		Yaw:    -t.Yaw,
+		Offset: t.Offset.RotateZ(-t.Yaw).Scale(-1),
+	}
+}
+
+// position maps the position measured by the drone to the map.
+func (t Transform) position(raw Position) *Position {
+	return &Position{
+		Location: t.Apply(raw.Location),
+		Rotation: t.Rotate(raw.Rotation),
+	}
+}
+
+// headingYaw returns the yaw of the direction in degrees, the way the drone reports it.
+func headingYaw(direction vector.V3D) float64 {
+	return math.Atan2(direction.Y(), direction.X()) * 180 / math.Pi
+}
+
+// normalizeYaw keeps degrees within (-180, 180].
+func normalizeYaw(yaw float64) float64 {
+	yaw = math.Mod(yaw, 360)
+	switch {
+	case yaw > 180:
+		yaw -= 360
+	case yaw <= -180:
+		yaw += 360
+	}
+	return yaw
+}
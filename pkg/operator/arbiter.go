@@ -0,0 +1,140 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrUnknownSession = errors.New("unknown session")
+	ErrForbidden      = errors.New("forbidden")
+	ErrNoControl      = errors.New("session doesn't hold control")
+)
+
+// Arbiter keeps operator sessions and decides which one of them controls the drone.
+// Sessions with empty ID are commands from a handler server that doesn't support sessions,
+// they get the legacy role and don't need to take control while nobody holds it.
+type Arbiter struct {
+	mux        sync.Mutex
+	legacyRole Role
+	sessions   map[string]Session
+	controller string // ID of the session holding control
+	holding    bool
+}
+
+func NewArbiter(legacyRole Role) *Arbiter {
+	return &Arbiter{
+		legacyRole: legacyRole,
+		sessions:   make(map[string]Session),
+	}
+}
+
+func (a *Arbiter) Join(s Session) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.sessions[s.ID] = s
+}
+
+// Leave removes the session, returns true if it held control.
+func (a *Arbiter) Leave(id string) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	delete(a.sessions, id)
+	if a.holding && a.controller == id {
+		a.holding = false
+		return true
+	}
+	return false
+}
+
+// Reset removes all sessions, e.g. after reconnecting to the handler server.
+func (a *Arbiter) Reset() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.sessions = make(map[string]Session)
+	a.holding = false
+}
+
+func (a *Arbiter) Session(id string) (Session, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.session(id)
+}
+
+func (a *Arbiter) session(id string) (Session, error) {
+	if id == "" {
+		return Session{Role: a.legacyRole}, nil
+	}
+	s, ok := a.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("%w %q", ErrUnknownSession, id)
+	}
+	return s, nil
+}
+
+// TakeControl gives control to the session. With force a session having PermOverride takes control from another one.
+func (a *Arbiter) TakeControl(id string, force bool) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	s, err := a.session(id)
+	if err != nil {
+		return err
+	}
+	if !s.Role.Can(PermControl) {
+		return fmt.Errorf("%w: %s can't take control", ErrForbidden, s.Role)
+	}
+	if a.holding && a.controller != id {
+		if !force || !s.Role.Can(PermOverride) {
+			return ErrNoControl
+		}
+	}
+	a.controller, a.holding = id, true
+	return nil
+}
+
+// ReleaseControl releases control if the session holds it.
+func (a *Arbiter) ReleaseControl(id string) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if !a.holding || a.controller != id {
+		return false
+	}
+	a.holding = false
+	return true
+}
+
+// Controller returns ID of the session holding control.
+func (a *Arbiter) Controller() (id string, ok bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.controller, a.holding
+}
+
+// Authorize checks the session's role has the permission and the session holds control.
+func (a *Arbiter) Authorize(id string, perm Permission) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	s, err := a.session(id)
+	if err != nil {
+		return err
+	}
+	if !s.Role.Can(perm) {
+		return fmt.Errorf("%w: %s has no %s permission", ErrForbidden, s.Role, perm)
+	}
+	if id == "" && !a.holding {
+		return nil // legacy handler server
+	}
+	if !a.holding || a.controller != id {
+		return ErrNoControl
+	}
+	return nil
+}
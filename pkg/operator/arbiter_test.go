@@ -0,0 +1,57 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ArbiterSuite struct {
+	suite.Suite
+
+	arbiter *Arbiter
+}
+
+func TestArbiterSuite(t *testing.T) {
+	suite.Run(t, new(ArbiterSuite))
+}
+
+func (s *ArbiterSuite) SetupTest() {
+	s.arbiter = NewArbiter(RoleAdmin)
+	s.arbiter.Join(Session{ID: "viewer", Role: RoleViewer})
+	s.arbiter.Join(Session{ID: "pilot", Role: RolePilot})
+	s.arbiter.Join(Session{ID: "admin", Role: RoleAdmin})
+}
+
+func (s *ArbiterSuite) TestTakeControl() {
+	s.ErrorIs(s.arbiter.TakeControl("viewer", false), ErrForbidden)
+	s.ErrorIs(s.arbiter.TakeControl("unknown", false), ErrUnknownSession)
+
+	s.NoError(s.arbiter.TakeControl("pilot", false))
+	s.ErrorIs(s.arbiter.TakeControl("admin", false), ErrNoControl)
+	s.NoError(s.arbiter.TakeControl("admin", true), "admin overrides control")
+	s.ErrorIs(s.arbiter.TakeControl("pilot", true), ErrNoControl, "pilot can't override")
+
+	id, ok := s.arbiter.Controller()
+	s.True(ok)
+	s.Equal("admin", id)
+
+	s.False(s.arbiter.ReleaseControl("pilot"))
+	s.True(s.arbiter.Leave("admin"))
+	_, ok = s.arbiter.Controller()
+	s.False(ok)
+}
+
+func (s *ArbiterSuite) TestAuthorize() {
+	s.NoError(s.arbiter.Authorize("", PermMapEdit), "legacy server while nobody holds control")
+	s.ErrorIs(s.arbiter.Authorize("pilot", PermFly), ErrNoControl)
+
+	s.NoError(s.arbiter.TakeControl("pilot", false))
+	s.NoError(s.arbiter.Authorize("pilot", PermTakeOff))
+	s.ErrorIs(s.arbiter.Authorize("pilot", PermMapEdit), ErrForbidden)
+	s.ErrorIs(s.arbiter.Authorize("viewer", PermFly), ErrForbidden)
+	s.ErrorIs(s.arbiter.Authorize("", PermFly), ErrNoControl)
+
+	s.arbiter.Reset()
+	s.ErrorIs(s.arbiter.Authorize("pilot", PermFly), ErrUnknownSession)
+}
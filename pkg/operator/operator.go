@@ -0,0 +1,74 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role of an operator session.
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // watches telemetry and video only
+	RolePilot  Role = "pilot"  // flies the drone
+	RoleAdmin  Role = "admin"  // flies the drone, edits the map and takes control from other pilots
+)
+
+// Permission is required to execute a command.
+type Permission string
+
+const (
+	PermControl  Permission = "control"  // take control of the drone
+	PermFly      Permission = "fly"      // manual movement
+	PermTakeOff  Permission = "take_off" // take off and land
+	PermAutoFly  Permission = "auto_fly" // autonomous flights to home and checkpoints
+	PermMapEdit  Permission = "map_edit" // add checkpoints, change home and the map
+	PermOverride Permission = "override" // take control held by another session
+	PermSettings Permission = "settings" // change drone and pilot settings
+)
+
+var rolePermissions = map[Role]map[Permission]bool{
+	RoleViewer: {},
+	RolePilot: {
+		PermControl: true,
+		PermFly:     true,
+		PermTakeOff: true,
+		PermAutoFly: true,
+	},
+	RoleAdmin: {
+		PermControl:  true,
+		PermFly:      true,
+		PermTakeOff:  true,
+		PermAutoFly:  true,
+		PermMapEdit:  true,
+		PermOverride: true,
+		PermSettings: true,
+	},
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Can reports whether the role has the permission.
+func (r Role) Can(perm Permission) bool {
+	return rolePermissions[r][perm]
+}
+
+// Session is an operator connected to the pilot.
+type Session struct {
+	ID       string
+	Operator string `json:",omitempty"`
+	Role     Role
+}
+
+// Event announces a session joining or leaving, it is sent in wsclient.MTSession message payload.
+type Event struct {
+	Session
+	Left  bool `json:",omitempty"`
+	Reset bool `json:",omitempty"` // all sessions are gone, e.g. the handler server reconnected
+}
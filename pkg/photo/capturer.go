@@ -0,0 +1,162 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// DefaultTimeout limits waiting for the drone to transfer a photo.
+const DefaultTimeout = 10 * time.Second
+
+const (
+	pollPeriod    = 100 * time.Millisecond
+	uploadTimeout = 30 * time.Second
+)
+
+var ErrBusy = errors.New("photo is being taken")
+
+// Photo is written to the JSON sidecar of the JPEG file.
+type Photo struct {
+	Name  string // file name without extension
+	Time  time.Time
+	Label string `json:",omitempty"` // e.g. the waypoint the photo is taken at
+	Pose  navigator.Pose
+}
+
+// Capturer takes photos with the drone's camera and saves them to a directory, tagged with the pose
+// of the drone. If the upload URL is set, photos are uploaded to the handler server too.
+type Capturer struct {
+	dir       string
+	uploadURL string
+	camera    Camera
+	poses     PoseSource
+	wsClient  wsclient.Messenger
+	client    *http.Client
+	timeout   time.Duration
+	busy      atomic.Bool
+}
+
+func New(dir string, camera Camera, poses PoseSource, wsClient wsclient.Messenger, uploadURL string) *Capturer {
+	return &Capturer{
+		dir:       dir,
+		uploadURL: uploadURL,
+		camera:    camera,
+		poses:     poses,
+		wsClient:  wsClient,
+		client:    &http.Client{Timeout: uploadTimeout},
+		timeout:   DefaultTimeout,
+	}
+}
+
+// Command takes a photo on operator's "photo" command, the arguments are its label.
+// See controller.Controller.Command.
+func (c *Capturer) Command(args string) (string, error) {
+	if err := c.Trigger(args); err != nil {
+		return "", err
+	}
+	return "Taking photo", nil
+}
+
+// Trigger takes a photo in background and reports the result to operators.
+func (c *Capturer) Trigger(label string) error {
+	if !c.busy.CompareAndSwap(false, true) {
+		return ErrBusy
+	}
+	go func() {
+		defer c.busy.Store(false)
+		text := "Photo saved"
+		photo, err := c.Capture(label)
+		if err != nil {
+			logrus.Error(err)
+			text = "Photo failed: " + err.Error()
+		} else {
+			text += ": " + photo.Name
+		}
+		c.wsClient.SendMessage(wsclient.Message{
+			Type:    wsclient.MTLog,
+			Content: []byte(text),
+		})
+	}()
+	return nil
+}
+
+// Capture takes a photo, waits until the drone transfers it and saves it with the sidecar.
+func (c *Capturer) Capture(label string) (Photo, error) {
+	photo := Photo{Time: time.Now(), Label: label, Pose: c.poses.GetPose()}
+	photo.Name = "photo-" + strings.Replace(photo.Time.Format("20060102-150405.000"), ".", "-", 1)
+
+	before := c.camera.NumPics()
+	if err := c.camera.TakePicture(); err != nil {
+		return Photo{}, fmt.Errorf("error taking photo: %w", err)
+	}
+	deadline := time.Now().Add(c.timeout)
+	for c.camera.NumPics() <= before {
+		if time.Now().After(deadline) {
+			return Photo{}, fmt.Errorf("photo isn't received in %s", c.timeout)
+		}
+		time.Sleep(pollPeriod)
+	}
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return Photo{}, fmt.Errorf("error creating photos directory: %w", err)
+	}
+	prefix := filepath.Join(c.dir, photo.Name)
+	n, err := c.camera.SaveAllPics(prefix)
+	if err != nil {
+		return Photo{}, fmt.Errorf("error saving photo: %w", err)
+	}
+	// pictures received after a timeout of previous captures are kept with their index
+	jpeg := prefix + ".jpg"
+	if err := os.Rename(fmt.Sprintf("%s_%d.jpg", prefix, n-1), jpeg); err != nil {
+		return Photo{}, fmt.Errorf("error saving photo: %w", err)
+	}
+	sidecar, _ := json.MarshalIndent(photo, "", "  ")
+	if err := os.WriteFile(prefix+".json", sidecar, 0o644); err != nil {
+		return Photo{}, fmt.Errorf("error saving photo sidecar: %w", err)
+	}
+
+	if c.uploadURL != "" {
+		data, err := os.ReadFile(jpeg)
+		if err == nil {
+			err = c.upload(photo.Name+".jpg", data)
+		}
+		if err == nil {
+			err = c.upload(photo.Name+".json", sidecar)
+		}
+		if err != nil {
+			return photo, fmt.Errorf("error uploading photo: %w", err)
+		}
+	}
+	return photo, nil
+}
+
+func (c *Capturer) upload(name string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.uploadURL+"drone/photos/"+name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
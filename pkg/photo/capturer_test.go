@@ -0,0 +1,135 @@
+package photo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+type CapturerSuite struct {
+	suite.Suite
+}
+
+func TestCapturerSuite(t *testing.T) {
+	suite.Run(t, new(CapturerSuite))
+}
+
+// camera delivers a picture on every TakePicture, the way the drone does after a delay.
+type camera struct {
+	mux     sync.Mutex
+	pics    [][]byte
+	deliver bool
+}
+
+func (c *camera) TakePicture() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.deliver {
+		c.pics = append(c.pics, []byte(fmt.Sprintf("jpeg %d", len(c.pics))))
+	}
+	return nil
+}
+
+func (c *camera) NumPics() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.pics)
+}
+
+func (c *camera) SaveAllPics(prefix string) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, pic := range c.pics {
+		if err := os.WriteFile(fmt.Sprintf("%s_%d.jpg", prefix, i), pic, 0o644); err != nil {
+			return 0, err
+		}
+	}
+	n := len(c.pics)
+	c.pics = nil
+	return n, nil
+}
+
+type poseFunc func() navigator.Pose
+
+func (f poseFunc) GetPose() navigator.Pose {
+	return f()
+}
+
+// messenger collects sent messages.
+type messenger struct {
+	sent chan wsclient.Message
+}
+
+func (m *messenger) SendMessage(msg wsclient.Message) { m.sent <- msg }
+func (m *messenger) ReceiveMessage(ctx context.Context) wsclient.Message {
+	<-ctx.Done()
+	return wsclient.Message{}
+}
+func (m *messenger) Accepts(wsclient.MessageType) bool { return true }
+
+func (s *CapturerSuite) TestCapture() {
+	uploads := make(map[string]string)
+	var uploadsMux sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		uploadsMux.Lock()
+		uploads[r.Method+" "+r.URL.Path] = string(data)
+		uploadsMux.Unlock()
+	}))
+	defer server.Close()
+
+	dir := s.T().TempDir()
+	pose := navigator.Pose{Time: 1000, X: 1, Y: 2, Z: 3, Yaw: 90}
+	c := New(dir, &camera{deliver: true}, poseFunc(func() navigator.Pose { return pose }), nil, server.URL+"/")
+
+	photo, err := c.Capture("checkpoint 1")
+	s.Require().NoError(err)
+	s.Equal("checkpoint 1", photo.Label)
+	s.Equal(pose, photo.Pose)
+
+	jpeg, err := os.ReadFile(filepath.Join(dir, photo.Name+".jpg"))
+	s.Require().NoError(err)
+	s.Equal("jpeg 0", string(jpeg))
+	sidecar, err := os.ReadFile(filepath.Join(dir, photo.Name+".json"))
+	s.Require().NoError(err)
+	var saved Photo
+	s.Require().NoError(json.Unmarshal(sidecar, &saved))
+	s.Equal(photo.Name, saved.Name)
+	s.Equal(pose, saved.Pose)
+	s.True(photo.Time.Equal(saved.Time))
+
+	s.Equal(map[string]string{
+		"PUT /drone/photos/" + photo.Name + ".jpg":  "jpeg 0",
+		"PUT /drone/photos/" + photo.Name + ".json": string(sidecar),
+	}, uploads)
+}
+
+func (s *CapturerSuite) TestTriggerTimeout() {
+	ws := &messenger{sent: make(chan wsclient.Message, 1)}
+	c := New(s.T().TempDir(), &camera{}, poseFunc(func() navigator.Pose { return navigator.Pose{} }), ws, "")
+	c.timeout = 200 * time.Millisecond
+
+	s.Require().NoError(c.Trigger(""))
+	s.ErrorIs(c.Trigger(""), ErrBusy)
+	select {
+	case msg := <-ws.sent:
+		s.EqualValues(wsclient.MTLog, msg.Type)
+		s.Equal("Photo failed: photo isn't received in 200ms", string(msg.Content))
+	case <-time.After(time.Second):
+		s.Fail("no result")
+	}
+	s.Eventually(func() bool { return !c.busy.Load() }, time.Second, 10*time.Millisecond)
+}
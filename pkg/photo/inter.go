@@ -0,0 +1,16 @@
+package photo
+
+import "github.com/einherij/pilot/pkg/navigator"
+
+// Camera takes pictures and keeps them in memory until saved, e.g. tello.Tello.
+type Camera interface {
+	TakePicture() error
+	NumPics() int
+	// SaveAllPics writes the pictures to prefix_N.jpg files and forgets them.
+	SaveAllPics(prefix string) (int, error)
+}
+
+// PoseSource provides the latest pose, e.g. navigator.Navigator.
+type PoseSource interface {
+	GetPose() navigator.Pose
+}
@@ -0,0 +1,17 @@
+package recorder
+
+import (
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/videosender"
+)
+
+// VideoSource fans out the video of the drone, e.g. videosender.Broadcaster.
+type VideoSource interface {
+	Subscribe(buffer int) *videosender.Subscription
+	Unsubscribe(s *videosender.Subscription)
+}
+
+// PoseSource provides the latest pose, e.g. navigator.Navigator.
+type PoseSource interface {
+	GetPose() navigator.Pose
+}
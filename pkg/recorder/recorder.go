@@ -0,0 +1,239 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/videosender"
+)
+
+// DefaultPosePeriod is used when the recorder is created with non-positive period.
+const DefaultPosePeriod = 100 * time.Millisecond
+
+// File extensions of a recording, files share the name of the recording.
+const (
+	VideoExt = ".h264"        // raw Annex B stream of the drone
+	PosesExt = ".poses.jsonl" // Sample per line
+)
+
+var (
+	ErrRecording    = errors.New("already recording")
+	ErrNotRecording = errors.New("not recording")
+)
+
+// Sample is a line of the poses file. Frame is the number of video frames written before the pose was
+// sampled, so the footage can be aligned with the trajectory.
+type Sample struct {
+	Frame int64 `json:"frame"`
+	navigator.Pose
+}
+
+// Recorder writes the video of the drone and its poses to a directory, a pair of files per recording.
+// In auto mode a recording is started on take off and stopped on landing.
+type Recorder struct {
+	dir    string
+	video  VideoSource
+	poses  PoseSource
+	period time.Duration
+	auto   bool
+
+	mux     sync.Mutex
+	current *recording
+}
+
+type recording struct {
+	name    string
+	auto    bool // started on take off
+	sub     *videosender.Subscription
+	video   *os.File
+	poses   *os.File
+	enc     *json.Encoder
+	frames  atomic.Int64
+	written chan struct{} // closed when the video is written
+}
+
+func New(dir string, video VideoSource, poses PoseSource, period time.Duration, auto bool) *Recorder {
+	if period <= 0 {
+		period = DefaultPosePeriod
+	}
+	return &Recorder{
+		dir:    dir,
+		video:  video,
+		poses:  poses,
+		period: period,
+		auto:   auto,
+	}
+}
+
+// Start starts a new recording and returns its name.
+func (r *Recorder) Start() (string, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.start(false)
+}
+
+// Stop finishes the current recording and returns its name.
+func (r *Recorder) Stop() (string, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.stop()
+}
+
+// Recording returns the name of the current recording, if any.
+func (r *Recorder) Recording() (string, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.current == nil {
+		return "", false
+	}
+	return r.current.name, true
+}
+
+// Command executes "start" and "stop" commands of operators, see controller.Controller.Command.
+func (r *Recorder) Command(args string) (string, error) {
+	switch args {
+	case "start":
+		name, err := r.Start()
+		if err != nil {
+			return "", err
+		}
+		return "Recording " + name + " started", nil
+	case "stop":
+		name, err := r.Stop()
+		if err != nil {
+			return "", err
+		}
+		return "Recording " + name + " stopped", nil
+	default:
+		return "", fmt.Errorf("unknown recorder command %q", args)
+	}
+}
+
+func (r *Recorder) Run(ctx context.Context) {
+	logrus.Warnf("started recorder")
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	var flying bool
+	for {
+		select {
+		case <-ticker.C:
+			pose := r.poses.GetPose()
+			wasFlying := flying
+			flying = pose.Flags&navigator.FlagFlying != 0
+			r.sample(pose, flying && !wasFlying, !flying && wasFlying)
+		case <-ctx.Done():
+			_, _ = r.Stop()
+			logrus.Warnf("stopped recorder")
+			return
+		}
+	}
+}
+
+// sample writes the pose to the current recording, starting or stopping it in auto mode.
+func (r *Recorder) sample(pose navigator.Pose, tookOff, landed bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.auto && tookOff && r.current == nil {
+		if _, err := r.start(true); err != nil {
+			logrus.Error(err)
+		}
+	}
+	if r.current == nil {
+		return
+	}
+	if err := r.current.enc.Encode(Sample{Frame: r.current.frames.Load(), Pose: pose}); err != nil {
+		logrus.Error(fmt.Errorf("error writing poses of %s: %w", r.current.name, err))
+	}
+	// recordings started by an operator are stopped by an operator too
+	if r.auto && landed && r.current.auto {
+		_, _ = r.stop()
+	}
+}
+
+func (r *Recorder) start(auto bool) (string, error) {
+	if r.current != nil {
+		return "", ErrRecording
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating recordings directory: %w", err)
+	}
+	// recordings started within a second get a suffix
+	base := "flight-" + time.Now().Format("20060102-150405")
+	name := base
+	video, err := os.OpenFile(filepath.Join(r.dir, name+VideoExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	for i := 2; errors.Is(err, fs.ErrExist); i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+		video, err = os.OpenFile(filepath.Join(r.dir, name+VideoExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error creating video file: %w", err)
+	}
+	poses, err := os.OpenFile(filepath.Join(r.dir, name+PosesExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		_ = video.Close()
+		return "", fmt.Errorf("error creating poses file: %w", err)
+	}
+	rec := &recording{
+		name:    name,
+		auto:    auto,
+		sub:     r.video.Subscribe(0),
+		video:   video,
+		poses:   poses,
+		enc:     json.NewEncoder(poses),
+		written: make(chan struct{}),
+	}
+	go rec.writeVideo()
+	r.current = rec
+	logrus.Warnf("started recording %s", name)
+	return name, nil
+}
+
+func (r *Recorder) stop() (string, error) {
+	rec := r.current
+	if rec == nil {
+		return "", ErrNotRecording
+	}
+	r.current = nil
+	r.video.Unsubscribe(rec.sub)
+	<-rec.written
+	if err := rec.video.Close(); err != nil {
+		logrus.Error(fmt.Errorf("error closing video of %s: %w", rec.name, err))
+	}
+	if err := rec.poses.Close(); err != nil {
+		logrus.Error(fmt.Errorf("error closing poses of %s: %w", rec.name, err))
+	}
+	logrus.Warnf("stopped recording %s, %d frames", rec.name, rec.frames.Load())
+	return rec.name, nil
+}
+
+// writeVideo writes frames until the subscription is cancelled, the stream starts from a key frame.
+func (rec *recording) writeVideo() {
+	defer close(rec.written)
+	var failed bool
+	for frame := range rec.sub.Frames() {
+		if failed {
+			continue
+		}
+		if _, err := rec.video.Write(frame); err != nil {
+			logrus.Error(fmt.Errorf("error writing video of %s: %w", rec.name, err))
+			failed = true
+			continue
+		}
+		rec.frames.Add(1)
+	}
+}
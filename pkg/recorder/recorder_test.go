@@ -0,0 +1,112 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/navigator"
+	"github.com/einherij/pilot/pkg/videosender"
+)
+
+type RecorderSuite struct {
+	suite.Suite
+	dir   string
+	video *videosender.Broadcaster
+}
+
+func TestRecorderSuite(t *testing.T) {
+	suite.Run(t, new(RecorderSuite))
+}
+
+func (s *RecorderSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.video = videosender.NewBroadcaster(nil, nil)
+}
+
+type poseFunc func() navigator.Pose
+
+func (f poseFunc) GetPose() navigator.Pose {
+	return f()
+}
+
+func (s *RecorderSuite) TestRecording() {
+	r := New(s.dir, s.video, poseFunc(func() navigator.Pose { return navigator.Pose{} }), 0, false)
+
+	info, err := r.Command("start")
+	s.Require().NoError(err)
+	name, ok := r.Recording()
+	s.Require().True(ok)
+	s.Equal("Recording "+name+" started", info)
+	_, err = r.Start()
+	s.ErrorIs(err, ErrRecording)
+
+	s.video.Publish([]byte{0, 0, 0, 1, 0x65, 1}, true)
+	s.video.Publish([]byte{0, 0, 0, 1, 0x41, 2}, false)
+	s.Eventually(func() bool { return r.current.frames.Load() == 2 }, time.Second, 10*time.Millisecond)
+	r.sample(navigator.Pose{Time: 1000, X: 1.5}, false, false)
+	s.video.Publish([]byte{0, 0, 0, 1, 0x41, 3}, false)
+	s.Eventually(func() bool { return r.current.frames.Load() == 3 }, time.Second, 10*time.Millisecond)
+	r.sample(navigator.Pose{Time: 1100, X: 2}, false, false)
+
+	_, err = r.Command("stop")
+	s.Require().NoError(err)
+	_, ok = r.Recording()
+	s.False(ok)
+	_, err = r.Stop()
+	s.ErrorIs(err, ErrNotRecording)
+
+	video, err := os.ReadFile(filepath.Join(s.dir, name+VideoExt))
+	s.Require().NoError(err)
+	s.Equal([]byte{0, 0, 0, 1, 0x65, 1, 0, 0, 0, 1, 0x41, 2, 0, 0, 0, 1, 0x41, 3}, video)
+	s.Equal([]Sample{
+		{Frame: 2, Pose: navigator.Pose{Time: 1000, X: 1.5}},
+		{Frame: 3, Pose: navigator.Pose{Time: 1100, X: 2}},
+	}, s.readSamples(name))
+}
+
+func (s *RecorderSuite) TestAutoRecording() {
+	r := New(s.dir, s.video, nil, 0, true)
+
+	r.sample(navigator.Pose{Time: 1000}, false, false)
+	_, ok := r.Recording()
+	s.False(ok)
+
+	r.sample(navigator.Pose{Time: 1100, Flags: navigator.FlagFlying}, true, false)
+	name, ok := r.Recording()
+	s.Require().True(ok)
+	r.sample(navigator.Pose{Time: 1200}, false, true)
+	_, ok = r.Recording()
+	s.False(ok)
+	s.Equal([]Sample{
+		{Pose: navigator.Pose{Time: 1100, Flags: navigator.FlagFlying}},
+		{Pose: navigator.Pose{Time: 1200}},
+	}, s.readSamples(name))
+
+	// a recording started by an operator isn't stopped on landing
+	_, err := r.Start()
+	s.Require().NoError(err)
+	r.sample(navigator.Pose{}, false, true)
+	_, ok = r.Recording()
+	s.True(ok)
+}
+
+func (s *RecorderSuite) readSamples(name string) []Sample {
+	f, err := os.Open(filepath.Join(s.dir, name+PosesExt))
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+
+	var samples []Sample
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var sample Sample
+		s.Require().NoError(json.Unmarshal(sc.Bytes(), &sample))
+		samples = append(samples, sample)
+	}
+	return samples
+}
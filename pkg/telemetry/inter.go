@@ -0,0 +1,8 @@
+package telemetry
+
+import "github.com/SMerrony/tello"
+
+// Source provides the latest flight data, e.g. navigator.Navigator.
+type Source interface {
+	GetFlightData() tello.FlightData
+}
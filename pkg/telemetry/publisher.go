@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// DefaultPeriod is used when the publisher is created with non-positive period.
+const DefaultPeriod = 500 * time.Millisecond
+
+// Publisher periodically sends Snapshot of the flight data as MTTelemetry message.
+type Publisher struct {
+	wsClient wsclient.Messenger
+	source   Source
+	period   time.Duration
+}
+
+func New(wsClient wsclient.Messenger, source Source, period time.Duration) *Publisher {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	return &Publisher{
+		wsClient: wsClient,
+		source:   source,
+		period:   period,
+	}
+}
+
+func (p *Publisher) Run(ctx context.Context) {
+	logrus.Warnf("started telemetry publisher")
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !p.wsClient.Accepts(wsclient.MTTelemetry) {
+				continue
+			}
+			payload, err := json.Marshal(NewSnapshot(p.source.GetFlightData(), time.Now()))
+			if err != nil {
+				logrus.Error(fmt.Errorf("error encoding telemetry: %w", err))
+				continue
+			}
+			p.wsClient.SendMessage(wsclient.Message{
+				Type:    wsclient.MTTelemetry,
+				Payload: payload,
+			})
+		case <-ctx.Done():
+			logrus.Warnf("stopped telemetry publisher")
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SMerrony/tello"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+type TelemetrySuite struct {
+	suite.Suite
+}
+
+func TestTelemetrySuite(t *testing.T) {
+	suite.Run(t, new(TelemetrySuite))
+}
+
+type messenger struct {
+	sent    chan wsclient.Message
+	accepts atomic.Bool
+}
+
+func (m *messenger) SendMessage(msg wsclient.Message) { m.sent <- msg }
+func (m *messenger) ReceiveMessage(ctx context.Context) wsclient.Message {
+	<-ctx.Done()
+	return wsclient.Message{}
+}
+func (m *messenger) Accepts(t wsclient.MessageType) bool {
+	return t == wsclient.MTTelemetry && m.accepts.Load()
+}
+
+type source struct {
+	fd tello.FlightData
+}
+
+func (s *source) GetFlightData() tello.FlightData { return s.fd }
+
+func (s *TelemetrySuite) TestSnapshot() {
+	var fd tello.FlightData
+	fd.Height = 15
+	fd.Flying = true
+	fd.BatteryPercentage = 80
+	fd.BatteryLow = true
+	fd.WifiStrength = 90
+	fd.IMU.Yaw = -45
+	fd.MVO.PositionX = 1.5
+	fd.NorthSpeed = 3
+	fd.ErrorState = true
+
+	snapshot := NewSnapshot(fd, time.UnixMilli(1700000000123))
+	s.Equal(int64(1700000000123), snapshot.Time)
+	s.Equal(1.5, snapshot.Height, "decimetres are converted to metres")
+	s.True(snapshot.Flying)
+	s.Equal(Battery{Percentage: 80, Low: true}, snapshot.Battery)
+	s.Equal(uint8(90), snapshot.Wifi.Strength)
+	s.Equal(int16(-45), snapshot.IMU.Yaw)
+	s.Equal(float32(1.5), snapshot.MVO.PositionX)
+	s.Equal(int16(3), snapshot.Speed.North)
+	s.True(snapshot.Errors.Error)
+
+	data, err := json.Marshal(snapshot)
+	s.Require().NoError(err)
+	s.Contains(string(data), `"battery":{"percentage":80,"milliVolts":0,"low":true`)
+}
+
+func (s *TelemetrySuite) TestPublisher() {
+	ws := &messenger{sent: make(chan wsclient.Message, 10)}
+	src := &source{}
+	src.fd.BatteryPercentage = 42
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go New(ws, src, 10*time.Millisecond).Run(ctx)
+
+	// nothing is sent until the other side accepts telemetry
+	time.Sleep(50 * time.Millisecond)
+	s.Empty(ws.sent)
+
+	ws.accepts.Store(true)
+	select {
+	case msg := <-ws.sent:
+		s.EqualValues(wsclient.MTTelemetry, msg.Type)
+		var snapshot Snapshot
+		s.Require().NoError(json.Unmarshal(msg.Payload, &snapshot))
+		s.Equal(int8(42), snapshot.Battery.Percentage)
+	case <-time.After(time.Second):
+		s.Fail("telemetry isn't sent")
+	}
+}
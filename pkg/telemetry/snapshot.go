@@ -0,0 +1,138 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/SMerrony/tello"
+)
+
+// Snapshot is a structured copy of tello.FlightData sent to the UI dashboard.
+type Snapshot struct {
+	Time int64 `json:"time"` // unix milliseconds
+
+	Height      float64 `json:"height"` // metres
+	MaxHeight   uint8   `json:"maxHeight"`
+	FlyMode     uint8   `json:"flyMode"`
+	Flying      bool    `json:"flying"`
+	OnGround    bool    `json:"onGround"`
+	Hover       bool    `json:"hover"`
+	FlyTime     int16   `json:"flyTime"`     // deciseconds since take off
+	FlyTimeLeft int16   `json:"flyTimeLeft"` // remaining flight time reported by the drone
+
+	Battery Battery `json:"battery"`
+	Wifi    Wifi    `json:"wifi"`
+	IMU     IMU     `json:"imu"`
+	MVO     MVO     `json:"mvo"`
+	Speed   Speed   `json:"speed"`
+	Errors  Errors  `json:"errors"`
+
+	LightStrength uint8 `json:"lightStrength"`
+}
+
+type Battery struct {
+	Percentage   int8  `json:"percentage"`
+	MilliVolts   int16 `json:"milliVolts"`
+	Low          bool  `json:"low"`
+	Critical     bool  `json:"critical"`
+	LowThreshold uint8 `json:"lowThreshold"`
+}
+
+type Wifi struct {
+	Strength     uint8 `json:"strength"`
+	Interference uint8 `json:"interference"`
+}
+
+type IMU struct {
+	QuaternionW float32 `json:"qw"`
+	QuaternionX float32 `json:"qx"`
+	QuaternionY float32 `json:"qy"`
+	QuaternionZ float32 `json:"qz"`
+	Temperature int16   `json:"temperature"`
+	Yaw         int16   `json:"yaw"`
+}
+
+type MVO struct {
+	PositionX float32 `json:"px"`
+	PositionY float32 `json:"py"`
+	PositionZ float32 `json:"pz"`
+	VelocityX int16   `json:"vx"`
+	VelocityY int16   `json:"vy"`
+	VelocityZ int16   `json:"vz"`
+}
+
+type Speed struct {
+	North    int16 `json:"north"`
+	East     int16 `json:"east"`
+	Vertical int16 `json:"vertical"`
+	Ground   int16 `json:"ground"`
+}
+
+// Errors are the drone's state flags signalling a problem.
+type Errors struct {
+	Error           bool `json:"error"`
+	DownVisualState bool `json:"downVisualState"`
+	ImuState        bool `json:"imuState"`
+	PressureState   bool `json:"pressureState"`
+	PowerState      bool `json:"powerState"`
+	WindState       bool `json:"windState"`
+	GravityState    bool `json:"gravityState"`
+	OutageRecording bool `json:"outageRecording"`
+}
+
+func NewSnapshot(fd tello.FlightData, t time.Time) Snapshot {
+	return Snapshot{
+		Time:        t.UnixMilli(),
+		Height:      float64(fd.Height) / 10.,
+		MaxHeight:   fd.MaxHeight,
+		FlyMode:     fd.FlyMode,
+		Flying:      fd.Flying,
+		OnGround:    fd.OnGround,
+		Hover:       fd.DroneHover,
+		FlyTime:     fd.FlyTime,
+		FlyTimeLeft: fd.DroneFlyTimeLeft,
+		Battery: Battery{
+			Percentage:   fd.BatteryPercentage,
+			MilliVolts:   fd.BatteryMilliVolts,
+			Low:          fd.BatteryLow,
+			Critical:     fd.BatteryCritical,
+			LowThreshold: fd.LowBatteryThreshold,
+		},
+		Wifi: Wifi{
+			Strength:     fd.WifiStrength,
+			Interference: fd.WifiInterference,
+		},
+		IMU: IMU{
+			QuaternionW: fd.IMU.QuaternionW,
+			QuaternionX: fd.IMU.QuaternionX,
+			QuaternionY: fd.IMU.QuaternionY,
+			QuaternionZ: fd.IMU.QuaternionZ,
+			Temperature: fd.IMU.Temperature,
+			Yaw:         fd.IMU.Yaw,
+		},
+		MVO: MVO{
+			PositionX: fd.MVO.PositionX,
+			PositionY: fd.MVO.PositionY,
+			PositionZ: fd.MVO.PositionZ,
+			VelocityX: fd.MVO.VelocityX,
+			VelocityY: fd.MVO.VelocityY,
+			VelocityZ: fd.MVO.VelocityZ,
+		},
+		Speed: Speed{
+			North:    fd.NorthSpeed,
+			East:     fd.EastSpeed,
+			Vertical: fd.VerticalSpeed,
+			Ground:   fd.GroundSpeed,
+		},
+		Errors: Errors{
+			Error:           fd.ErrorState,
+			DownVisualState: fd.DownVisualState,
+			ImuState:        fd.ImuState,
+			PressureState:   fd.PressureState,
+			PowerState:      fd.PowerState,
+			WindState:       fd.WindState,
+			GravityState:    fd.GravityState,
+			OutageRecording: fd.OutageRecording,
+		},
+		LightStrength: fd.LightStrength,
+	}
+}
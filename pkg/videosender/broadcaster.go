@@ -0,0 +1,274 @@
+package videosender
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+// DefaultSubscriptionBuffer is the number of frames queued for a subscriber, 2 seconds of the Tello's stream.
+const DefaultSubscriptionBuffer = 60
+
+const (
+	// keyRequestInterval limits key frame requests, the drone answers in tens of milliseconds
+	keyRequestInterval = time.Second
+	// maxCachedFrames limits the frames since the last key frame cached for joining subscribers
+	maxCachedFrames = DefaultSubscriptionBuffer
+)
+
+// Broadcaster fans the H.264 stream of the drone out to multiple consumers, e.g. streaming, recording
+// and analysis. Every subscriber has its own buffer, a subscriber that can't keep up loses frames
+// until the next key frame, so it always gets a decodable stream. Frames are access units in
+// Annex B format, so a subscription can replace the stream of the drone for any consumer.
+//
+// The drone sends parameter sets and a key frame only when asked. The broadcaster caches the latest ones
+// with the frames following the key frame, so a joining subscriber starts decoding at once, and asks
+// for a new key frame only when a subscriber waits for it, e.g. after a decode error.
+type Broadcaster struct {
+	source     <-chan []byte
+	requestKey func()
+	stats      *Stats
+
+	mux         sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+	sps, pps    []byte   // latest parameter sets, without start codes
+	gop         [][]byte // frames since the latest key frame, nil if there are too many
+	lastRequest time.Time
+}
+
+// Subscription receives frames of the broadcaster.
+type Subscription struct {
+	frames  chan []byte
+	waitKey bool // guarded by Broadcaster.mux
+	dropped atomic.Uint64
+}
+
+// Frames returns the channel of frames, it's closed when the stream ends or the subscription is cancelled.
+func (s *Subscription) Frames() <-chan []byte {
+	return s.frames
+}
+
+// Dropped returns the number of frames dropped because the subscriber couldn't keep up.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// NewBroadcaster creates a broadcaster of the source stream, if source is nil frames are published with Publish.
+// requestKey asks the source for parameter sets and a key frame, e.g. tello.Tello.GetVideoSpsPps, it may be nil.
+func NewBroadcaster(source <-chan []byte, requestKey func()) *Broadcaster {
+	return &Broadcaster{
+		source:      source,
+		requestKey:  requestKey,
+		stats:       NewStats(),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Stats returns the stats of the source stream measured by Run.
+func (b *Broadcaster) Stats() *Stats {
+	return b.stats
+}
+
+// Subscribe adds a subscriber with the buffer of frames. It starts receiving from the cached key frame
+// if the frames following it fit the buffer, otherwise from the next key frame.
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+	s := &Subscription{frames: make(chan []byte, buffer), waitKey: true}
+
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		close(s.frames)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+	if len(b.gop) > 0 && len(b.gop) <= buffer {
+		for _, frame := range b.gop {
+			s.frames <- frame
+		}
+		s.waitKey = false
+	}
+	wait := s.waitKey
+	b.mux.Unlock()
+
+	if wait {
+		b.RequestKeyFrame()
+	}
+	return s
+}
+
+// RequestKeyFrame asks the source for a key frame, e.g. when a consumer can't decode the stream.
+// Requests are sent at most once a second.
+func (b *Broadcaster) RequestKeyFrame() {
+	if b.requestKey == nil {
+		return
+	}
+	b.mux.Lock()
+	now := time.Now()
+	if now.Sub(b.lastRequest) < keyRequestInterval {
+		b.mux.Unlock()
+		return
+	}
+	b.lastRequest = now
+	b.mux.Unlock()
+
+	b.requestKey()
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Broadcaster) Unsubscribe(s *Subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.frames)
+	}
+}
+
+// Publish sends the frame to every subscriber not waiting for a key frame, it never blocks.
+func (b *Broadcaster) Publish(frame []byte, key bool) {
+	b.mux.Lock()
+	needKey := b.publish(frame, frame, key)
+	b.mux.Unlock()
+
+	if needKey {
+		b.RequestKeyFrame()
+	}
+}
+
+// PublishUnit publishes the H.264 access unit. Subscribers starting from a key frame get it with the cached
+// parameter sets if it has none.
+func (b *Broadcaster) PublishUnit(au h264.AccessUnit) {
+	frame := au.AnnexB()
+	b.mux.Lock()
+	needKey := b.publish(frame, b.withParams(au, frame), au.Key)
+	b.mux.Unlock()
+
+	if needKey {
+		b.RequestKeyFrame()
+	}
+}
+
+// publish caches the frame and sends it, or start to subscribers starting from it.
+// It returns true if a subscriber waits for a key frame.
+func (b *Broadcaster) publish(frame, start []byte, key bool) bool {
+	switch {
+	case key:
+		b.gop = [][]byte{start}
+	case b.gop != nil && len(b.gop) < maxCachedFrames:
+		b.gop = append(b.gop, frame)
+	default:
+		b.gop = nil
+	}
+
+	var needKey bool
+	for s := range b.subscribers {
+		f := frame
+		if s.waitKey {
+			if !key {
+				needKey = true
+				continue
+			}
+			f = start
+		}
+		select {
+		case s.frames <- f:
+			s.waitKey = false
+		default:
+			s.waitKey = true
+			s.dropped.Add(1)
+			needKey = true
+		}
+	}
+	return needKey
+}
+
+// withParams remembers parameter sets of the access unit and returns the key frame with cached ones
+// prepended if it lacks them.
+func (b *Broadcaster) withParams(au h264.AccessUnit, frame []byte) []byte {
+	var hasSPS, hasPPS bool
+	for _, nalu := range au.NALUs {
+		switch h264.Type(nalu) {
+		case h264.NALSPS:
+			b.sps, hasSPS = nalu, true
+		case h264.NALPPS:
+			b.pps, hasPPS = nalu, true
+		}
+	}
+	if !au.Key || b.sps == nil || b.pps == nil || hasSPS && hasPPS {
+		return frame
+	}
+	complete := h264.AccessUnit{NALUs: [][]byte{b.sps, b.pps}, Key: true}
+	for _, nalu := range au.NALUs {
+		if t := h264.Type(nalu); t != h264.NALSPS && t != h264.NALPPS {
+			complete.NALUs = append(complete.NALUs, nalu)
+		}
+	}
+	return complete.AnnexB()
+}
+
+// Close ends the stream for all subscribers.
+func (b *Broadcaster) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		close(s.frames)
+	}
+}
+
+// Run publishes access units of the source stream until it's closed or ctx is done.
+func (b *Broadcaster) Run(ctx context.Context) {
+	logrus.Warnf("started video broadcaster")
+	defer func() {
+		b.Close()
+		logrus.Warnf("stopped video broadcaster")
+	}()
+
+	var (
+		splitter  h264.Splitter
+		assembler h264.Assembler
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case block, ok := <-b.source:
+			if !ok {
+				logrus.Warnf("video stream closed")
+				if nalu := splitter.Flush(); nalu != nil {
+					if au, ok := assembler.Push(nalu); ok {
+						b.PublishUnit(au)
+					}
+				}
+				if au, ok := assembler.Flush(); ok {
+					b.PublishUnit(au)
+				}
+				return
+			}
+			now := time.Now()
+			b.stats.AddBlock(len(block), now)
+			for _, nalu := range splitter.Write(block) {
+				b.stats.AddNALU(nalu)
+				if au, ok := assembler.Push(nalu); ok {
+					b.stats.AddFrame(au.Key, now)
+					b.PublishUnit(au)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package videosender
+
+import (
+	"context"
+	"time"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+func (s *PackagerSuite) TestBroadcasterSlowSubscriber() {
+	b := NewBroadcaster(nil, nil)
+	fast := b.Subscribe(10)
+	slow := b.Subscribe(2)
+
+	b.Publish([]byte("p0"), false) // nobody starts with a delta frame
+	b.Publish([]byte("k1"), true)
+	b.Publish([]byte("p2"), false)
+	b.Publish([]byte("p3"), false) // slow buffer is full, waits for a key frame
+	b.Publish([]byte("p4"), false)
+	s.Equal([]string{"k1", "p2"}, drain(slow))
+	b.Publish([]byte("p5"), false)
+	b.Publish([]byte("k6"), true)
+
+	s.Equal([]string{"k1", "p2", "p3", "p4", "p5", "k6"}, drain(fast))
+	s.Equal([]string{"k6"}, drain(slow))
+	s.Equal(uint64(1), slow.Dropped())
+	s.Zero(fast.Dropped())
+
+	b.Unsubscribe(slow)
+	_, ok := <-slow.Frames()
+	s.False(ok)
+}
+
+func (s *PackagerSuite) TestBroadcasterRun() {
+	source := make(chan []byte, 10)
+	b := NewBroadcaster(source, nil)
+	sub := b.Subscribe(0)
+	go b.Run(context.Background())
+
+	source <- []byte{0, 0, 0, 1, 0x65, 0x88, 1, 0, 0, 0, 1, 0x41}
+	source <- []byte{0x9a, 2, 0, 0, 0, 1, 0x41, 0x9a, 3}
+	close(source)
+
+	var frames [][]byte
+	for frame := range sub.Frames() {
+		frames = append(frames, frame)
+	}
+	s.Equal([][]byte{{0, 0, 0, 1, 0x65, 0x88, 1}, {0, 0, 0, 1, 0x41, 0x9a, 2}, {0, 0, 0, 1, 0x41, 0x9a, 3}}, frames)
+
+	// subscribing to the ended stream returns a closed channel
+	select {
+	case _, ok := <-b.Subscribe(0).Frames():
+		s.False(ok)
+	case <-time.After(time.Second):
+		s.Fail("channel isn't closed")
+	}
+}
+
+func drain(sub *Subscription) []string {
+	var frames []string
+	for {
+		select {
+		case frame := <-sub.Frames():
+			frames = append(frames, string(frame))
+		default:
+			return frames
+		}
+	}
+}
+
+func (s *PackagerSuite) TestBroadcasterKeyFrames() {
+	var requests int
+	b := NewBroadcaster(nil, func() { requests++ })
+	sps, pps := []byte{0x67, 1}, []byte{0x68, 2}
+	idr := func(n byte) h264.AccessUnit { return h264.AccessUnit{NALUs: [][]byte{{0x65, 0x88, n}}, Key: true} }
+	slice := func(n byte) h264.AccessUnit { return h264.AccessUnit{NALUs: [][]byte{{0x41, 0x9a, n}}} }
+
+	// nothing is cached, the joining subscriber asks for a key frame once a second
+	early := b.Subscribe(0)
+	b.PublishUnit(slice(0))
+	s.Equal(1, requests)
+	b.PublishUnit(h264.AccessUnit{NALUs: [][]byte{sps, pps, {0x65, 0x88, 1}}, Key: true})
+	b.PublishUnit(slice(2))
+	s.Equal([]string{
+		string([]byte{0, 0, 0, 1, 0x67, 1, 0, 0, 0, 1, 0x68, 2, 0, 0, 0, 1, 0x65, 0x88, 1}),
+		string([]byte{0, 0, 0, 1, 0x41, 0x9a, 2}),
+	}, drain(early))
+
+	// a late subscriber starts from the cached key frame at once
+	late := b.Subscribe(0)
+	s.Equal(1, requests)
+	s.Equal([]string{
+		string([]byte{0, 0, 0, 1, 0x67, 1, 0, 0, 0, 1, 0x68, 2, 0, 0, 0, 1, 0x65, 0x88, 1}),
+		string([]byte{0, 0, 0, 1, 0x41, 0x9a, 2}),
+	}, drain(late))
+
+	// key frames without parameter sets get the cached ones for subscribers starting from them
+	b.PublishUnit(idr(3))
+	s.Equal([]string{string([]byte{0, 0, 0, 1, 0x65, 0x88, 3})}, drain(late))
+	small := b.Subscribe(1)
+	s.Equal([]string{string([]byte{0, 0, 0, 1, 0x67, 1, 0, 0, 0, 1, 0x68, 2, 0, 0, 0, 1, 0x65, 0x88, 3})}, drain(small))
+
+	// the cached frames don't fit the buffer of the subscriber
+	b.PublishUnit(slice(4))
+	b.lastRequest = time.Time{}
+	b.Subscribe(1)
+	s.Equal(2, requests)
+}
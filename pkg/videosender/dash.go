@@ -0,0 +1,128 @@
+package videosender
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// File names of the DASH stream, the same as ffmpeg's dash muxer uses so either can feed the UI.
+const (
+	manifestName  = "feed"
+	initTemplate  = "init-stream$RepresentationID$.m4s"
+	mediaTemplate = "chunk-stream$RepresentationID$-$Number%05d$.m4s"
+)
+
+func initName(representation int) string {
+	return fmt.Sprintf("init-stream%d.m4s", representation)
+}
+
+func mediaName(representation int, number int) string {
+	return fmt.Sprintf("chunk-stream%d-%05d.m4s", representation, number)
+}
+
+// segmentInfo describes an uploaded media segment.
+type segmentInfo struct {
+	number   int
+	time     uint64 // in timescale units
+	duration uint32
+	size     int
+}
+
+// mpd is a live DASH manifest of a single video representation.
+type mpd struct {
+	XMLName                    xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string   `xml:"profiles,attr"`
+	Type                       string   `xml:"type,attr"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr"`
+	PublishTime                string   `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr"`
+	Period                     struct {
+		ID            string `xml:"id,attr"`
+		Start         string `xml:"start,attr"`
+		AdaptationSet struct {
+			ContentType      string `xml:"contentType,attr"`
+			MimeType         string `xml:"mimeType,attr"`
+			SegmentAlignment bool   `xml:"segmentAlignment,attr"`
+			Representation   struct {
+				ID              string `xml:"id,attr"`
+				Codecs          string `xml:"codecs,attr"`
+				Bandwidth       int    `xml:"bandwidth,attr"`
+				Width           int    `xml:"width,attr"`
+				Height          int    `xml:"height,attr"`
+				SegmentTemplate struct {
+					Timescale      int             `xml:"timescale,attr"`
+					Initialization string          `xml:"initialization,attr"`
+					Media          string          `xml:"media,attr"`
+					StartNumber    int             `xml:"startNumber,attr"`
+					Segments       []timelineEntry `xml:"SegmentTimeline>S"`
+				}
+			}
+		}
+	}
+}
+
+type timelineEntry struct {
+	T uint64 `xml:"t,attr"`
+	D uint32 `xml:"d,attr"`
+}
+
+// manifest builds the live manifest listing the segments of the representation.
+func manifest(
+	representation int,
+	codec string,
+	width, height int,
+	segments []segmentInfo,
+	availabilityStart, now time.Time,
+	segmentDuration time.Duration,
+) []byte {
+	var m mpd
+	m.Profiles = "urn:mpeg:dash:profile:isoff-live:2011"
+	m.Type = "dynamic"
+	m.AvailabilityStartTime = availabilityStart.UTC().Format(time.RFC3339Nano)
+	m.PublishTime = now.UTC().Format(time.RFC3339Nano)
+	m.MinimumUpdatePeriod = isoDuration(segmentDuration)
+	m.MinBufferTime = isoDuration(2 * segmentDuration)
+	m.SuggestedPresentationDelay = isoDuration(segmentDuration)
+
+	m.Period.ID = "0"
+	m.Period.Start = "PT0S"
+	as := &m.Period.AdaptationSet
+	as.ContentType = "video"
+	as.MimeType = "video/mp4"
+	as.SegmentAlignment = true
+
+	r := &as.Representation
+	r.ID = strconv.Itoa(representation)
+	r.Codecs = codec
+	r.Width, r.Height = width, height
+	r.SegmentTemplate.Timescale = timescale
+	r.SegmentTemplate.Initialization = initTemplate
+	r.SegmentTemplate.Media = mediaTemplate
+
+	var size, duration uint64
+	for i, s := range segments {
+		if i == 0 {
+			r.SegmentTemplate.StartNumber = s.number
+		}
+		r.SegmentTemplate.Segments = append(r.SegmentTemplate.Segments, timelineEntry{T: s.time, D: s.duration})
+		size += uint64(s.size)
+		duration += uint64(s.duration)
+	}
+	if duration > 0 {
+		r.Bandwidth = int(size * 8 * timescale / duration)
+	}
+	m.TimeShiftBufferDepth = isoDuration(time.Duration(duration) * time.Second / timescale)
+
+	data, _ := xml.MarshalIndent(m, "", "  ")
+	return append([]byte(xml.Header), data...)
+}
+
+// isoDuration formats the duration as ISO 8601 seconds, e.g. PT0.5S.
+func isoDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
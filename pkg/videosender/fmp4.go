@@ -0,0 +1,194 @@
+package videosender
+
+import (
+	"encoding/binary"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+// timescale of the video track, 90 kHz as usual for video
+const timescale = 90000
+
+// Sample flags of trun, see ISO/IEC 14496-12 8.8.3.1.
+const (
+	sampleFlagsKey    = 0x02000000 // sample_depends_on = 2
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on = 1, sample_is_non_sync_sample
+)
+
+// sample is an access unit in AVCC format, NAL units prefixed with 4 byte lengths.
+type sample struct {
+	data     []byte
+	duration uint32 // in timescale units
+	key      bool
+}
+
+// newSample converts the access unit to a sample, parameter sets and delimiters are left to the init segment.
+func newSample(au h264.AccessUnit) sample {
+	s := sample{key: au.Key}
+	for _, nalu := range au.NALUs {
+		switch h264.Type(nalu) {
+		case h264.NALSPS, h264.NALPPS, h264.NALAUD:
+			continue
+		}
+		s.data = binary.BigEndian.AppendUint32(s.data, uint32(len(nalu)))
+		s.data = append(s.data, nalu...)
+	}
+	return s
+}
+
+// box builds an ISO BMFF box.
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullBox builds a box with version and flags.
+func fullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags)
+	return box(typ, append([][]byte{header}, payload...)...)
+}
+
+// u builds big endian fields, values are truncated to the size of their type.
+func u(fields ...interface{}) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case []byte:
+			b = append(b, v...)
+		case string:
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+// unityMatrix is the identity transformation matrix of mvhd and tkhd.
+var unityMatrix = u(
+	uint32(0x00010000), uint32(0), uint32(0),
+	uint32(0), uint32(0x00010000), uint32(0),
+	uint32(0), uint32(0), uint32(0x40000000),
+)
+
+// initSegment builds the fragmented MP4 header describing a single H.264 track.
+func initSegment(sps h264.SPS, spsNALU, ppsNALU []byte) []byte {
+	width, height := uint16(sps.Width), uint16(sps.Height)
+
+	avcC := box("avcC", u(
+		uint8(1), sps.Profile, sps.Compatibility, sps.Level,
+		uint8(0xff),                                // 4 byte NAL unit lengths
+		uint8(0xe1), uint16(len(spsNALU)), spsNALU, // one SPS
+		uint8(1), uint16(len(ppsNALU)), ppsNALU, // one PPS
+	))
+	avc1 := box("avc1",
+		u(
+			make([]byte, 6), uint16(1), // reserved, data_reference_index
+			make([]byte, 16), // pre_defined, reserved
+			width, height,
+			uint32(0x00480000), uint32(0x00480000), // 72 dpi
+			uint32(0), uint16(1), // reserved, frame_count
+			make([]byte, 32),               // compressorname
+			uint16(0x0018), uint16(0xffff), // depth, pre_defined
+		),
+		avcC,
+	)
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u(uint32(1)), avc1),
+		fullBox("stts", 0, 0, u(uint32(0))),
+		fullBox("stsc", 0, 0, u(uint32(0))),
+		fullBox("stsz", 0, 0, u(uint32(0), uint32(0))),
+		fullBox("stco", 0, 0, u(uint32(0))),
+	)
+	minf := box("minf",
+		fullBox("vmhd", 0, 1, make([]byte, 8)),
+		box("dinf", fullBox("dref", 0, 0, u(uint32(1)), fullBox("url ", 0, 1))),
+		stbl,
+	)
+	mdia := box("mdia",
+		fullBox("mdhd", 0, 0, u(uint32(0), uint32(0), uint32(timescale), uint32(0), uint16(0x55c4), uint16(0))),
+		fullBox("hdlr", 0, 0, u(uint32(0), "vide", make([]byte, 12), "VideoHandler\x00")),
+		minf,
+	)
+	trak := box("trak",
+		fullBox("tkhd", 0, 3, u(
+			uint32(0), uint32(0), uint32(1), uint32(0), uint32(0), // times, track_ID, reserved, duration
+			make([]byte, 8), uint16(0), uint16(0), uint16(0), uint16(0), // reserved, layer, alternate_group, volume, reserved
+			unityMatrix,
+			uint32(width)<<16, uint32(height)<<16,
+		)),
+		mdia,
+	)
+	moov := box("moov",
+		fullBox("mvhd", 0, 0, u(
+			uint32(0), uint32(0), uint32(1000), uint32(0), // times, timescale, duration
+			uint32(0x00010000), uint16(0x0100), make([]byte, 10), // rate, volume, reserved
+			unityMatrix,
+			make([]byte, 24), uint32(2), // pre_defined, next_track_ID
+		)),
+		trak,
+		box("mvex", fullBox("trex", 0, 0, u(uint32(1), uint32(1), uint32(0), uint32(0), uint32(0)))),
+	)
+	ftyp := box("ftyp", u("iso5", uint32(512), "iso5", "iso6", "mp41", "avc1"))
+	return append(ftyp, moov...)
+}
+
+// mediaSegment builds a fragment of the samples starting at baseTime.
+func mediaSegment(seq uint32, baseTime uint64, samples []sample) []byte {
+	const (
+		trunDataOffset     = 0x000001
+		trunSampleDuration = 0x000100
+		trunSampleSize     = 0x000200
+		trunSampleFlags    = 0x000400
+		tfhdDefaultBase    = 0x020000 // default-base-is-moof
+	)
+
+	entries := u(uint32(len(samples)), uint32(0)) // data_offset is patched below
+	var mdatSize int
+	for _, s := range samples {
+		flags := uint32(sampleFlagsNonKey)
+		if s.key {
+			flags = sampleFlagsKey
+		}
+		entries = append(entries, u(s.duration, uint32(len(s.data)), flags)...)
+		mdatSize += len(s.data)
+	}
+	trun := fullBox("trun", 0, trunDataOffset|trunSampleDuration|trunSampleSize|trunSampleFlags, entries)
+	moof := box("moof",
+		fullBox("mfhd", 0, 0, u(seq)),
+		box("traf",
+			fullBox("tfhd", 0, tfhdDefaultBase, u(uint32(1))),
+			fullBox("tfdt", 1, 0, u(baseTime)),
+			trun,
+		),
+	)
+	// data_offset is counted from the start of moof to the first sample in mdat
+	offsetPos := len(moof) - len(trun) + 16
+	binary.BigEndian.PutUint32(moof[offsetPos:], uint32(len(moof)+8))
+
+	styp := box("styp", u("msdh", uint32(0), "msdh", "msix"))
+	segment := make([]byte, 0, len(styp)+len(moof)+8+mdatSize)
+	segment = append(segment, styp...)
+	segment = append(segment, moof...)
+	segment = binary.BigEndian.AppendUint32(segment, uint32(8+mdatSize))
+	segment = append(segment, "mdat"...)
+	for _, s := range samples {
+		segment = append(segment, s.data...)
+	}
+	return segment
+}
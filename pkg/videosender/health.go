@@ -0,0 +1,64 @@
+package videosender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// DefaultHealthPeriod is used when the publisher is created with non-positive period.
+const DefaultHealthPeriod = 2 * time.Second
+
+// HealthSource is an encoder reporting its Health, e.g. Sender.
+type HealthSource interface {
+	Health() Health
+}
+
+// HealthPublisher periodically sends Health of the encoder as MTEncoder message.
+type HealthPublisher struct {
+	wsClient wsclient.Messenger
+	source   HealthSource
+	period   time.Duration
+}
+
+func NewHealthPublisher(wsClient wsclient.Messenger, source HealthSource, period time.Duration) *HealthPublisher {
+	if period <= 0 {
+		period = DefaultHealthPeriod
+	}
+	return &HealthPublisher{
+		wsClient: wsClient,
+		source:   source,
+		period:   period,
+	}
+}
+
+func (p *HealthPublisher) Run(ctx context.Context) {
+	logrus.Warnf("started encoder health publisher")
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !p.wsClient.Accepts(wsclient.MTEncoder) {
+				continue
+			}
+			payload, err := json.Marshal(p.source.Health())
+			if err != nil {
+				logrus.Error(fmt.Errorf("error encoding encoder health: %w", err))
+				continue
+			}
+			p.wsClient.SendMessage(wsclient.Message{
+				Type:    wsclient.MTEncoder,
+				Payload: payload,
+			})
+		case <-ctx.Done():
+			logrus.Warnf("stopped encoder health publisher")
+			return
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package videosender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+const (
+	// DefaultSegmentDuration is the minimal duration of a segment, segments are cut at key frames.
+	DefaultSegmentDuration = 500 * time.Millisecond
+	// maxSegmentFactor limits segments without key frames to this many segment durations.
+	maxSegmentFactor = 4
+	// manifestWindow is the number of the latest segments listed in the manifest, older ones are deleted.
+	manifestWindow  = 30
+	uploadQueueSize = 32
+	uploadTimeout   = 5 * time.Second
+	// frameDuration is used when arrival times of frames are unusable, Tello streams 30 fps
+	frameDuration = timescale / 30
+)
+
+// Packager packages the H.264 stream of the drone into fragmented MP4 DASH segments without transcoding
+// and uploads them to destURL the same way ffmpeg's dash muxer of StreamPipe does.
+type Packager struct {
+	destURL      string
+	sourceStream <-chan []byte
+	client       *http.Client
+	uploads      chan upload
+	segmenter    *segmenter
+	stats        *Stats
+}
+
+// upload of a file, nil data deletes the file.
+type upload struct {
+	name string
+	data []byte
+}
+
+func NewPackager(destURL string, sourceStream <-chan []byte, segmentDuration time.Duration) *Packager {
+	if segmentDuration <= 0 {
+		segmentDuration = DefaultSegmentDuration
+	}
+	p := &Packager{
+		destURL:      destURL,
+		sourceStream: sourceStream,
+		client:       &http.Client{Timeout: uploadTimeout},
+		uploads:      make(chan upload, uploadQueueSize),
+	}
+	p.segmenter = newSegmenter(segmentDuration, p.enqueue)
+	return p
+}
+
+// OnKeyFrameNeeded sets the function asking the source for a key frame, e.g. Broadcaster.RequestKeyFrame,
+// it's called when a segment is due or the stream can't be packaged without one. It must be set before Run.
+func (p *Packager) OnKeyFrameNeeded(f func()) {
+	p.segmenter.keyFrameNeeded = f
+}
+
+// ReportTo makes the packager record latencies of segment uploads to the stats. It must be called before Run.
+func (p *Packager) ReportTo(stats *Stats) {
+	p.stats = stats
+	stats.mux.Lock()
+	stats.segmentDuration = p.segmenter.duration
+	stats.mux.Unlock()
+}
+
+func (p *Packager) Run(ctx context.Context) {
+	logrus.Warnf("started video packager")
+	go p.uploadFiles(ctx)
+
+	var (
+		splitter  h264.Splitter
+		assembler h264.Assembler
+		auStart   time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Warnf("stopped video packager")
+			return
+		case block, ok := <-p.sourceStream:
+			if !ok {
+				logrus.Warnf("video stream closed")
+				<-ctx.Done()
+				logrus.Warnf("stopped video packager")
+				return
+			}
+			now := time.Now()
+			for _, nalu := range splitter.Write(block) {
+				if au, ok := assembler.Push(nalu); ok {
+					p.segmenter.push(au, auStart)
+					auStart = now
+				} else if auStart.IsZero() {
+					auStart = now
+				}
+			}
+		}
+	}
+}
+
+// enqueue never blocks, so a slow server doesn't stall reading the drone's stream.
+func (p *Packager) enqueue(name string, data []byte) {
+	select {
+	case p.uploads <- upload{name: name, data: data}:
+	default:
+		logrus.Warnf("video upload queue is full, dropped %s", name)
+	}
+}
+
+func (p *Packager) uploadFiles(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-p.uploads:
+			started := time.Now()
+			err := p.send(ctx, u)
+			if err != nil {
+				logrus.Error(fmt.Errorf("error uploading %s: %w", u.name, err))
+			}
+			if u.data != nil && strings.HasPrefix(u.name, "chunk-") {
+				p.stats.AddUpload(time.Since(started), err)
+			}
+		}
+	}
+}
+
+func (p *Packager) send(ctx context.Context, u upload) error {
+	method, body := http.MethodPut, io.Reader(bytes.NewReader(u.data))
+	if u.data == nil {
+		method, body = http.MethodDelete, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.destURL+"drone/video/fs/"+u.name, body)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// segmenter cuts access units into segments starting at key frames and writes them with the manifest.
+// A change of parameter sets, e.g. after switching the camera mode, starts a new representation.
+type segmenter struct {
+	duration       time.Duration
+	write          func(name string, data []byte) // nil data deletes the file
+	keyFrameNeeded func()                         // nil if the source can't be asked
+
+	sps            h264.SPS
+	spsNALU        []byte
+	ppsNALU        []byte
+	representation int
+	started        time.Time // availability start of the stream
+	waitKey        bool      // the next segment must start with a key frame
+
+	number   int    // number of the next segment
+	time     uint64 // decode time of the next segment
+	samples  []sample
+	sampleTS uint64 // duration of samples
+
+	pending      *sample // the last sample, its duration is known when the next one arrives
+	pendingStart time.Time
+
+	segments []segmentInfo // listed in the manifest
+}
+
+func newSegmenter(duration time.Duration, write func(name string, data []byte)) *segmenter {
+	return &segmenter{
+		duration:       duration,
+		write:          write,
+		representation: -1,
+		number:         1,
+	}
+}
+
+// push adds the access unit received at start.
+func (s *segmenter) push(au h264.AccessUnit, start time.Time) {
+	s.updateParameters(au)
+	if s.spsNALU == nil || s.ppsNALU == nil || (s.waitKey && !au.Key) {
+		// the drone sends key frames with parameter sets only when asked
+		s.requestKeyFrame()
+		return
+	}
+	s.waitKey = false
+
+	if s.pending != nil {
+		s.pending.duration = frameTicks(start.Sub(s.pendingStart))
+		s.samples = append(s.samples, *s.pending)
+		s.sampleTS += uint64(s.pending.duration)
+		s.pending = nil
+	}
+	target := uint64(s.duration.Seconds() * timescale)
+	if s.sampleTS >= target && !au.Key {
+		// segments start with key frames, so players joining later can decode them
+		s.requestKeyFrame()
+	}
+	if s.sampleTS >= target && (au.Key || s.sampleTS >= maxSegmentFactor*target) {
+		s.flush()
+	}
+	next := newSample(au)
+	s.pending, s.pendingStart = &next, start
+}
+
+func (s *segmenter) requestKeyFrame() {
+	if s.keyFrameNeeded != nil {
+		s.keyFrameNeeded()
+	}
+}
+
+// updateParameters remembers new SPS/PPS of the access unit, writing the init segment of a new representation.
+func (s *segmenter) updateParameters(au h264.AccessUnit) {
+	spsNALU, ppsNALU := s.spsNALU, s.ppsNALU
+	for _, nalu := range au.NALUs {
+		switch h264.Type(nalu) {
+		case h264.NALSPS:
+			spsNALU = nalu
+		case h264.NALPPS:
+			ppsNALU = nalu
+		}
+	}
+	if spsNALU == nil || ppsNALU == nil || (bytes.Equal(spsNALU, s.spsNALU) && bytes.Equal(ppsNALU, s.ppsNALU)) {
+		return
+	}
+	sps, err := h264.ParseSPS(spsNALU)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error parsing video parameters: %w", err))
+		return
+	}
+
+	// the pending sample belongs to the old representation
+	if s.pending != nil {
+		s.pending.duration = frameDuration
+		s.samples = append(s.samples, *s.pending)
+		s.sampleTS += frameDuration
+		s.pending = nil
+	}
+	s.flush()
+	if s.representation >= 0 {
+		for _, seg := range s.segments {
+			s.write(mediaName(s.representation, seg.number), nil)
+		}
+		s.write(initName(s.representation), nil)
+	}
+	s.segments = nil
+
+	s.sps, s.spsNALU, s.ppsNALU = sps, spsNALU, ppsNALU
+	s.representation++
+	s.waitKey = true
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	logrus.Warnf("video stream %dx%d %s", sps.Width, sps.Height, sps.Codec())
+	s.write(initName(s.representation), initSegment(sps, spsNALU, ppsNALU))
+}
+
+// flush writes the collected samples as the next segment and updates the manifest.
+func (s *segmenter) flush() {
+	if len(s.samples) == 0 {
+		return
+	}
+	data := mediaSegment(uint32(s.number), s.time, s.samples)
+	s.write(mediaName(s.representation, s.number), data)
+	s.segments = append(s.segments, segmentInfo{
+		number:   s.number,
+		time:     s.time,
+		duration: uint32(s.sampleTS),
+		size:     len(data),
+	})
+	if len(s.segments) > manifestWindow {
+		s.write(mediaName(s.representation, s.segments[0].number), nil)
+		s.segments = s.segments[1:]
+	}
+	s.write(manifestName, manifest(s.representation, s.sps.Codec(), s.sps.Width, s.sps.Height,
+		s.segments, s.started, time.Now(), s.duration))
+
+	s.number++
+	s.time += s.sampleTS
+	s.samples, s.sampleTS = nil, 0
+}
+
+// frameTicks converts the time between frames to timescale units, unusable values are replaced with frameDuration.
+func frameTicks(d time.Duration) uint32 {
+	if d <= 0 || d > time.Second {
+		return frameDuration
+	}
+	return uint32(d * timescale / time.Second)
+}
@@ -0,0 +1,178 @@
+package videosender
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+type PackagerSuite struct {
+	suite.Suite
+}
+
+func TestPackagerSuite(t *testing.T) {
+	suite.Run(t, new(PackagerSuite))
+}
+
+// Tello 960x720 parameter sets
+var (
+	testSPS = []byte{0x67, 0x4d, 0x40, 0x28, 0x95, 0xa0, 0x3c, 0x05, 0xb9}
+	testPPS = []byte{0x68, 0xee, 0x38, 0x80}
+)
+
+// boxes returns the top level boxes of the data by type.
+func boxes(data []byte) map[string][]byte {
+	result := make(map[string][]byte)
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		result[string(data[4:8])] = data[:size]
+		data = data[size:]
+	}
+	return result
+}
+
+func names(files map[string][]byte) []string {
+	var result []string
+	for name := range files {
+		result = append(result, name)
+	}
+	return result
+}
+
+func (s *PackagerSuite) TestSegments() {
+	files := make(map[string][]byte)
+	seg := newSegmenter(100*time.Millisecond, func(name string, data []byte) {
+		if data == nil {
+			delete(files, name)
+			return
+		}
+		files[name] = data
+	})
+
+	start := time.Now()
+	frame := func(i int) h264.AccessUnit {
+		if i%5 == 0 {
+			return h264.AccessUnit{NALUs: [][]byte{testSPS, testPPS, {0x65, 0x88, byte(i)}}, Key: true}
+		}
+		return h264.AccessUnit{NALUs: [][]byte{{0x41, 0x9a, byte(i)}}}
+	}
+	seg.push(frame(3), start) // dropped before parameter sets
+	for i := 5; i < 21; i++ {
+		seg.push(frame(i), start.Add(time.Duration(i)*33*time.Millisecond))
+	}
+
+	init := boxes(files["init-stream0.m4s"])
+	s.Contains(init, "ftyp")
+	s.Contains(init, "moov")
+
+	// frames 5-9, 10-14 and 15-19 are segments starting with key frames, frame 20 is pending
+	s.ElementsMatch([]string{
+		"init-stream0.m4s",
+		"chunk-stream0-00001.m4s",
+		"chunk-stream0-00002.m4s",
+		"chunk-stream0-00003.m4s",
+		"feed",
+	}, names(files))
+	media := boxes(files["chunk-stream0-00001.m4s"])
+	s.Require().Contains(media, "moof")
+	s.Require().Contains(media, "mdat")
+	mdat := media["mdat"][8:]
+	s.Equal([]byte{0, 0, 0, 3, 0x65, 0x88, 5}, mdat[:7], "parameter sets are in the init segment only")
+	s.Len(mdat, 5*7)
+
+	// data offset of trun points right after the mdat header
+	moof := media["moof"]
+	offset := binary.BigEndian.Uint32(moof[len(moof)-5*12-4:])
+	s.Equal(uint32(len(moof)+8), offset)
+
+	var m mpd
+	s.Require().NoError(xml.Unmarshal(files["feed"], &m))
+	r := m.Period.AdaptationSet.Representation
+	s.Equal("0", r.ID)
+	s.Equal("avc1.4d4028", r.Codecs)
+	s.Equal(960, r.Width)
+	s.Equal(1, r.SegmentTemplate.StartNumber)
+	s.Require().Len(r.SegmentTemplate.Segments, 3)
+	s.Equal(uint64(0), r.SegmentTemplate.Segments[0].T)
+	s.Equal(uint32(5*33*timescale/1000), r.SegmentTemplate.Segments[0].D)
+	s.Equal(uint64(5*33*timescale/1000), r.SegmentTemplate.Segments[1].T)
+
+	// new parameter sets start a new representation and remove the old one
+	wide := []byte{0x67, 0x4d, 0x40, 0x28, 0x95, 0xa0, 0x3c, 0x05, 0xb8}
+	seg.push(h264.AccessUnit{NALUs: [][]byte{wide, testPPS, {0x65, 0x88, 0}}, Key: true}, start.Add(time.Second))
+	s.ElementsMatch([]string{"init-stream1.m4s", "feed"}, names(files))
+}
+
+func (s *PackagerSuite) TestKeyFrameRequests() {
+	seg := newSegmenter(100*time.Millisecond, func(string, []byte) {})
+	requests := 0
+	seg.keyFrameNeeded = func() { requests++ }
+
+	start := time.Now()
+	delta := func(i int) h264.AccessUnit {
+		return h264.AccessUnit{NALUs: [][]byte{{0x41, 0x9a, byte(i)}}}
+	}
+	seg.push(delta(0), start)
+	s.Equal(1, requests, "no parameter sets yet")
+
+	seg.push(h264.AccessUnit{NALUs: [][]byte{testSPS, testPPS, {0x65, 0x88, 1}}, Key: true}, start)
+	requests = 0
+	for i := 1; i < 3; i++ {
+		seg.push(delta(i), start.Add(time.Duration(i)*33*time.Millisecond))
+	}
+	s.Zero(requests, "the segment isn't due")
+	for i := 3; i < 6; i++ {
+		seg.push(delta(i), start.Add(time.Duration(i)*33*time.Millisecond))
+	}
+	s.Positive(requests, "the segment is due without a key frame")
+}
+
+func (s *PackagerSuite) TestUpload() {
+	var (
+		mux      sync.Mutex
+		uploaded []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		mux.Lock()
+		uploaded = append(uploaded, r.Method+" "+r.URL.Path)
+		mux.Unlock()
+	}))
+	defer server.Close()
+
+	stream := make(chan []byte, 100)
+	p := NewPackager(server.URL+"/", stream, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	stream <- append([]byte{0, 0, 0, 1}, testSPS...)
+	stream <- append([]byte{0, 0, 0, 1}, testPPS...)
+	for i := 0; i < 6; i++ {
+		stream <- []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	s.Eventually(func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(uploaded) >= 3
+	}, time.Second, 10*time.Millisecond)
+	mux.Lock()
+	defer mux.Unlock()
+	s.Equal([]string{
+		"PUT /drone/video/fs/init-stream0.m4s",
+		"PUT /drone/video/fs/chunk-stream0-00001.m4s",
+		"PUT /drone/video/fs/feed",
+	}, uploaded[:3])
+}
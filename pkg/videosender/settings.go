@@ -0,0 +1,250 @@
+package videosender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SMerrony/tello"
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// DefaultSettingsPeriod is used when the tuner is created with non-positive period.
+const DefaultSettingsPeriod = 10 * time.Second
+
+// Camera modes of the drone.
+const (
+	CameraWide   = "wide"   // 16:9, 1280x720
+	CameraNormal = "normal" // 4:3, 960x720, the drone takes photos in it
+)
+
+// bitrates are the video bitrates of the drone by their names in Mbit/s.
+var bitrates = map[string]tello.VBR{
+	"auto": tello.VbrAuto,
+	"1":    tello.Vbr1M,
+	"1.5":  tello.Vbr1M5,
+	"2":    tello.Vbr2M,
+	"3":    tello.Vbr3M,
+	"4":    tello.Vbr4M,
+}
+
+// Settings of the video, they are sent in wsclient.MTVideoSettings message.
+type Settings struct {
+	Camera  string `json:"camera"`  // CameraWide or CameraNormal
+	Bitrate string `json:"bitrate"` // Mbit/s or "auto"
+	// output of the encoders, zero without them, the drone's stream is sent as is then
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	GOP    int `json:"gop,omitempty"` // key frame interval of the encoders, frames
+}
+
+// DefaultSettings match StreamPipe.
+var DefaultSettings = Settings{Camera: CameraWide, Bitrate: "auto", Width: 320, Height: 180, GOP: 40}
+
+// Camera changes the video of the drone, e.g. tello.Tello.
+type Camera interface {
+	SetVideoWide()
+	SetVideoNormal()
+	SetVideoBitrate(vbr tello.VBR)
+}
+
+// Encoder is restarted with new settings, e.g. Sender.
+type Encoder interface {
+	Apply(settings Settings)
+}
+
+// Tuner applies video settings changed by operators to the drone and the encoders and reports them.
+type Tuner struct {
+	wsClient wsclient.Messenger
+	camera   Camera
+	encoders []Encoder
+	period   time.Duration
+
+	mux      sync.Mutex
+	settings Settings
+}
+
+func NewTuner(wsClient wsclient.Messenger, camera Camera, settings Settings, period time.Duration, encoders ...Encoder) *Tuner {
+	if period <= 0 {
+		period = DefaultSettingsPeriod
+	}
+	for _, e := range encoders {
+		e.Apply(settings)
+	}
+	if len(encoders) == 0 {
+		// the size and the key frame interval of the drone's stream can't be changed
+		settings.Width, settings.Height, settings.GOP = 0, 0, 0
+	}
+	return &Tuner{
+		wsClient: wsClient,
+		camera:   camera,
+		encoders: encoders,
+		period:   period,
+		settings: settings,
+	}
+}
+
+// Settings returns the active settings.
+func (t *Tuner) Settings() Settings {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.settings
+}
+
+// Command changes the settings on operator's "video" command, e.g. "video normal", "video bitrate 2",
+// "video size 640x360" or "video gop 30". See controller.Controller.Command.
+func (t *Tuner) Command(args string) (string, error) {
+	t.mux.Lock()
+	settings := t.settings
+	t.mux.Unlock()
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("video setting isn't set")
+	}
+	restart := true
+	if (fields[0] == "size" || fields[0] == "gop") && len(t.encoders) == 0 {
+		return "", fmt.Errorf("video %s needs an encoder, the drone's stream is sent as is", fields[0])
+	}
+	switch fields[0] {
+	case CameraWide, CameraNormal:
+		settings.Camera = fields[0]
+	case "bitrate":
+		if len(fields) != 2 {
+			return "", fmt.Errorf("bitrate isn't set")
+		}
+		if _, ok := bitrates[fields[1]]; !ok {
+			return "", fmt.Errorf("unknown bitrate %q", fields[1])
+		}
+		settings.Bitrate = fields[1]
+		restart = false
+	case "size":
+		if len(fields) != 2 {
+			return "", fmt.Errorf("size isn't set")
+		}
+		width, height, err := parseSize(fields[1])
+		if err != nil {
+			return "", err
+		}
+		settings.Width, settings.Height = width, height
+	case "gop":
+		if len(fields) != 2 {
+			return "", fmt.Errorf("gop isn't set")
+		}
+		gop, err := strconv.Atoi(fields[1])
+		if err != nil || gop <= 0 {
+			return "", fmt.Errorf("wrong gop %q", fields[1])
+		}
+		settings.GOP = gop
+	default:
+		return "", fmt.Errorf("unknown video setting %q", fields[0])
+	}
+	t.apply(settings, restart)
+	return "Video settings: " + settings.String(), nil
+}
+
+func (t *Tuner) Run(ctx context.Context) {
+	logrus.Warnf("started video tuner")
+	t.apply(t.Settings(), false)
+	ticker := time.NewTicker(t.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.send(t.Settings())
+		case <-ctx.Done():
+			logrus.Warnf("stopped video tuner")
+			return
+		}
+	}
+}
+
+// apply sets the camera and restarts the encoders if asked, e.g. when the picture size changes.
+func (t *Tuner) apply(settings Settings, restart bool) {
+	t.mux.Lock()
+	t.settings = settings
+	t.mux.Unlock()
+
+	if settings.Camera == CameraNormal {
+		t.camera.SetVideoNormal()
+	} else {
+		t.camera.SetVideoWide()
+	}
+	t.camera.SetVideoBitrate(bitrates[settings.Bitrate])
+	if restart {
+		for _, e := range t.encoders {
+			e.Apply(settings)
+		}
+	}
+	t.send(settings)
+}
+
+func (t *Tuner) send(settings Settings) {
+	if !t.wsClient.Accepts(wsclient.MTVideoSettings) {
+		return
+	}
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error encoding video settings: %w", err))
+		return
+	}
+	t.wsClient.SendMessage(wsclient.Message{
+		Type:    wsclient.MTVideoSettings,
+		Payload: payload,
+	})
+}
+
+func (s Settings) String() string {
+	if s.Width == 0 && s.Height == 0 && s.GOP == 0 {
+		return fmt.Sprintf("%s camera, bitrate %s", s.Camera, s.Bitrate)
+	}
+	return fmt.Sprintf("%s camera, bitrate %s, size %dx%d, gop %d", s.Camera, s.Bitrate, s.Width, s.Height, s.GOP)
+}
+
+func parseSize(s string) (width, height int, err error) {
+	w, h, ok := strings.Cut(s, "x")
+	if ok {
+		width, err = strconv.Atoi(w)
+	}
+	if ok && err == nil {
+		height, err = strconv.Atoi(h)
+	}
+	// encoders need even sizes
+	if !ok || err != nil || width <= 0 || height <= 0 || width%2 != 0 || height%2 != 0 {
+		return 0, 0, fmt.Errorf("wrong size %q", s)
+	}
+	return width, height, nil
+}
+
+// applySettings sets the key frame interval and the scale filter of ffmpeg arguments,
+// the filter is added before the output if there is none.
+func applySettings(args []string, settings Settings) []string {
+	args = append([]string(nil), args...)
+	scale := fmt.Sprintf("scale=%d:%d", settings.Width, settings.Height)
+	hasScale := false
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-g":
+			if settings.GOP > 0 {
+				args[i+1] = strconv.Itoa(settings.GOP)
+			}
+		case "-vf":
+			if settings.Width > 0 && settings.Height > 0 {
+				args[i+1] = scale
+			}
+			hasScale = true
+		}
+	}
+	if !hasScale && settings.Width > 0 && settings.Height > 0 && len(args) > 0 {
+		output := args[len(args)-1]
+		args = append(append(args[:len(args)-1], "-vf", scale), output)
+	}
+	return args
+}
@@ -0,0 +1,105 @@
+package videosender
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/SMerrony/tello"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+type TunerSuite struct {
+	suite.Suite
+}
+
+func TestTunerSuite(t *testing.T) {
+	suite.Run(t, new(TunerSuite))
+}
+
+type camera struct {
+	mode    string
+	bitrate tello.VBR
+}
+
+func (c *camera) SetVideoWide()                 { c.mode = CameraWide }
+func (c *camera) SetVideoNormal()               { c.mode = CameraNormal }
+func (c *camera) SetVideoBitrate(vbr tello.VBR) { c.bitrate = vbr }
+
+type encoder struct {
+	applied []Settings
+}
+
+func (e *encoder) Apply(settings Settings) { e.applied = append(e.applied, settings) }
+
+func (s *TunerSuite) TestApplySettings() {
+	_, args := parseCommand(StreamPipe, "http://localhost/")
+	args = applySettings(args, Settings{Width: 640, Height: 360, GOP: 30})
+	command := strings.Join(args, " ")
+	s.Contains(command, "-g 30 ")
+	s.Contains(command, "-vf scale=640:360 ")
+
+	args = applySettings(strings.Fields(StreamMJPEG)[1:], Settings{Width: 640, Height: 360, GOP: 30})
+	s.Equal("-f h264 -i pipe:0 -f image2pipe -vcodec mjpeg -q:v 5 -r 15 -vf scale=640:360 pipe:1", strings.Join(args, " "))
+}
+
+func (s *TunerSuite) TestCommand() {
+	ws := &messenger{sent: make(chan wsclient.Message, 10)}
+	cam := new(camera)
+	enc := new(encoder)
+	tuner := NewTuner(ws, cam, DefaultSettings, 0, enc)
+	s.Equal([]Settings{DefaultSettings}, enc.applied)
+
+	info, err := tuner.Command("normal")
+	s.Require().NoError(err)
+	s.Equal("Video settings: normal camera, bitrate auto, size 320x180, gop 40", info)
+	s.Equal(CameraNormal, cam.mode)
+	s.Len(enc.applied, 2)
+
+	// bitrate doesn't need restarting encoders
+	_, err = tuner.Command("bitrate 1.5")
+	s.Require().NoError(err)
+	s.Equal(tello.Vbr1M5, cam.bitrate)
+	s.Len(enc.applied, 2)
+
+	_, err = tuner.Command("size 640x360")
+	s.Require().NoError(err)
+	_, err = tuner.Command("gop 30")
+	s.Require().NoError(err)
+	expected := Settings{Camera: CameraNormal, Bitrate: "1.5", Width: 640, Height: 360, GOP: 30}
+	s.Equal(expected, tuner.Settings())
+	s.Equal(expected, enc.applied[len(enc.applied)-1])
+
+	for _, wrong := range []string{"", "zoom", "bitrate 5", "size 641x360", "size big", "gop 0"} {
+		_, err = tuner.Command(wrong)
+		s.Error(err, wrong)
+	}
+	s.Equal(expected, tuner.Settings())
+
+	var last Settings
+	for len(ws.sent) > 0 {
+		msg := <-ws.sent
+		s.EqualValues(wsclient.MTVideoSettings, msg.Type)
+		s.Require().NoError(json.Unmarshal(msg.Payload, &last))
+	}
+	s.Equal(expected, last)
+}
+
+func (s *TunerSuite) TestWithoutEncoders() {
+	ws := &messenger{sent: make(chan wsclient.Message, 10)}
+	tuner := NewTuner(ws, new(camera), DefaultSettings, 0)
+	expected := Settings{Camera: CameraWide, Bitrate: "auto"}
+	s.Equal(expected, tuner.Settings(), "the drone's stream is sent as is")
+
+	for _, unsupported := range []string{"size 640x360", "gop 30"} {
+		_, err := tuner.Command(unsupported)
+		s.Error(err, unsupported)
+	}
+	info, err := tuner.Command("bitrate 2")
+	s.Require().NoError(err)
+	s.Equal("Video settings: wide camera, bitrate 2", info)
+	msg := <-ws.sent
+	s.JSONEq(`{"camera":"wide","bitrate":"2"}`, string(msg.Payload))
+}
@@ -0,0 +1,334 @@
+package videosender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/h264"
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// DefaultStatsPeriod is used when the stats publisher is created with non-positive period.
+const DefaultStatsPeriod = time.Second
+
+const (
+	// rateWindow is the period bitrate and frame rate are averaged over
+	rateWindow = time.Second
+	// gapThreshold is the interval between frames counted as a gap, the Tello sends a frame every 33 ms
+	gapThreshold = 200 * time.Millisecond
+	// stallThreshold is the interval without frames counted as a stall
+	stallThreshold = time.Second
+	// minHealthyFPS is the frame rate below which the video is degraded
+	minHealthyFPS = 15
+)
+
+// Video health reported in StatsSnapshot.
+const (
+	VideoOK       = "ok"
+	VideoDegraded = "degraded" // low frame rate, gaps or slow uploads
+	VideoStalled  = "stalled"  // no frames from the drone
+)
+
+var nalTypeNames = map[int]string{
+	h264.NALSlice: "slice",
+	h264.NALIDR:   "idr",
+	h264.NALSEI:   "sei",
+	h264.NALSPS:   "sps",
+	h264.NALPPS:   "pps",
+	h264.NALAUD:   "aud",
+}
+
+// Stats measures the video path: the stream of the drone and uploads of segments. Nil Stats doesn't measure anything.
+type Stats struct {
+	mux sync.Mutex
+
+	bytes, frames, keyFrames uint64
+	nalus                    map[string]uint64
+	gaps, stalls             uint64
+	maxGap                   time.Duration
+	lastFrame                time.Time
+	stalled                  bool
+
+	windowStart           time.Time
+	windowBytes           uint64
+	windowFrames          uint64
+	bitrate, fps          float64
+	uploads, uploadFails  uint64
+	uploadSum, lastUpload time.Duration
+	segmentDuration       time.Duration // uploads slower than a segment lag behind the stream
+}
+
+// StatsSnapshot is the payload of wsclient.MTVideoStats message.
+type StatsSnapshot struct {
+	Health       string            `json:"health"`
+	Bitrate      float64           `json:"bitrate"` // bits per second
+	FPS          float64           `json:"fps"`
+	Bytes        uint64            `json:"bytes"`
+	Frames       uint64            `json:"frames"`
+	KeyFrames    uint64            `json:"keyFrames"`
+	NALUs        map[string]uint64 `json:"nalus"`  // by type: slice, idr, sei, sps, pps, aud, other
+	Gaps         uint64            `json:"gaps"`   // intervals between frames longer than 200 ms
+	Stalls       uint64            `json:"stalls"` // intervals without frames longer than a second
+	MaxGapMs     int64             `json:"maxGapMs"`
+	SinceFrameMs int64             `json:"sinceFrameMs"`
+	Uploads      uint64            `json:"uploads"`
+	UploadErrors uint64            `json:"uploadErrors"`
+	// of the last segment uploaded by Packager, nil if nothing measured it, e.g. over WebRTC or with ffmpeg
+	UploadLatencyMs *int64 `json:"uploadLatencyMs,omitempty"`
+}
+
+func NewStats() *Stats {
+	return &Stats{nalus: make(map[string]uint64)}
+}
+
+// AddBlock counts a block of the stream received from the drone.
+func (s *Stats) AddBlock(size int, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.bytes += uint64(size)
+	s.windowBytes += uint64(size)
+	s.roll(now)
+}
+
+// AddNALU counts a NAL unit of the stream.
+func (s *Stats) AddNALU(nalu []byte) {
+	if s == nil {
+		return
+	}
+	name, ok := nalTypeNames[h264.Type(nalu)]
+	if !ok {
+		name = "other"
+	}
+	s.mux.Lock()
+	s.nalus[name]++
+	s.mux.Unlock()
+}
+
+// AddFrame counts an access unit of the stream and detects gaps between frames.
+func (s *Stats) AddFrame(key bool, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.frames++
+	s.windowFrames++
+	if key {
+		s.keyFrames++
+	}
+	if !s.lastFrame.IsZero() {
+		gap := now.Sub(s.lastFrame)
+		if gap > gapThreshold {
+			s.gaps++
+		}
+		if gap > stallThreshold && !s.stalled {
+			s.stalls++
+		}
+		if gap > s.maxGap {
+			s.maxGap = gap
+		}
+	}
+	s.lastFrame = now
+	s.stalled = false
+	s.roll(now)
+}
+
+// AddUpload records the latency of a segment upload.
+func (s *Stats) AddUpload(latency time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err != nil {
+		s.uploadFails++
+		return
+	}
+	s.uploads++
+	s.uploadSum += latency
+	s.lastUpload = latency
+}
+
+// roll computes the rates when the window is over.
+func (s *Stats) roll(now time.Time) {
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+		return
+	}
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	s.bitrate = float64(s.windowBytes*8) / elapsed.Seconds()
+	s.fps = float64(s.windowFrames) / elapsed.Seconds()
+	s.windowStart, s.windowBytes, s.windowFrames = now, 0, 0
+}
+
+// Snapshot returns the current stats.
+func (s *Stats) Snapshot(now time.Time) StatsSnapshot {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.roll(now)
+	var sinceFrame time.Duration
+	if !s.lastFrame.IsZero() {
+		sinceFrame = now.Sub(s.lastFrame)
+	}
+	// a stall is counted once, when it's noticed
+	if sinceFrame > stallThreshold && !s.stalled {
+		s.stalls++
+		s.stalled = true
+	}
+	nalus := make(map[string]uint64, len(s.nalus))
+	for name, n := range s.nalus {
+		nalus[name] = n
+	}
+	snapshot := StatsSnapshot{
+		Bitrate:      s.bitrate,
+		FPS:          s.fps,
+		Bytes:        s.bytes,
+		Frames:       s.frames,
+		KeyFrames:    s.keyFrames,
+		NALUs:        nalus,
+		Gaps:         s.gaps,
+		Stalls:       s.stalls,
+		MaxGapMs:     s.maxGap.Milliseconds(),
+		SinceFrameMs: sinceFrame.Milliseconds(),
+		Uploads:      s.uploads,
+		UploadErrors: s.uploadFails,
+	}
+	if s.uploads > 0 {
+		latency := s.lastUpload.Milliseconds()
+		snapshot.UploadLatencyMs = &latency
+	}
+	switch {
+	case s.lastFrame.IsZero() || sinceFrame > stallThreshold:
+		snapshot.Health = VideoStalled
+	case s.fps < minHealthyFPS || sinceFrame > gapThreshold ||
+		s.segmentDuration > 0 && s.lastUpload > s.segmentDuration:
+		snapshot.Health = VideoDegraded
+	default:
+		snapshot.Health = VideoOK
+	}
+	return snapshot
+}
+
+// WriteMetrics writes the stats in Prometheus text format.
+func (s *Stats) WriteMetrics(w io.Writer, now time.Time) error {
+	snapshot := s.Snapshot(now)
+	s.mux.Lock()
+	uploadSum := s.uploadSum
+	s.mux.Unlock()
+
+	var err error
+	metric := func(name, kind, help string, values ...string) {
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for i := 0; i+1 < len(values) && err == nil; i += 2 {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", name, values[i], values[i+1])
+		}
+	}
+	number := func(v interface{}) string { return fmt.Sprint(v) }
+
+	metric("pilot_video_received_bytes_total", "counter", "Bytes of the H.264 stream received from the drone.",
+		"", number(snapshot.Bytes))
+	metric("pilot_video_frames_total", "counter", "Frames received from the drone.",
+		"", number(snapshot.Frames))
+	metric("pilot_video_key_frames_total", "counter", "Key frames received from the drone.",
+		"", number(snapshot.KeyFrames))
+	names := make([]string, 0, len(snapshot.NALUs))
+	for name := range snapshot.NALUs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var nalus []string
+	for _, name := range names {
+		nalus = append(nalus, fmt.Sprintf(`{type=%q}`, name), number(snapshot.NALUs[name]))
+	}
+	metric("pilot_video_nal_units_total", "counter", "NAL units received from the drone by type.", nalus...)
+	metric("pilot_video_gaps_total", "counter", "Intervals between frames longer than 200 ms.",
+		"", number(snapshot.Gaps))
+	metric("pilot_video_stalls_total", "counter", "Intervals without frames longer than a second.",
+		"", number(snapshot.Stalls))
+	metric("pilot_video_bitrate_bits", "gauge", "Bitrate of the stream over the last second.",
+		"", number(snapshot.Bitrate))
+	metric("pilot_video_fps", "gauge", "Frame rate of the stream over the last second.",
+		"", number(snapshot.FPS))
+	metric("pilot_video_seconds_since_frame", "gauge", "Time since the last frame.",
+		"", number(float64(snapshot.SinceFrameMs)/1000))
+	metric("pilot_video_upload_seconds", "summary", "Latency of segment uploads.",
+		"_sum", number(uploadSum.Seconds()), "_count", number(snapshot.Uploads))
+	metric("pilot_video_upload_errors_total", "counter", "Failed segment uploads.",
+		"", number(snapshot.UploadErrors))
+	return err
+}
+
+// ServeHTTP serves the metrics for Prometheus.
+func (s *Stats) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.WriteMetrics(w, time.Now()); err != nil {
+		logrus.Error(fmt.Errorf("error writing metrics: %w", err))
+	}
+}
+
+// StatsPublisher periodically sends StatsSnapshot as MTVideoStats message.
+type StatsPublisher struct {
+	wsClient wsclient.Messenger
+	stats    *Stats
+	period   time.Duration
+}
+
+func NewStatsPublisher(wsClient wsclient.Messenger, stats *Stats, period time.Duration) *StatsPublisher {
+	if period <= 0 {
+		period = DefaultStatsPeriod
+	}
+	return &StatsPublisher{
+		wsClient: wsClient,
+		stats:    stats,
+		period:   period,
+	}
+}
+
+func (p *StatsPublisher) Run(ctx context.Context) {
+	logrus.Warnf("started video stats publisher")
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// stalls are detected by snapshots, so they are taken even if nobody accepts them
+			snapshot := p.stats.Snapshot(now)
+			if !p.wsClient.Accepts(wsclient.MTVideoStats) {
+				continue
+			}
+			payload, err := json.Marshal(snapshot)
+			if err != nil {
+				logrus.Error(fmt.Errorf("error encoding video stats: %w", err))
+				continue
+			}
+			p.wsClient.SendMessage(wsclient.Message{
+				Type:    wsclient.MTVideoStats,
+				Payload: payload,
+			})
+		case <-ctx.Done():
+			logrus.Warnf("stopped video stats publisher")
+			return
+		}
+	}
+}
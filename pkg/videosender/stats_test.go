@@ -0,0 +1,63 @@
+package videosender
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+func (s *PackagerSuite) TestStats() {
+	stats := NewStats()
+	start := time.Unix(1000, 0)
+	data, err := json.Marshal(stats.Snapshot(start))
+	s.Require().NoError(err)
+	s.NotContains(string(data), "uploadLatencyMs", "latency isn't measured without uploads")
+	frame := time.Second / 30
+	for i := 0; i < 31; i++ {
+		now := start.Add(time.Duration(i) * frame)
+		stats.AddBlock(1000, now)
+		stats.AddNALU([]byte{0x41, 0x9a})
+		stats.AddFrame(i == 0, now)
+	}
+	stats.AddUpload(100*time.Millisecond, nil)
+	stats.AddUpload(0, errors.New("timeout"))
+
+	snapshot := stats.Snapshot(start.Add(time.Second + 10*time.Millisecond))
+	s.Equal(VideoOK, snapshot.Health)
+	s.InDelta(30, snapshot.FPS, 1)
+	s.InDelta(240000, snapshot.Bitrate, 8000)
+	s.Equal(uint64(31), snapshot.Frames)
+	s.Equal(uint64(1), snapshot.KeyFrames)
+	s.Equal(map[string]uint64{"slice": 31}, snapshot.NALUs)
+	s.Equal(uint64(1), snapshot.Uploads)
+	s.Equal(uint64(1), snapshot.UploadErrors)
+	s.Require().NotNil(snapshot.UploadLatencyMs)
+	s.Equal(int64(100), *snapshot.UploadLatencyMs)
+
+	// the stall is counted once, by the snapshot noticing it and by the frame ending it
+	stalled := start.Add(3 * time.Second)
+	snapshot = stats.Snapshot(stalled)
+	s.Equal(VideoStalled, snapshot.Health)
+	s.Equal(uint64(1), snapshot.Stalls)
+	s.Zero(snapshot.FPS)
+	stats.AddFrame(false, stalled)
+	snapshot = stats.Snapshot(stalled)
+	s.Equal(VideoDegraded, snapshot.Health)
+	s.Equal(uint64(1), snapshot.Stalls)
+	s.Equal(uint64(1), snapshot.Gaps)
+
+	var metrics bytes.Buffer
+	s.Require().NoError(stats.WriteMetrics(&metrics, stalled))
+	for _, line := range []string{
+		"# TYPE pilot_video_frames_total counter",
+		"pilot_video_frames_total 32",
+		`pilot_video_nal_units_total{type="slice"} 31`,
+		"pilot_video_stalls_total 1",
+		"pilot_video_upload_seconds_sum 0.1",
+		"pilot_video_upload_seconds_count 1",
+	} {
+		s.True(strings.Contains(metrics.String(), line+"\n"), line)
+	}
+}
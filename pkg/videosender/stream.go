@@ -0,0 +1,270 @@
+package videosender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/h264"
+)
+
+// Format of the live stream served by StreamServer.
+type Format string
+
+const (
+	// FormatH264 is the raw Annex B stream of the drone, one access unit per websocket message,
+	// browsers decode it with WebCodecs.
+	FormatH264 Format = "h264"
+	// FormatMJPEG is JPEG frames transcoded by ffmpeg, browsers show it in an img element.
+	FormatMJPEG Format = "mjpeg"
+)
+
+// LivePath is the path of the live stream on the web server.
+const LivePath = "/drone/video/live"
+
+// StreamMJPEG transcodes the H.264 stream of the drone into JPEG frames.
+const StreamMJPEG = `
+	ffmpeg
+		-f h264
+		-i pipe:0
+		-f image2pipe
+		-vcodec mjpeg
+		-q:v 5
+		-r 15
+		pipe:1
+`
+
+// clientBuffer is the number of frames queued for a slow client before it skips to the next key frame.
+const clientBuffer = 30
+
+// StreamServer serves the live video of the drone over plain HTTP or websocket, without DASH segments latency.
+// If addr is empty the server doesn't listen on its own and is expected to be mounted as http.Handler.
+type StreamServer struct {
+	addr         string
+	format       Format
+	sourceStream <-chan []byte
+	upgrader     websocket.Upgrader
+	supervisor   *supervisor  // of the MJPEG transcoder
+	clients      *Broadcaster // frames are published to HTTP clients
+
+	keyFrameNeeded func()
+}
+
+func NewStreamServer(addr string, sourceStream <-chan []byte, format Format) *StreamServer {
+	command := strings.Fields(StreamMJPEG)
+	s := &StreamServer{
+		addr:         addr,
+		format:       format,
+		sourceStream: sourceStream,
+		supervisor:   newSupervisor(command[0], command[1:], false),
+	}
+	// clients joining without a cached key frame need a new one from the drone
+	s.clients = NewBroadcaster(nil, func() {
+		if s.format == FormatH264 && s.keyFrameNeeded != nil {
+			s.keyFrameNeeded()
+		}
+	})
+	return s
+}
+
+// Apply restarts the MJPEG transcoder with the output size of the settings, the H.264 stream is sent as is.
+func (s *StreamServer) Apply(settings Settings) {
+	if s.format != FormatMJPEG {
+		return
+	}
+	command := strings.Fields(StreamMJPEG)
+	s.supervisor.restart(applySettings(command[1:], settings))
+}
+
+// OnKeyFrameNeeded sets the function asking the source for a key frame, e.g. Broadcaster.RequestKeyFrame,
+// it's called when a client joins without a cached key frame or the transcoder fails to decode the stream.
+// It must be set before Run.
+func (s *StreamServer) OnKeyFrameNeeded(f func()) {
+	s.keyFrameNeeded = f
+	s.supervisor.keyFrameNeeded = f
+}
+
+// Health returns the state of the MJPEG transcoder.
+func (s *StreamServer) Health() Health {
+	return s.supervisor.Health()
+}
+
+// Format returns the format of the stream.
+func (s *StreamServer) Format() Format {
+	return s.format
+}
+
+func (s *StreamServer) Run(ctx context.Context) {
+	logrus.Warnf("started %s video stream", s.format)
+	if s.addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(LivePath, s)
+		srv := &http.Server{Addr: s.addr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Error(fmt.Errorf("error serving video stream: %w", err))
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+	}
+
+	if s.format == FormatMJPEG {
+		s.transcode(ctx)
+	} else {
+		s.forward(ctx)
+	}
+	logrus.Warnf("stopped %s video stream", s.format)
+}
+
+// forward publishes access units of the drone's stream.
+func (s *StreamServer) forward(ctx context.Context) {
+	var (
+		splitter  h264.Splitter
+		assembler h264.Assembler
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case block, ok := <-s.sourceStream:
+			if !ok {
+				logrus.Warnf("video stream closed")
+				<-ctx.Done()
+				return
+			}
+			for _, nalu := range splitter.Write(block) {
+				if au, ok := assembler.Push(nalu); ok {
+					s.clients.PublishUnit(au)
+				}
+			}
+		}
+	}
+}
+
+// transcode runs ffmpeg converting the drone's stream to JPEG frames.
+func (s *StreamServer) transcode(ctx context.Context) {
+	s.supervisor.run(ctx, s.sourceStream, func(stdout io.Reader) {
+		readJPEGs(stdout, func(frame []byte) { s.clients.Publish(frame, true) })
+	})
+}
+
+// readJPEGs splits concatenated JPEG images, they end with the EOI marker which can't appear inside.
+func readJPEGs(r io.Reader, frame func([]byte)) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var buf []byte
+	for {
+		chunk, err := br.ReadSlice(0xd9)
+		buf = append(buf, chunk...)
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return
+		}
+		if len(buf) >= 4 && bytes.HasSuffix(buf, []byte{0xff, 0xd9}) {
+			if start := bytes.Index(buf, []byte{0xff, 0xd8}); start >= 0 {
+				frame(append([]byte(nil), buf[start:]...))
+			}
+			buf = buf[:0]
+		}
+	}
+}
+
+// ServeHTTP streams frames as websocket binary messages if the request is a websocket upgrade,
+// otherwise as a raw H.264 byte stream or a multipart MJPEG stream.
+func (s *StreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	const boundary = "frame"
+	if s.format == FormatMJPEG {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	} else {
+		w.Header().Set("Content-Type", "video/h264")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	c := s.clients.Subscribe(clientBuffer)
+	defer s.clients.Unsubscribe(c)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-c.Frames():
+			if !ok {
+				return
+			}
+			var err error
+			if s.format == FormatMJPEG {
+				_, err = fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %s\r\n\r\n",
+					boundary, strconv.Itoa(len(frame)))
+				if err == nil {
+					_, err = w.Write(frame)
+				}
+				if err == nil {
+					_, err = io.WriteString(w, "\r\n")
+				}
+			} else {
+				_, err = w.Write(frame)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *StreamServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error upgrading video web socket: %w", err))
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// the client doesn't send anything, reading detects closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := s.clients.Subscribe(clientBuffer)
+	defer s.clients.Unsubscribe(c)
+	for {
+		select {
+		case <-closed:
+			return
+		case frame, ok := <-c.Frames():
+			if !ok {
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+			if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package videosender
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func (s *PackagerSuite) TestStreamH264() {
+	stream := make(chan []byte, 100)
+	server := NewStreamServer("", stream, FormatH264)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+
+	http := httptest.NewServer(server)
+	defer http.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(http.URL, "http")+LivePath, nil)
+	s.Require().NoError(err)
+	defer func() { _ = conn.Close() }()
+	s.Eventually(func() bool {
+		server.clients.mux.Lock()
+		defer server.clients.mux.Unlock()
+		return len(server.clients.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the client joins at the key frame, the frame before it can't be decoded
+	stream <- []byte{0, 0, 0, 1, 0x41, 0x9a, 1}
+	stream <- []byte{0, 0, 0, 1, 0x65, 0x88, 2}
+	stream <- []byte{0, 0, 0, 1, 0x41, 0x9a, 3}
+	stream <- []byte{0, 0, 0, 1, 0x41, 0x9a, 4}
+	stream <- []byte{0, 0, 0, 1, 0x41, 0x9a, 5}
+
+	for _, expected := range [][]byte{{0, 0, 0, 1, 0x65, 0x88, 2}, {0, 0, 0, 1, 0x41, 0x9a, 3}} {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, frame, err := conn.ReadMessage()
+		s.Require().NoError(err)
+		s.Equal(expected, frame)
+	}
+}
+
+func (s *PackagerSuite) TestReadJPEGs() {
+	first := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0xd9, 0xff, 0x00, 0xff, 0xd9}
+	second := []byte{0xff, 0xd8, 0x01, 0xff, 0xd9}
+	var frames [][]byte
+	readJPEGs(bytes.NewReader(append(append([]byte(nil), first...), second...)), func(frame []byte) {
+		frames = append(frames, frame)
+	})
+	s.Equal([][]byte{first, second}, frames)
+}
@@ -0,0 +1,314 @@
+package videosender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = 30 * time.Second
+	// stableRun is the running time after which the encoder is considered healthy and the restart delay is reset
+	stableRun = time.Minute
+	// inputQueueSize is the number of stream blocks waiting for the encoder, newer blocks are dropped when it's full
+	inputQueueSize = 256
+)
+
+var errStreamClosed = errors.New("video stream closed")
+
+// Health of the external encoder reported to the UI.
+type Health struct {
+	Running   bool      `json:"running"`
+	Since     time.Time `json:"since"` // start of the current run or time of the last exit
+	FPS       float64   `json:"fps"`   // encoding speed reported by ffmpeg
+	Restarts  int       `json:"restarts"`
+	Dropped   uint64    `json:"dropped"` // stream blocks dropped while the encoder wasn't running or couldn't keep up
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// supervisor runs an external encoder, feeds it with the stream and restarts it with backoff when it exits.
+type supervisor struct {
+	name     string
+	args     []string
+	debugLog bool
+	// keyFrameNeeded is called when the encoder starts or can't decode the stream, set before run
+	keyFrameNeeded func()
+
+	mux       sync.Mutex
+	health    Health
+	stopRun   context.CancelFunc // stops the running encoder
+	restarted bool               // the encoder is stopped to restart it with new arguments
+}
+
+func newSupervisor(name string, args []string, debugLog bool) *supervisor {
+	return &supervisor{
+		name:     name,
+		args:     args,
+		debugLog: debugLog,
+	}
+}
+
+func (s *supervisor) Health() Health {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.health
+}
+
+func (s *supervisor) update(f func(h *Health)) {
+	s.mux.Lock()
+	f(&s.health)
+	s.mux.Unlock()
+}
+
+// run keeps the encoder running until ctx is done. The encoder reads input from stdin if input isn't nil,
+// its stdout is passed to output if output isn't nil.
+func (s *supervisor) run(ctx context.Context, input <-chan []byte, output func(io.Reader)) {
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		err := s.runOnce(ctx, input, output)
+		var restarted bool
+		s.update(func(h *Health) { h.Running, h.Since, h.FPS = false, time.Now(), 0 })
+		s.mux.Lock()
+		restarted, s.restarted, s.stopRun = s.restarted, false, nil
+		s.mux.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if restarted {
+			logrus.Warnf("restarting %s with new settings", s.name)
+			continue
+		}
+		if errors.Is(err, errStreamClosed) {
+			logrus.Warnf("video stream closed")
+			<-ctx.Done()
+			return
+		}
+		logrus.Error(fmt.Errorf("error running %s: %w", s.name, err))
+		s.update(func(h *Health) {
+			h.Restarts++
+			h.LastError = err.Error()
+		})
+
+		if time.Since(started) > stableRun {
+			delay = minRestartDelay
+		}
+		if !s.wait(ctx, input, delay) {
+			return
+		}
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// wait drops the stream for the delay, returns false if ctx is done.
+func (s *supervisor) wait(ctx context.Context, input <-chan []byte, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case _, ok := <-input:
+			if !ok {
+				input = nil // exits on the next run
+				continue
+			}
+			s.update(func(h *Health) { h.Dropped++ })
+		}
+	}
+}
+
+// restart replaces the arguments and restarts the running encoder at once, without counting it as a failure.
+func (s *supervisor) restart(args []string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.args = args
+	if s.stopRun != nil {
+		s.restarted = true
+		s.stopRun()
+	}
+}
+
+func (s *supervisor) runOnce(ctx context.Context, input <-chan []byte, output func(io.Reader)) error {
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mux.Lock()
+	cmd := exec.CommandContext(cmdCtx, s.name, s.args...)
+	s.stopRun = cancel
+	s.mux.Unlock()
+
+	var (
+		stdin  io.WriteCloser
+		stdout io.ReadCloser
+		err    error
+	)
+	if input != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return fmt.Errorf("error opening stdin pipe: %w", err)
+		}
+	}
+	if output != nil {
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return fmt.Errorf("error opening stdout pipe: %w", err)
+		}
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error opening stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting command: %w", err)
+	}
+	s.update(func(h *Health) { h.Running, h.Since = true, time.Now() })
+	if input != nil {
+		s.requestKeyFrame()
+	}
+
+	// Wait must be called after the pipes are read to the end
+	var (
+		readers  sync.WaitGroup
+		lastLine string
+	)
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		lastLine = s.readStderr(stderr)
+	}()
+	if output != nil {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			output(stdout)
+			_, _ = io.Copy(io.Discard, stdout)
+		}()
+	}
+	exited := make(chan error, 1)
+	go func() {
+		readers.Wait()
+		exited <- cmd.Wait()
+	}()
+
+	// a stalled encoder must not block reading the stream, so it's written from a queue
+	var (
+		queue    chan []byte
+		writeErr = make(chan error, 1)
+	)
+	if input != nil {
+		queue = make(chan []byte, inputQueueSize)
+		go func() {
+			defer func() { _ = stdin.Close() }()
+			for block := range queue {
+				if _, err := stdin.Write(block); err != nil {
+					writeErr <- fmt.Errorf("error writing stream block: %w", err)
+					return
+				}
+			}
+		}()
+		defer close(queue)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-exited
+			return nil
+		case err := <-exited:
+			return exitError(err, lastLine)
+		case err := <-writeErr:
+			cancel()
+			<-exited
+			return err
+		case block, ok := <-input:
+			if !ok {
+				cancel()
+				<-exited
+				return errStreamClosed
+			}
+			select {
+			case queue <- block:
+			default:
+				s.update(func(h *Health) { h.Dropped++ })
+			}
+		}
+	}
+}
+
+// exitError describes why the encoder exited, ffmpeg prints the reason as its last line.
+func exitError(err error, lastLine string) error {
+	if err == nil {
+		err = errors.New("exited")
+	}
+	if lastLine != "" {
+		return fmt.Errorf("%w: %s", err, lastLine)
+	}
+	return err
+}
+
+var (
+	fpsRegexp = regexp.MustCompile(`fps=\s*([\d.]+)`)
+	// decodeErrorRegexp matches ffmpeg's H.264 decoder errors, they are fixed by the next key frame
+	decodeErrorRegexp = regexp.MustCompile(`error while decoding|non-existing PPS|decode_slice_header error|no frame!`)
+)
+
+func (s *supervisor) requestKeyFrame() {
+	if s.keyFrameNeeded != nil {
+		s.keyFrameNeeded()
+	}
+}
+
+// readStderr tracks ffmpeg progress lines and returns the last line of the output.
+func (s *supervisor) readStderr(stderr io.Reader) string {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanLines)
+	var last string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if m := fpsRegexp.FindStringSubmatch(line); m != nil {
+			if fps, err := strconv.ParseFloat(m[1], 64); err == nil {
+				s.update(func(h *Health) { h.FPS = fps })
+			}
+		} else {
+			last = line
+		}
+		if decodeErrorRegexp.MatchString(line) {
+			s.requestKeyFrame()
+		}
+		if s.debugLog {
+			logrus.WithField("label", "FFMPEG_STDERR").Warn(line)
+		}
+	}
+	return last
+}
+
+// scanLines splits lines ending with \n or \r, ffmpeg ends progress lines with \r only.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,87 @@
+package videosender
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SupervisorSuite struct {
+	suite.Suite
+}
+
+func TestSupervisorSuite(t *testing.T) {
+	suite.Run(t, new(SupervisorSuite))
+}
+
+func (s *SupervisorSuite) TestRestarts() {
+	sup := newSupervisor("sh", []string{"-c", `printf 'frame= 10 fps= 25.0\r' >&2; echo "broken pipe" >&2; exit 3`}, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sup.run(ctx, nil, nil)
+	}()
+
+	s.Eventually(func() bool { return sup.Health().Restarts >= 2 }, 5*time.Second, 10*time.Millisecond)
+	health := sup.Health()
+	s.False(health.Running)
+	s.Equal("exit status 3: broken pipe", health.LastError)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		s.Fail("supervisor didn't stop")
+	}
+
+	// new settings restart the encoder with new arguments, it isn't a failure
+	sup = newSupervisor("sh", []string{"-c", "echo first; exec sleep 10"}, false)
+	output := make(chan string, 2)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go sup.run(ctx, nil, func(stdout io.Reader) {
+		data, _ := io.ReadAll(stdout)
+		output <- strings.TrimSpace(string(data))
+	})
+	s.Eventually(func() bool { return sup.Health().Running }, time.Second, 10*time.Millisecond)
+	sup.restart([]string{"-c", "echo second; exec sleep 10"})
+	s.Equal("first", <-output)
+	s.Eventually(func() bool { return sup.Health().Running }, time.Second, 10*time.Millisecond)
+	cancel()
+	s.Equal("second", <-output)
+	s.Zero(sup.Health().Restarts)
+}
+
+func (s *SupervisorSuite) TestPipes() {
+	sup := newSupervisor("cat", nil, false)
+	input := make(chan []byte)
+	output := make(chan []byte, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sup.run(ctx, input, func(stdout io.Reader) {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(stdout, buf); err == nil {
+			output <- buf
+		}
+	})
+
+	input <- []byte("hel")
+	input <- []byte("lo")
+	select {
+	case data := <-output:
+		s.Equal("hello", string(data))
+	case <-time.After(time.Second):
+		s.Fail("no output")
+	}
+	s.True(sup.Health().Running)
+
+	// closed stream stops the encoder without restarts
+	close(input)
+	s.Eventually(func() bool { return !sup.Health().Running || sup.Health().Restarts > 0 }, time.Second, 10*time.Millisecond)
+	s.Zero(sup.Health().Restarts)
+}
@@ -1,15 +1,11 @@
 package videosender
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"io"
-	"os/exec"
 	"regexp"
 	"strings"
-	"time"
 )
 
 const StreamCamera = `
@@ -47,69 +43,48 @@ const StreamPipe = `
 		%sdrone/video/fs/feed
 `
 
+// Sender runs ffmpeg encoding the stream, or the camera with StreamCamera, and uploading DASH segments to destURL.
 type Sender struct {
-	debugLog     bool
 	command      string
+	args         []string // of the command before settings are applied
 	sourceStream <-chan []byte
-	destURL      string
+	supervisor   *supervisor
 }
 
 func New(destURL string, sourceStream <-chan []byte, command string, debugLog bool) *Sender {
+	name, args := parseCommand(command, destURL)
 	return &Sender{
-		debugLog:     debugLog,
 		command:      command,
+		args:         args,
 		sourceStream: sourceStream,
-		destURL:      destURL,
+		supervisor:   newSupervisor(name, args, debugLog),
 	}
 }
 
-func (s *Sender) Run(ctx context.Context) {
-	logrus.Warnf("started video sender")
-	const retryInterval = 5 * time.Second
-	timer := time.NewTimer(0)
-	name, args := parseCommand(s.command, s.destURL)
-
-	for {
-		select {
-		case <-ctx.Done():
-			timer.Stop()
-			logrus.Warnf("stopped video sender")
-		case <-timer.C:
-			func() {
-				cmd := exec.CommandContext(ctx, name, args...)
-				stdinPipe, err := cmd.StdinPipe()
-				if err != nil {
-					logrus.Error(fmt.Errorf("error opening stdin pipe: %w", err))
-					return
-				}
-				defer func() { _ = stdinPipe.Close() }()
-
-				stderrPipe, err := cmd.StderrPipe()
-				if err != nil {
-					logrus.Error(fmt.Errorf("error opening stderr pipe: %w", err))
-					return
-				}
-				defer func() { _ = stderrPipe.Close() }()
+// Apply restarts ffmpeg with the output size and key frame interval of the settings.
+func (s *Sender) Apply(settings Settings) {
+	s.supervisor.restart(applySettings(s.args, settings))
+}
 
-				if err := cmd.Start(); err != nil {
-					logrus.Error(fmt.Errorf("error starting command: %w", err))
-				}
+// OnKeyFrameNeeded sets the function asking the source for a key frame, e.g. Broadcaster.RequestKeyFrame,
+// it's called when ffmpeg starts or fails to decode the stream. It must be set before Run.
+func (s *Sender) OnKeyFrameNeeded(f func()) {
+	s.supervisor.keyFrameNeeded = f
+}
 
-				if s.debugLog {
-					go logStderr(ctx, stderrPipe)
-				}
+// Health returns the state of the ffmpeg process.
+func (s *Sender) Health() Health {
+	return s.supervisor.Health()
+}
 
-				if s.command == StreamPipe {
-					for block := range s.sourceStream {
-						if _, err := stdinPipe.Write(block); err != nil {
-							logrus.Error(fmt.Errorf("error writing stream block: %w", err))
-						}
-					}
-				}
-			}()
-			timer.Reset(retryInterval)
-		}
+func (s *Sender) Run(ctx context.Context) {
+	logrus.Warnf("started video sender")
+	var input <-chan []byte
+	if s.command == StreamPipe {
+		input = s.sourceStream
 	}
+	s.supervisor.run(ctx, input, nil)
+	logrus.Warnf("stopped video sender")
 }
 
 var spaceRegexp = regexp.MustCompile(`[\t\n\s]+`)
@@ -124,22 +99,3 @@ func parseCommand(command string, destURL string) (name string, args []string) {
 	}
 	return lines[0], lines[1:]
 }
-
-func logStderr(ctx context.Context, stderrPipe io.ReadCloser) {
-	bufStderr := bufio.NewReader(stderrPipe)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			line, _, err := bufStderr.ReadLine()
-			if err != nil {
-				logrus.Error(fmt.Errorf("error reading stderr: %w", err))
-				return
-			}
-			if len(line) > 0 {
-				logrus.WithField("label", "FFMPEG_STDERR").Warn(string(line))
-			}
-		}
-	}
-}
@@ -0,0 +1,232 @@
+package videosender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/h264"
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// Signal is the payload of wsclient.MTWebRTC messages. A viewer sends an offer with all its ICE candidates,
+// the pilot answers the same way, so no trickle ICE is needed.
+type Signal struct {
+	ID    string `json:"id"`   // chosen by the viewer, answers are matched by it if the session isn't known
+	Type  string `json:"type"` // "offer", "answer" or "close"
+	SDP   string `json:"sdp,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// gatherTimeout limits collecting ICE candidates of an answer, e.g. when STUN servers are unreachable.
+const gatherTimeout = 5 * time.Second
+
+// WebRTC sends the H.264 stream of the drone to viewers over WebRTC without transcoding,
+// which has much lower latency than DASH. Viewers negotiate connections over the websocket.
+type WebRTC struct {
+	wsClient     wsclient.Messenger
+	sourceStream <-chan []byte
+	config       webrtc.Configuration
+	track        *webrtc.TrackLocalStaticSample
+
+	keyFrameNeeded func()
+
+	mux   sync.Mutex
+	peers map[string]*webrtc.PeerConnection // by session and signal ID
+}
+
+// NewWebRTC creates the sender, iceServers are STUN/TURN URLs, they aren't needed in a local network.
+func NewWebRTC(wsClient wsclient.Messenger, sourceStream <-chan []byte, iceServers []string) (*WebRTC, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: timescale},
+		"video",
+		"pilot",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating video track: %w", err)
+	}
+	w := &WebRTC{
+		wsClient:     wsClient,
+		sourceStream: sourceStream,
+		track:        track,
+		peers:        make(map[string]*webrtc.PeerConnection),
+	}
+	if len(iceServers) > 0 {
+		w.config.ICEServers = []webrtc.ICEServer{{URLs: iceServers}}
+	}
+	return w, nil
+}
+
+// OnKeyFrameNeeded sets the function asking the source for a key frame, e.g. Broadcaster.RequestKeyFrame,
+// it's called when a viewer connects or reports a picture loss. It must be set before Run.
+func (w *WebRTC) OnKeyFrameNeeded(f func()) {
+	w.keyFrameNeeded = f
+}
+
+func (w *WebRTC) requestKeyFrame() {
+	if w.keyFrameNeeded != nil {
+		w.keyFrameNeeded()
+	}
+}
+
+func (w *WebRTC) Run(ctx context.Context) {
+	logrus.Warnf("started webrtc video sender")
+	defer func() {
+		w.mux.Lock()
+		for key, pc := range w.peers {
+			_ = pc.Close()
+			delete(w.peers, key)
+		}
+		w.mux.Unlock()
+		logrus.Warnf("stopped webrtc video sender")
+	}()
+
+	var (
+		splitter  h264.Splitter
+		assembler h264.Assembler
+		lastWrite time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case block, ok := <-w.sourceStream:
+			if !ok {
+				logrus.Warnf("video stream closed")
+				<-ctx.Done()
+				return
+			}
+			for _, nalu := range splitter.Write(block) {
+				au, ok := assembler.Push(nalu)
+				if !ok {
+					continue
+				}
+				now := time.Now()
+				duration := time.Second / 30
+				if !lastWrite.IsZero() && now.Sub(lastWrite) < time.Second {
+					duration = now.Sub(lastWrite)
+				}
+				lastWrite = now
+				if err := w.track.WriteSample(media.Sample{Data: au.AnnexB(), Duration: duration}); err != nil {
+					logrus.Error(fmt.Errorf("error writing video sample: %w", err))
+				}
+			}
+		}
+	}
+}
+
+// HandleSignal negotiates connections with viewers, it's called with wsclient.MTWebRTC messages.
+func (w *WebRTC) HandleSignal(msg wsclient.Message) {
+	var signal Signal
+	if err := json.Unmarshal(msg.Payload, &signal); err != nil {
+		logrus.Warnf("broken webrtc message")
+		return
+	}
+	key := msg.Session + "/" + signal.ID
+	switch signal.Type {
+	case "offer":
+		// gathering candidates takes a while, the caller's loop must not wait for it
+		go func() {
+			answer := Signal{ID: signal.ID, Type: "answer"}
+			sdp, err := w.answer(key, signal.SDP)
+			if err != nil {
+				logrus.Error(fmt.Errorf("error answering webrtc offer: %w", err))
+				answer.Error = err.Error()
+			}
+			answer.SDP = sdp
+			payload, _ := json.Marshal(answer)
+			w.wsClient.SendMessage(wsclient.Message{Type: wsclient.MTWebRTC, Session: msg.Session, Payload: payload})
+		}()
+	case "close":
+		w.closePeer(key)
+	}
+}
+
+func (w *WebRTC) answer(key, offer string) (string, error) {
+	w.closePeer(key)
+	pc, err := webrtc.NewPeerConnection(w.config)
+	if err != nil {
+		return "", err
+	}
+	sender, err := pc.AddTrack(w.track)
+	if err != nil {
+		_ = pc.Close()
+		return "", err
+	}
+	// RTCP must be read for interceptors, e.g. NACK handling, decoders of viewers ask for key frames with it
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				switch packet.(type) {
+				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+					w.requestKeyFrame()
+				}
+			}
+		}
+	}()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logrus.Warnf("webrtc viewer %s %s", key, state)
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			w.requestKeyFrame()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			w.removePeer(key, pc)
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		_ = pc.Close()
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		_ = pc.Close()
+		return "", err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+		logrus.Warnf("webrtc candidates gathering timed out")
+	}
+
+	w.mux.Lock()
+	w.peers[key] = pc
+	w.mux.Unlock()
+	return pc.LocalDescription().SDP, nil
+}
+
+func (w *WebRTC) closePeer(key string) {
+	w.mux.Lock()
+	pc, ok := w.peers[key]
+	delete(w.peers, key)
+	w.mux.Unlock()
+	if ok {
+		_ = pc.Close()
+	}
+}
+
+// removePeer forgets the connection unless it was already replaced by a new one.
+func (w *WebRTC) removePeer(key string, pc *webrtc.PeerConnection) {
+	w.mux.Lock()
+	if w.peers[key] == pc {
+		delete(w.peers, key)
+	}
+	w.mux.Unlock()
+	_ = pc.Close()
+}
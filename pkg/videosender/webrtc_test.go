@@ -0,0 +1,62 @@
+package videosender
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/einherij/pilot/pkg/wsclient"
+)
+
+// messenger collects sent messages.
+type messenger struct {
+	sent chan wsclient.Message
+}
+
+func (m *messenger) SendMessage(msg wsclient.Message) { m.sent <- msg }
+func (m *messenger) ReceiveMessage(ctx context.Context) wsclient.Message {
+	<-ctx.Done()
+	return wsclient.Message{}
+}
+func (m *messenger) Accepts(wsclient.MessageType) bool { return true }
+
+func (s *PackagerSuite) TestWebRTCAnswer() {
+	ws := &messenger{sent: make(chan wsclient.Message, 1)}
+	sender, err := NewWebRTC(ws, make(chan []byte), nil)
+	s.Require().NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sender.Run(ctx)
+
+	viewer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	s.Require().NoError(err)
+	defer func() { _ = viewer.Close() }()
+	_, err = viewer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	s.Require().NoError(err)
+	offer, err := viewer.CreateOffer(nil)
+	s.Require().NoError(err)
+	gathered := webrtc.GatheringCompletePromise(viewer)
+	s.Require().NoError(viewer.SetLocalDescription(offer))
+	<-gathered
+
+	payload, _ := json.Marshal(Signal{ID: "v1", Type: "offer", SDP: viewer.LocalDescription().SDP})
+	sender.HandleSignal(wsclient.Message{Type: wsclient.MTWebRTC, Session: "1", Payload: payload})
+
+	select {
+	case msg := <-ws.sent:
+		s.Equal("1", msg.Session)
+		var answer Signal
+		s.Require().NoError(json.Unmarshal(msg.Payload, &answer))
+		s.Equal("v1", answer.ID)
+		s.Equal("answer", answer.Type)
+		s.Empty(answer.Error)
+		s.True(strings.Contains(answer.SDP, "H264"))
+		s.NoError(viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}))
+	case <-time.After(10 * time.Second):
+		s.Fail("no answer")
+	}
+}
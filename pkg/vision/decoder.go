@@ -0,0 +1,95 @@
+package vision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Frame size and rate of the decoder, the wide camera mode keeps 16:9 and markers are looked for
+// a few times per second.
+const (
+	DefaultWidth  = 640
+	DefaultHeight = 360
+	DefaultFPS    = 5
+)
+
+// Decoder runs a command decoding the H.264 stream from stdin to raw grayscale frames on stdout, e.g. ffmpeg.
+type Decoder struct {
+	Name   string
+	Args   []string
+	Width  int
+	Height int
+}
+
+// NewDecoder returns the ffmpeg decoder of frames scaled to the size at the frame rate.
+func NewDecoder(width, height, fps int) Decoder {
+	return Decoder{
+		Name: "ffmpeg",
+		Args: []string{
+			"-loglevel", "error",
+			"-fflags", "nobuffer",
+			"-f", "h264",
+			"-i", "pipe:0",
+			"-vf", fmt.Sprintf("fps=%d,scale=%d:%d", fps, width, height),
+			"-pix_fmt", "gray",
+			"-f", "rawvideo",
+			"pipe:1",
+		},
+		Width:  width,
+		Height: height,
+	}
+}
+
+// Decode runs the decoder on the stream and calls f with every frame until the stream ends.
+// Frames are reused, f must not keep them.
+func (d Decoder) Decode(ctx context.Context, stream io.Reader, f func(frame *image.Gray)) error {
+	cmd := exec.CommandContext(ctx, d.Name, d.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error opening decoder input: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error opening decoder output: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("error starting decoder: %w", err)
+	}
+	go func() {
+		_, _ = io.Copy(stdin, stream)
+		_ = stdin.Close()
+	}()
+
+	frame := image.NewGray(image.Rect(0, 0, d.Width, d.Height))
+	for {
+		_, err = io.ReadFull(stdout, frame.Pix)
+		if err != nil {
+			break
+		}
+		f(frame)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("decoder output ends with a part of a frame")
+	} else {
+		err = fmt.Errorf("error reading decoder output: %w", err)
+		_ = cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if waitErr != nil {
+		return fmt.Errorf("decoder failed: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
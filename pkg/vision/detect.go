@@ -0,0 +1,361 @@
+package vision
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// markerCells is the side of a marker in cells: the black border around 5x5 bits
+	markerCells = 7
+	// minMarkerSide is the side in pixels of the smallest marker detected, a cell needs a couple of pixels
+	minMarkerSide = 14
+	// thresholdOffset is how much darker than its neighbourhood a pixel must be to be dark
+	thresholdOffset = 7
+	// minContrast is the difference between the border and the white bits of a marker
+	minContrast = 30
+)
+
+// arucoRows are the rows of bits of the original ArUco dictionary, the index is the 2 bits a row encodes.
+var arucoRows = [4]uint8{0x10, 0x17, 0x09, 0x0e}
+
+// Detection is a marker found in a frame.
+type Detection struct {
+	ID      int
+	Corners [4][2]float64 // in pixels: top left, top right, bottom right and bottom left corners of the marker
+}
+
+// Detect finds markers of the original ArUco dictionary (5x5 bits, 1024 ids) in the frame. The markers need
+// a white margin around them.
+func Detect(img *image.Gray) []Detection {
+	var detections []Detection
+	for _, quad := range findQuads(img, binarize(img)) {
+		for rotation := 0; rotation < 4; rotation++ {
+			var corners [4][2]float64
+			for i := range corners {
+				corners[i] = quad[(i+rotation)%4]
+			}
+			if id, ok := readMarker(img, corners); ok {
+				detections = append(detections, Detection{ID: id, Corners: corners})
+				break
+			}
+		}
+	}
+	return detections
+}
+
+// binarize marks pixels darker than their neighbourhood, so markers are found in uneven light.
+// Wide dark areas become their edges, which is enough to find the outline of a marker.
+func binarize(img *image.Gray) []bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	// integral image of the frame
+	sums := make([]int64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row int64
+		for x := 0; x < w; x++ {
+			row += int64(img.Pix[y*img.Stride+x])
+			sums[(y+1)*(w+1)+x+1] = sums[y*(w+1)+x+1] + row
+		}
+	}
+	radius := w / 32
+	if radius < 3 {
+		radius = 3
+	}
+	dark := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := maxInt(y-radius, 0), minInt(y+radius+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := maxInt(x-radius, 0), minInt(x+radius+1, w)
+			sum := sums[y1*(w+1)+x1] - sums[y0*(w+1)+x1] - sums[y1*(w+1)+x0] + sums[y0*(w+1)+x0]
+			mean := sum / int64((y1-y0)*(x1-x0))
+			dark[y*w+x] = int64(img.Pix[y*img.Stride+x]) < mean-thresholdOffset
+		}
+	}
+	return dark
+}
+
+// findQuads returns the quadrilaterals outlined by connected dark pixels, corners go clockwise on the frame.
+func findQuads(img *image.Gray, dark []bool) [][4][2]float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	visited := make([]bool, w*h)
+	var quads [][4][2]float64
+	var stack, pixels []int
+	for start := range dark {
+		if !dark[start] || visited[start] {
+			continue
+		}
+		// flood fill the component
+		pixels = pixels[:0]
+		stack = append(stack[:0], start)
+		visited[start] = true
+		minX, minY, maxX, maxY := w, h, 0, 0
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pixels = append(pixels, i)
+			x, y := i%w, i/w
+			minX, minY, maxX, maxY = minInt(minX, x), minInt(minY, y), maxInt(maxX, x), maxInt(maxY, y)
+			for _, n := range [4]int{i - 1, i + 1, i - w, i + w} {
+				if n < 0 || n >= len(dark) || (n == i-1 && x == 0) || (n == i+1 && x == w-1) {
+					continue
+				}
+				if dark[n] && !visited[n] {
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+		// markers cut by the edge of the frame can't be read
+		if maxX-minX < minMarkerSide || maxY-minY < minMarkerSide ||
+			minX == 0 || minY == 0 || maxX == w-1 || maxY == h-1 {
+			continue
+		}
+		if quad, ok := fitQuad(pixels, w); ok {
+			quads = append(quads, refineQuad(img, quad))
+		}
+	}
+	return quads
+}
+
+// fitQuad finds the corners of the convex quadrilateral covered by the pixels: the farthest pixel from the
+// center, the farthest one from it and the farthest ones on both sides of the diagonal between them.
+func fitQuad(pixels []int, w int) ([4][2]float64, bool) {
+	point := func(i int) (float64, float64) { return float64(i%w) + 0.5, float64(i/w) + 0.5 }
+	var cx, cy float64
+	for _, i := range pixels {
+		x, y := point(i)
+		cx, cy = cx+x, cy+y
+	}
+	cx, cy = cx/float64(len(pixels)), cy/float64(len(pixels))
+	farthest := func(distance func(x, y float64) float64) (float64, float64, float64) {
+		var bestX, bestY float64
+		best := math.Inf(-1)
+		for _, i := range pixels {
+			x, y := point(i)
+			if d := distance(x, y); d > best {
+				best, bestX, bestY = d, x, y
+			}
+		}
+		return bestX, bestY, best
+	}
+	x0, y0, _ := farthest(func(x, y float64) float64 { return math.Hypot(x-cx, y-cy) })
+	x2, y2, diagonal := farthest(func(x, y float64) float64 { return math.Hypot(x-x0, y-y0) })
+	side := func(x, y float64) float64 { return ((x2-x0)*(y-y0) - (y2-y0)*(x-x0)) / diagonal }
+	x1, y1, d1 := farthest(side)
+	x3, y3, d3 := farthest(func(x, y float64) float64 { return -side(x, y) })
+	// a square seen at an angle is still wide on both sides of its diagonal
+	if d1 < minMarkerSide/2 || d3 < minMarkerSide/2 {
+		return [4][2]float64{}, false
+	}
+	// y of the frame goes down, so the corner on the negative side follows the first one clockwise
+	quad := [4][2]float64{{x0, y0}, {x3, y3}, {x2, y2}, {x1, y1}}
+	// pixel centers are half a pixel inside the outline
+	for i := range quad {
+		quad[i][0] += math.Copysign(0.5, quad[i][0]-cx)
+		quad[i][1] += math.Copysign(0.5, quad[i][1]-cy)
+	}
+	return quad, true
+}
+
+// refineQuad moves the sides of the quadrilateral to the edges between dark and light found with subpixel
+// precision and returns the crossings of the sides. Corners of pixels are too coarse to measure the pose.
+func refineQuad(img *image.Gray, quad [4][2]float64) [4][2]float64 {
+	const samples = 16
+	var cx, cy float64
+	for _, c := range quad {
+		cx, cy = cx+c[0]/4, cy+c[1]/4
+	}
+	var lines [4][4]float64 // point and direction of every side
+	for i := range quad {
+		a, b := quad[i], quad[(i+1)%4]
+		length := math.Hypot(b[0]-a[0], b[1]-a[1])
+		dx, dy := (b[0]-a[0])/length, (b[1]-a[1])/length
+		nx, ny := -dy, dx
+		if nx*((a[0]+b[0])/2-cx)+ny*((a[1]+b[1])/2-cy) < 0 {
+			nx, ny = -nx, -ny // outwards
+		}
+		reach := math.Max(1.5, length/markerCells/2)
+		var points [][2]float64
+		for k := 1; k < samples; k++ {
+			t := 0.15 + 0.7*float64(k)/samples
+			px, py := a[0]+(b[0]-a[0])*t, a[1]+(b[1]-a[1])*t
+			if offset, ok := findEdge(img, px, py, nx, ny, reach); ok {
+				points = append(points, [2]float64{px + nx*offset, py + ny*offset})
+			}
+		}
+		line, ok := fitLine(points)
+		if !ok {
+			return quad
+		}
+		lines[i] = line
+	}
+	var refined [4][2]float64
+	for i := range refined {
+		corner, ok := intersect(lines[(i+3)%4], lines[i])
+		// a corner far from the rough one means the edges weren't found
+		if !ok || math.Hypot(corner[0]-quad[i][0], corner[1]-quad[i][1]) > 3 {
+			return quad
+		}
+		refined[i] = corner
+	}
+	return refined
+}
+
+// findEdge returns the offset along the normal from the point where the brightness crosses the middle
+// between the dark side and the light one.
+func findEdge(img *image.Gray, x, y, nx, ny, reach float64) (float64, bool) {
+	const step = 0.25
+	n := int(2*reach/step) + 1
+	values := make([]float64, n)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		d := -reach + float64(i)*step
+		values[i] = bilinear(img, x+nx*d, y+ny*d)
+		lo, hi = math.Min(lo, values[i]), math.Max(hi, values[i])
+	}
+	if hi-lo < minContrast {
+		return 0, false
+	}
+	mid := (lo + hi) / 2
+	for i := 1; i < n; i++ {
+		if values[i-1] < mid && values[i] >= mid {
+			frac := (mid - values[i-1]) / (values[i] - values[i-1])
+			return -reach + (float64(i-1)+frac)*step, true
+		}
+	}
+	return 0, false
+}
+
+// bilinear returns the brightness between pixel centers.
+func bilinear(img *image.Gray, x, y float64) float64 {
+	x, y = x-0.5, y-0.5
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	at := func(px, py int) float64 {
+		px = minInt(maxInt(px, 0), img.Rect.Dx()-1)
+		py = minInt(maxInt(py, 0), img.Rect.Dy()-1)
+		return float64(img.Pix[py*img.Stride+px])
+	}
+	return at(x0, y0)*(1-fx)*(1-fy) + at(x0+1, y0)*fx*(1-fy) + at(x0, y0+1)*(1-fx)*fy + at(x0+1, y0+1)*fx*fy
+}
+
+// fitLine returns the point and the direction of the line fitted to the points by least squares.
+func fitLine(points [][2]float64) ([4]float64, bool) {
+	if len(points) < 2 {
+		return [4]float64{}, false
+	}
+	var mx, my float64
+	for _, p := range points {
+		mx, my = mx+p[0], my+p[1]
+	}
+	mx, my = mx/float64(len(points)), my/float64(len(points))
+	var sxx, sxy, syy float64
+	for _, p := range points {
+		dx, dy := p[0]-mx, p[1]-my
+		sxx, sxy, syy = sxx+dx*dx, sxy+dx*dy, syy+dy*dy
+	}
+	// the principal axis of the points
+	angle := math.Atan2(2*sxy, sxx-syy) / 2
+	return [4]float64{mx, my, math.Cos(angle), math.Sin(angle)}, true
+}
+
+// intersect returns the crossing of the lines.
+func intersect(a, b [4]float64) ([2]float64, bool) {
+	det := a[2]*b[3] - a[3]*b[2]
+	if math.Abs(det) < 1e-9 {
+		return [2]float64{}, false
+	}
+	t := ((b[0]-a[0])*b[3] - (b[1]-a[1])*b[2]) / det
+	return [2]float64{a[0] + a[2]*t, a[1] + a[3]*t}, true
+}
+
+// readMarker samples the cells of the marker with the corners and decodes its id.
+func readMarker(img *image.Gray, corners [4][2]float64) (int, bool) {
+	grid, ok := homography([4][2]float64{{0, 0}, {markerCells, 0}, {markerCells, markerCells}, {0, markerCells}}, corners)
+	if !ok {
+		return 0, false
+	}
+	cell := math.Hypot(corners[1][0]-corners[0][0], corners[1][1]-corners[0][1]) / markerCells
+	radius := int(cell / 4)
+	var cells [markerCells][markerCells]float64
+	var borderSum, innerMax float64
+	for row := range cells {
+		for col := range cells[row] {
+			u, v := grid.project(float64(col)+0.5, float64(row)+0.5)
+			cells[row][col] = sample(img, int(u), int(v), radius)
+			if row == 0 || col == 0 || row == markerCells-1 || col == markerCells-1 {
+				borderSum += cells[row][col]
+			} else {
+				innerMax = math.Max(innerMax, cells[row][col])
+			}
+		}
+	}
+	borderMean := borderSum / (4 * (markerCells - 1))
+	if innerMax-borderMean < minContrast {
+		return 0, false
+	}
+	threshold := (borderMean + innerMax) / 2
+	id := 0
+	for row := range cells {
+		var bits uint8
+		for col := range cells[row] {
+			white := cells[row][col] > threshold
+			if row == 0 || col == 0 || row == markerCells-1 || col == markerCells-1 {
+				if white {
+					return 0, false
+				}
+				continue
+			}
+			bits <<= 1
+			if white {
+				bits |= 1
+			}
+		}
+		if row == 0 || row == markerCells-1 {
+			continue
+		}
+		code := -1
+		for i, r := range arucoRows {
+			if r == bits {
+				code = i
+			}
+		}
+		if code < 0 {
+			return 0, false
+		}
+		id = id<<2 | code
+	}
+	return id, true
+}
+
+// sample returns the mean brightness of the square around the pixel, or black outside the frame.
+func sample(img *image.Gray, x, y, radius int) float64 {
+	var sum, n float64
+	for sy := y - radius; sy <= y+radius; sy++ {
+		for sx := x - radius; sx <= x+radius; sx++ {
+			if sx < 0 || sy < 0 || sx >= img.Rect.Dx() || sy >= img.Rect.Dy() {
+				continue
+			}
+			sum += float64(img.Pix[sy*img.Stride+sx])
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
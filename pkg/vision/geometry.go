@@ -0,0 +1,87 @@
+package vision
+
+import (
+	"math"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// mat3 is a row-major 3x3 matrix.
+type mat3 [3][3]float64
+
+func (m mat3) mulVec(v vector.V3D) vector.V3D {
+	var r vector.V3D
+	for i := range m {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+func (m mat3) col(j int) vector.V3D {
+	return vector.V3D{m[0][j], m[1][j], m[2][j]}
+}
+
+// project maps the point of a plane with the homography.
+func (m mat3) project(x, y float64) (u, v float64) {
+	p := m.mulVec(vector.V3D{x, y, 1})
+	return p[0] / p[2], p[1] / p[2]
+}
+
+func dot(a, b vector.V3D) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b vector.V3D) vector.V3D {
+	return vector.V3D{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func norm(a vector.V3D) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+func normalize(a vector.V3D) vector.V3D {
+	return a.Scale(1 / norm(a))
+}
+
+// homography returns the matrix mapping the points src to dst, it fails if three of the points are on a line.
+func homography(src, dst [4][2]float64) (mat3, bool) {
+	// h33 is 1, the other 8 elements solve a linear system of 2 equations per point
+	var a [8][9]float64
+	for i := range src {
+		x, y, u, v := src[i][0], src[i][1], dst[i][0], dst[i][1]
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	// Gaussian elimination with partial pivoting
+	for c := 0; c < 8; c++ {
+		pivot := c
+		for r := c + 1; r < 8; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[pivot][c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][c]) < 1e-12 {
+			return mat3{}, false
+		}
+		a[c], a[pivot] = a[pivot], a[c]
+		for r := 0; r < 8; r++ {
+			if r == c {
+				continue
+			}
+			f := a[r][c] / a[c][c]
+			for k := c; k < 9; k++ {
+				a[r][k] -= f * a[c][k]
+			}
+		}
+	}
+	var h [9]float64
+	for i := 0; i < 8; i++ {
+		h[i] = a[i][8] / a[i][i]
+	}
+	h[8] = 1
+	return mat3{{h[0], h[1], h[2]}, {h[3], h[4], h[5]}, {h[6], h[7], h[8]}}, true
+}
@@ -0,0 +1,14 @@
+package vision
+
+import "github.com/einherij/pilot/pkg/videosender"
+
+// VideoSource fans out the video of the drone, e.g. videosender.Broadcaster.
+type VideoSource interface {
+	Subscribe(buffer int) *videosender.Subscription
+	Unsubscribe(s *videosender.Subscription)
+}
+
+// Corrector takes positions of the drone in the map, e.g. navigator.Navigator.
+type Corrector interface {
+	Correct(x, y, yaw float64)
+}
@@ -0,0 +1,100 @@
+package vision
+
+import (
+	"image"
+	"math"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// DefaultFOV is the horizontal field of view of the Tello's camera in the wide mode, degrees.
+const DefaultFOV = 70
+
+// Location of the drone in the map measured by a marker.
+type Location struct {
+	Marker   int     `json:"marker"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Z        float64 `json:"z"`
+	Yaw      float64 `json:"yaw"`      // degrees, heading of the drone
+	Distance float64 `json:"distance"` // from the camera to the marker
+}
+
+// Locator finds the drone in the map by the markers seen in frames of its camera.
+type Locator struct {
+	fov     float64
+	markers map[int]Marker
+}
+
+// NewLocator creates a locator of a camera with the horizontal field of view in degrees, frames must
+// keep the aspect ratio of the camera. Markers which aren't listed are ignored.
+func NewLocator(fov float64, markers []Marker) *Locator {
+	if fov <= 0 {
+		fov = DefaultFOV
+	}
+	l := &Locator{fov: fov, markers: make(map[int]Marker, len(markers))}
+	for _, m := range markers {
+		l.markers[m.ID] = m
+	}
+	return l
+}
+
+// Locate returns the location measured by the nearest known marker in the frame.
+func (l *Locator) Locate(frame *image.Gray) (Location, bool) {
+	var best Location
+	found := false
+	for _, d := range Detect(frame) {
+		marker, ok := l.markers[d.ID]
+		if !ok {
+			continue
+		}
+		loc, ok := l.locate(frame.Rect.Dx(), frame.Rect.Dy(), marker, d.Corners)
+		if ok && (!found || loc.Distance < best.Distance) {
+			best, found = loc, true
+		}
+	}
+	return best, found
+}
+
+// locate recovers the pose of the camera from the homography of the marker's plane to the frame,
+// H = K [r1 r2 t], where K is the camera matrix, r1 and r2 are the marker's axes in the camera frame
+// and t is its center.
+func (l *Locator) locate(width, height int, marker Marker, corners [4][2]float64) (Location, bool) {
+	h, ok := homography(marker.corners(), corners)
+	if !ok {
+		return Location{}, false
+	}
+	focal := float64(width) / 2 / math.Tan(l.fov*math.Pi/360)
+	cx, cy := float64(width)/2, float64(height)/2
+	invK := mat3{{1 / focal, 0, -cx / focal}, {0, 1 / focal, -cy / focal}, {0, 0, 1}}
+	a1, a2, a3 := invK.mulVec(h.col(0)), invK.mulVec(h.col(1)), invK.mulVec(h.col(2))
+	scale := 2 / (norm(a1) + norm(a2))
+	if a3[2] < 0 {
+		// the marker is in front of the camera
+		scale = -scale
+	}
+	r1, r2, t := a1.Scale(scale), a2.Scale(scale), a3.Scale(scale)
+	// the measured axes aren't exactly orthogonal
+	r1 = normalize(r1)
+	r3 := normalize(cross(r1, r2))
+	r2 = cross(r3, r1)
+
+	// the camera in the marker's frame: its center is -R^T t, its x axis is the first row of R
+	center := vector.V3D{-dot(r1, t), -dot(r2, t), -dot(r3, t)}
+	right := vector.V3D{r1[0], r2[0], r3[0]}
+	mx, my, mz := marker.axes()
+	toMap := func(v vector.V3D) vector.V3D {
+		return mx.Scale(v[0]).Add(my.Scale(v[1])).Add(mz.Scale(v[2]))
+	}
+	position := toMap(center).Add(vector.V3D{marker.X, marker.Y, marker.Z})
+	// the right of the camera is the right of the drone, which is level in flight
+	right = toMap(right)
+	return Location{
+		Marker:   marker.ID,
+		X:        position.X(),
+		Y:        position.Y(),
+		Z:        position.Z(),
+		Yaw:      math.Atan2(right.X(), -right.Y()) * 180 / math.Pi,
+		Distance: norm(t),
+	}, true
+}
@@ -0,0 +1,58 @@
+package vision
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+// Marker is a fiducial marker at a known position of the map.
+//
+// A floor marker lies with its top towards Yaw, a wall marker hangs upright facing Yaw.
+type Marker struct {
+	ID   int     `json:"id"`
+	X    float64 `json:"x"` // center of the marker in the map
+	Y    float64 `json:"y"`
+	Z    float64 `json:"z"`
+	Yaw  float64 `json:"yaw"`  // degrees
+	Size float64 `json:"size"` // side of the black square in units of the map
+	Wall bool    `json:"wall"`
+}
+
+// LoadMarkers reads the JSON array of markers.
+func LoadMarkers(path string) ([]Marker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading markers: %w", err)
+	}
+	var markers []Marker
+	if err = json.Unmarshal(data, &markers); err != nil {
+		return nil, fmt.Errorf("error decoding markers: %w", err)
+	}
+	for _, m := range markers {
+		if m.Size <= 0 {
+			return nil, fmt.Errorf("marker %d has no size", m.ID)
+		}
+	}
+	return markers, nil
+}
+
+// axes returns the axes of the marker in the map: x to the right of its picture, y to its top and z
+// out of the picture towards the viewer.
+func (m Marker) axes() (x, y, z vector.V3D) {
+	yaw := m.Yaw * math.Pi / 180
+	cos, sin := math.Cos(yaw), math.Sin(yaw)
+	if m.Wall {
+		return vector.V3D{-sin, cos, 0}, vector.V3D{0, 0, 1}, vector.V3D{cos, sin, 0}
+	}
+	return vector.V3D{sin, -cos, 0}, vector.V3D{cos, sin, 0}, vector.V3D{0, 0, 1}
+}
+
+// corners returns the corners of the marker in its own plane: top left, top right, bottom right and bottom left.
+func (m Marker) corners() [4][2]float64 {
+	h := m.Size / 2
+	return [4][2]float64{{-h, h}, {h, h}, {h, -h}, {-h, -h}}
+}
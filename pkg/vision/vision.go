@@ -0,0 +1,93 @@
+package vision
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPeriod is used when vision is created with non-positive period.
+	DefaultPeriod = time.Second
+	// restartDelay is the pause before the decoder is restarted after a failure
+	restartDelay = time.Second
+)
+
+// Vision corrects the position of the drone by markers at known positions of the map seen by its camera,
+// so positions measured by the drone don't drift between flights. The video is decoded by the decoder,
+// corrections are made at most once per period.
+type Vision struct {
+	video     VideoSource
+	corrector Corrector
+	decoder   Decoder
+	locator   *Locator
+	period    time.Duration
+
+	lastCorrection time.Time // accessed only from Run
+}
+
+func New(video VideoSource, corrector Corrector, decoder Decoder, locator *Locator, period time.Duration) *Vision {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	return &Vision{
+		video:     video,
+		corrector: corrector,
+		decoder:   decoder,
+		locator:   locator,
+		period:    period,
+	}
+}
+
+func (v *Vision) Run(ctx context.Context) {
+	logrus.Warnf("started vision")
+	for {
+		if err := v.decode(ctx); err != nil {
+			logrus.Error(fmt.Errorf("error decoding video for vision: %w", err))
+		}
+		select {
+		case <-time.After(restartDelay):
+		case <-ctx.Done():
+			logrus.Warnf("stopped vision")
+			return
+		}
+	}
+}
+
+// decode runs the decoder on a subscription to the video until it fails or the context is done.
+func (v *Vision) decode(ctx context.Context) error {
+	sub := v.video.Subscribe(0)
+	stream, writer := io.Pipe()
+	go func() {
+		for frame := range sub.Frames() {
+			if _, err := writer.Write(frame); err != nil {
+				return
+			}
+		}
+		_ = writer.Close()
+	}()
+	defer func() {
+		v.video.Unsubscribe(sub)
+		_ = stream.Close()
+	}()
+	return v.decoder.Decode(ctx, stream, func(frame *image.Gray) {
+		v.correct(frame, time.Now())
+	})
+}
+
+func (v *Vision) correct(frame *image.Gray, now time.Time) {
+	if now.Sub(v.lastCorrection) < v.period {
+		return
+	}
+	loc, ok := v.locator.Locate(frame)
+	if !ok {
+		return
+	}
+	v.lastCorrection = now
+	v.corrector.Correct(loc.X, loc.Y, loc.Yaw)
+	logrus.Debugf("position corrected by marker %d: %.2f %.2f yaw %.1f", loc.Marker, loc.X, loc.Y, loc.Yaw)
+}
@@ -0,0 +1,169 @@
+package vision
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/vector"
+)
+
+type VisionSuite struct {
+	suite.Suite
+}
+
+func TestVisionSuite(t *testing.T) {
+	suite.Run(t, new(VisionSuite))
+}
+
+// view is the camera of the drone at a pose, tilted down by tilt degrees.
+type view struct {
+	x, y, z, yaw, tilt float64
+}
+
+// render draws the marker the way the camera sees it on a grey background.
+func (s *VisionSuite) render(v view, m Marker) *image.Gray {
+	const width, height = DefaultWidth, DefaultHeight
+	focal := float64(width) / 2 / math.Tan(DefaultFOV*math.Pi/360)
+	yaw, tilt := v.yaw*math.Pi/180, v.tilt*math.Pi/180
+	forward := vector.V3D{math.Cos(yaw), math.Sin(yaw), 0}
+	left := vector.V3D{-math.Sin(yaw), math.Cos(yaw), 0}
+	up := vector.V3D{0, 0, 1}
+	camX := left.Scale(-1)
+	camY := up.Scale(math.Cos(tilt)).Add(forward.Scale(math.Sin(tilt))).Scale(-1)
+	camZ := forward.Scale(math.Cos(tilt)).Sub(up.Scale(math.Sin(tilt)))
+
+	mx, my, _ := m.axes()
+	var projected [4][2]float64
+	for i, c := range m.corners() {
+		p := vector.V3D{m.X, m.Y, m.Z}.Add(mx.Scale(c[0])).Add(my.Scale(c[1])).Sub(vector.V3D{v.x, v.y, v.z})
+		z := dot(p, camZ)
+		s.Require().Positive(z, "marker is behind the camera")
+		projected[i] = [2]float64{focal*dot(p, camX)/z + width/2, focal*dot(p, camY)/z + height/2}
+	}
+	toMarker, ok := homography(projected, m.corners())
+	s.Require().True(ok)
+
+	// pixels are supersampled, so edges are blurred like on a camera
+	const subpixels = 4
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			var sum int
+			for sy := 0; sy < subpixels; sy++ {
+				for sx := 0; sx < subpixels; sx++ {
+					x, y := toMarker.project(
+						float64(px)+(float64(sx)+0.5)/subpixels,
+						float64(py)+(float64(sy)+0.5)/subpixels,
+					)
+					sum += markerColor(m, x, y)
+				}
+			}
+			img.Pix[py*img.Stride+px] = uint8(sum / (subpixels * subpixels))
+		}
+	}
+	return img
+}
+
+// markerColor returns the brightness of the point of the marker's plane.
+func markerColor(m Marker, x, y float64) int {
+	col := int(math.Floor((x + m.Size/2) / m.Size * markerCells))
+	row := int(math.Floor((m.Size/2 - y) / m.Size * markerCells))
+	switch {
+	case col < 0 || row < 0 || col >= markerCells || row >= markerCells:
+		return 200
+	case markerBit(m.ID, row, col):
+		return 220
+	default:
+		return 30
+	}
+}
+
+// markerBit tells if the cell of the marker is white.
+func markerBit(id, row, col int) bool {
+	if row == 0 || col == 0 || row == markerCells-1 || col == markerCells-1 {
+		return false
+	}
+	code := arucoRows[(id>>(2*(markerCells-2-row)))&3]
+	return code>>(markerCells-2-col)&1 == 1
+}
+
+func (s *VisionSuite) TestLocate() {
+	for name, tc := range map[string]struct {
+		view   view
+		marker Marker
+	}{
+		"wall": {
+			view:   view{x: 1.5, y: 0.3, z: 1.1, yaw: 5},
+			marker: Marker{ID: 37, X: 3, Y: 0, Z: 1, Yaw: 180, Size: 0.3, Wall: true},
+		},
+		"floor": {
+			view:   view{x: 0.8, y: 0.9, z: 1.2, yaw: -100, tilt: 60},
+			marker: Marker{ID: 300, X: 0.6, Y: 0.2, Yaw: 30, Size: 0.2},
+		},
+	} {
+		s.Run(name, func() {
+			frame := s.render(tc.view, tc.marker)
+			detections := Detect(frame)
+			s.Require().Len(detections, 1)
+			s.Equal(tc.marker.ID, detections[0].ID)
+
+			loc, ok := NewLocator(0, []Marker{tc.marker}).Locate(frame)
+			s.Require().True(ok)
+			s.Equal(tc.marker.ID, loc.Marker)
+			s.InDelta(tc.view.x, loc.X, 0.03)
+			s.InDelta(tc.view.y, loc.Y, 0.03)
+			s.InDelta(tc.view.z, loc.Z, 0.03)
+			s.InDelta(tc.view.yaw, loc.Yaw, 1.5)
+
+			_, ok = NewLocator(0, []Marker{{ID: tc.marker.ID + 1, Size: 1}}).Locate(frame)
+			s.False(ok, "unknown markers are ignored")
+		})
+	}
+}
+
+type corrections []Location
+
+func (c *corrections) Correct(x, y, yaw float64) {
+	*c = append(*c, Location{X: x, Y: y, Yaw: yaw})
+}
+
+func (s *VisionSuite) TestCorrect() {
+	marker := Marker{ID: 5, X: 2, Y: 1, Z: 1, Yaw: 180, Size: 0.3, Wall: true}
+	frame := s.render(view{x: 0, y: 1, z: 1}, marker)
+	var c corrections
+	v := New(nil, &c, Decoder{}, NewLocator(0, []Marker{marker}), time.Second)
+
+	now := time.Now()
+	v.correct(frame, now)
+	v.correct(frame, now.Add(500*time.Millisecond))
+	s.Require().Len(c, 1, "corrections are made once per period")
+	s.InDelta(0, c[0].X, 0.03)
+	s.InDelta(1, c[0].Y, 0.03)
+	s.InDelta(0, c[0].Yaw, 1.5)
+	v.correct(frame, now.Add(time.Second))
+	s.Len(c, 2)
+}
+
+func (s *VisionSuite) TestDecode() {
+	// raw frames pass through cat the way ffmpeg outputs them
+	d := Decoder{Name: "cat", Width: 3, Height: 2}
+	var frames [][]byte
+	err := d.Decode(context.Background(), bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}), func(frame *image.Gray) {
+		s.Equal(image.Rect(0, 0, 3, 2), frame.Rect)
+		frames = append(frames, append([]byte(nil), frame.Pix...))
+	})
+	s.Require().NoError(err)
+	s.Equal([][]byte{{1, 2, 3, 4, 5, 6}, {7, 8, 9, 10, 11, 12}}, frames)
+
+	err = d.Decode(context.Background(), bytes.NewReader([]byte{1, 2, 3}), func(*image.Gray) {})
+	s.Error(err, "a part of a frame")
+
+	err = Decoder{Name: "false", Width: 1, Height: 1}.Decode(context.Background(), bytes.NewReader(nil), func(*image.Gray) {})
+	s.Error(err)
+}
@@ -0,0 +1,172 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// relay forwards messages between the pilot's websocket client and the browsers connected to the page.
+// Messages are relayed as is, so both sides speak the same wsclient.Message protocol.
+type relay struct {
+	upgrader websocket.Upgrader
+
+	mux   sync.Mutex
+	drone *peer
+	ui    map[*peer]struct{}
+}
+
+type peer struct {
+	writeMux sync.Mutex
+	conn     *websocket.Conn
+}
+
+func (p *peer) write(messageType int, data []byte) error {
+	p.writeMux.Lock()
+	defer p.writeMux.Unlock()
+
+	return p.conn.WriteMessage(messageType, data)
+}
+
+func newRelay() *relay {
+	return &relay{
+		ui: make(map[*peer]struct{}),
+	}
+}
+
+// serveDrone accepts the pilot's connection from this machine only, the latest one replaces the previous one,
+// so another host could take the pilot's place and receive commands of operators.
+func (r *relay) serveDrone(w http.ResponseWriter, req *http.Request) {
+	if !isLocal(req.RemoteAddr) {
+		logrus.Warnf("rejected pilot connection from %s", req.RemoteAddr)
+		http.Error(w, "pilot must connect from the same host", http.StatusForbidden)
+		return
+	}
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error upgrading drone connection: %w", err))
+		return
+	}
+	p := &peer{conn: conn}
+	r.mux.Lock()
+	if r.drone != nil {
+		_ = r.drone.conn.Close() // only the latest pilot connection is used
+	}
+	r.drone = p
+	r.mux.Unlock()
+	logrus.Warnf("pilot connected to web ui")
+
+	defer func() {
+		r.mux.Lock()
+		if r.drone == p {
+			r.drone = nil
+		}
+		r.mux.Unlock()
+		_ = conn.Close()
+		logrus.Warnf("pilot disconnected from web ui")
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		r.mux.Lock()
+		targets := make([]*peer, 0, len(r.ui))
+		for ui := range r.ui {
+			targets = append(targets, ui)
+		}
+		r.mux.Unlock()
+		for _, ui := range targets {
+			if err := ui.write(messageType, data); err != nil {
+				_ = ui.conn.Close()
+			}
+		}
+	}
+}
+
+// serveUI relays messages of the pages to the pilot except session events, they set roles of operators and
+// come from the handler server only. Anyone reaching the page is relayed, so the pilot lets them watch only.
+func (r *relay) serveUI(w http.ResponseWriter, req *http.Request) {
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error upgrading ui connection: %w", err))
+		return
+	}
+	p := &peer{conn: conn}
+	r.mux.Lock()
+	r.ui[p] = struct{}{}
+	r.mux.Unlock()
+
+	defer func() {
+		r.mux.Lock()
+		delete(r.ui, p)
+		r.mux.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg struct{ Type string }
+		if json.Unmarshal(data, &msg) == nil && msg.Type == "session" {
+			logrus.Warnf("dropped session event from web ui %s", req.RemoteAddr)
+			continue
+		}
+		r.mux.Lock()
+		drone := r.drone
+		r.mux.Unlock()
+		if drone == nil {
+			continue
+		}
+		if err := drone.write(messageType, data); err != nil {
+			logrus.Error(fmt.Errorf("error relaying message to pilot: %w", err))
+		}
+	}
+}
+
+func (r *relay) closeAll() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.drone != nil {
+		_ = r.drone.conn.Close()
+	}
+	for ui := range r.ui {
+		_ = ui.conn.Close()
+	}
+}
+
+// isLocal tells if the address belongs to this machine: it's a loopback one or an address of its interfaces,
+// e.g. when the web ui listens on a LAN address only.
+func isLocal(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logrus.Error(fmt.Errorf("error listing interface addresses: %w", err))
+		return false
+	}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
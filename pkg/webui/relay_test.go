@@ -0,0 +1,91 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+type RelaySuite struct {
+	suite.Suite
+
+	relay *relay
+	http  *httptest.Server
+}
+
+func TestRelaySuite(t *testing.T) {
+	suite.Run(t, new(RelaySuite))
+}
+
+func (s *RelaySuite) SetupTest() {
+	s.relay = newRelay()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drone/ws/", s.relay.serveDrone)
+	mux.HandleFunc("/ui/ws/", s.relay.serveUI)
+	s.http = httptest.NewServer(mux)
+}
+
+func (s *RelaySuite) TearDownTest() {
+	s.relay.closeAll()
+	s.http.Close()
+}
+
+func (s *RelaySuite) dial(path string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.http.URL, "http")+path, nil)
+	s.Require().NoError(err)
+	return conn
+}
+
+func (s *RelaySuite) read(conn *websocket.Conn) string {
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, data, err := conn.ReadMessage()
+	s.Require().NoError(err)
+	return string(data)
+}
+
+func (s *RelaySuite) TestForwarding() {
+	drone := s.dial("/drone/ws/")
+	first, second := s.dial("/ui/ws/"), s.dial("/ui/ws/")
+	s.Eventually(func() bool {
+		s.relay.mux.Lock()
+		defer s.relay.mux.Unlock()
+		return s.relay.drone != nil && len(s.relay.ui) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	s.Require().NoError(drone.WriteMessage(websocket.TextMessage, []byte(`{"Type":"telemetry"}`)))
+	s.Equal(`{"Type":"telemetry"}`, s.read(first), "every page gets messages of the pilot")
+	s.Equal(`{"Type":"telemetry"}`, s.read(second))
+
+	s.Require().NoError(second.WriteMessage(websocket.TextMessage, []byte(`{"Type":"cmd","Content":"RHU="}`)))
+	s.Equal(`{"Type":"cmd","Content":"RHU="}`, s.read(drone), "commands of pages go to the pilot")
+
+	s.Require().NoError(second.WriteMessage(websocket.TextMessage, []byte(`{"Type":"session","Payload":{"ID":"x","Role":"admin"}}`)))
+	s.Require().NoError(second.WriteMessage(websocket.TextMessage, []byte(`{"Type":"control"}`)))
+	s.Equal(`{"Type":"control"}`, s.read(drone), "pages can't set roles")
+
+	// a new pilot connection replaces the previous one
+	replacement := s.dial("/drone/ws/")
+	_ = drone.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := drone.ReadMessage()
+	s.Error(err)
+	s.Require().NoError(replacement.WriteMessage(websocket.TextMessage, []byte(`{"Type":"log"}`)))
+	s.Equal(`{"Type":"log"}`, s.read(first))
+}
+
+func (s *RelaySuite) TestRemotePilotRejected() {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/drone/ws/", nil)
+	r.RemoteAddr = "192.0.2.10:40000"
+	s.http.Config.Handler.ServeHTTP(w, r)
+	s.Equal(http.StatusForbidden, w.Code)
+
+	s.True(isLocal("127.0.0.1:40000"))
+	s.True(isLocal("[::1]:40000"))
+	s.False(isLocal("192.0.2.10:40000"))
+	s.False(isLocal("broken"))
+}
@@ -0,0 +1,125 @@
+package webui
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed static
+var static embed.FS
+
+const (
+	maxVideoFiles = 200      // limits the number of DASH segments kept in memory
+	maxPhotoFiles = 100      // photos and their sidecars
+	maxFileSize   = 16 << 20 // of an uploaded segment or photo
+)
+
+// Server is a minimal replacement of the handler server.
+// It serves the control page, accepts the pilot's websocket, the encoder's DASH uploads and photos,
+// so a single machine can fly the drone without deploying the separate server.
+type Server struct {
+	addr   string
+	relay  *relay
+	videos *fileStore
+	photos *fileStore
+	ui     http.Handler // serves the page's websocket instead of the relay if set
+
+	videoMode string       // how the page plays the video, see HandleVideo
+	live      http.Handler // live video stream, if any
+	metrics   http.Handler // Prometheus metrics, if any
+}
+
+func New(addr string) *Server {
+	return &Server{
+		addr:   addr,
+		relay:  newRelay(),
+		videos: newFileStore(maxVideoFiles),
+		photos: newFileStore(maxPhotoFiles),
+
+		videoMode: "dash",
+	}
+}
+
+// URL returns the base URL the pilot's components should use as a handler host.
+func (s *Server) URL() string {
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return "http://" + s.addr + "/"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// HandleUI makes the page's websocket served by h, e.g. by wsclient.Server when the pilot accepts UI connections itself.
+func (s *Server) HandleUI(h http.Handler) {
+	s.ui = h
+}
+
+// HandleVideo tells the page how to play the video: "dash" segments uploaded to the server (default),
+// "webrtc" negotiated over the page's websocket, or "h264"/"mjpeg" live stream served by live.
+func (s *Server) HandleVideo(mode string, live http.Handler) {
+	s.videoMode = mode
+	s.live = live
+}
+
+// HandleMetrics serves Prometheus metrics at /metrics, e.g. videosender.Stats.
+func (s *Server) HandleMetrics(metrics http.Handler) {
+	s.metrics = metrics
+}
+
+func (s *Server) Handler() http.Handler {
+	staticFS, _ := fs.Sub(static, "static")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(staticFS)))
+	mux.HandleFunc("/drone/ws/", s.relay.serveDrone)
+	if s.ui != nil {
+		mux.Handle("/ui/ws/", s.ui)
+	} else {
+		mux.HandleFunc("/ui/ws/", s.relay.serveUI)
+	}
+	mux.Handle("/drone/video/fs/", http.StripPrefix("/drone/video/fs/", s.videos))
+	mux.Handle("/drone/photos/", http.StripPrefix("/drone/photos/", s.photos))
+	mux.HandleFunc("/drone/video/mode", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(s.videoMode))
+	})
+	if s.live != nil {
+		mux.Handle("/drone/video/live", s.live)
+	}
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics)
+	}
+	return mux
+}
+
+func (s *Server) Run(ctx context.Context) {
+	logrus.Warnf("started web ui on %s", s.addr)
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Error(fmt.Errorf("error serving web ui: %w", err))
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.relay.closeAll()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logrus.Error(fmt.Errorf("error shutting down web ui: %w", err))
+	}
+	logrus.Warnf("stopped web ui")
+}
@@ -0,0 +1,663 @@
+'use strict';
+
+// Messages use the same format as wsclient.Message: {Type, Content}, Content is base64 encoded.
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+function encodeContent(text) {
+    let binary = '';
+    encoder.encode(text).forEach((b) => { binary += String.fromCharCode(b); });
+    return btoa(binary);
+}
+
+function decodeContent(content) {
+    if (!content) {
+        return '';
+    }
+    const binary = atob(content);
+    const bytes = new Uint8Array(binary.length);
+    for (let i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return decoder.decode(bytes);
+}
+
+// Connection
+
+let socket = null;
+
+async function connect() {
+    const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    let query = '';
+    auth = null;
+    const secret = document.getElementById('secret').value;
+    if (secret !== '') {
+        query = await handshake(secret) + '&op=' + encodeURIComponent(document.getElementById('operator').value);
+    }
+    socket = new WebSocket(scheme + '//' + location.host + '/ui/ws/' + query);
+    socket.onopen = () => {
+        setStatus(true);
+        // compact pose instead of OBJ position, see wsclient.Hello
+        socket.send(JSON.stringify({Type: 'hello', Payload: {Accept: ['fly_map', 'pose', 'telemetry', 'encoder', 'video_stats', 'video_settings']}}));
+        if (videoMode === 'webrtc') {
+            startWebRTC();
+        }
+    };
+    socket.onclose = () => {
+        setStatus(false);
+        setTimeout(connect, 1000);
+    };
+    socket.onmessage = (event) => handleMessage(JSON.parse(event.data));
+}
+
+// Messages are sent one by one so that signed ones keep the order of their sequence numbers.
+let sending = Promise.resolve();
+
+function send(type, text) {
+    sending = sending.then(() => sendNow(type, text)).catch((e) => console.log('send: ' + e));
+}
+
+// sendPayload sends JSON payload of compact messages, they are never signed.
+function sendPayload(type, payload) {
+    sending = sending.then(() => {
+        if (socket && socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify({Type: type, Payload: payload}));
+        }
+    });
+}
+
+async function sendNow(type, text) {
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    const msg = {Type: type, Content: encodeContent(text)};
+    if (auth !== null && signedTypes.has(type)) {
+        msg.Seq = ++auth.seq;
+        // the session is set by the pilot and there is no payload, see wsclient.Auth
+        msg.Sig = await hmac(auth.key, msg.Seq + '\n' + type + '\n\n' + encoder.encode(text).length + '\n' + text);
+    }
+    socket.send(JSON.stringify(msg));
+}
+
+// Authorization, see wsclient.Auth.
+
+const signedTypes = new Set(['cmd', 'control']);
+let auth = null;
+
+async function hmacKey(secret) {
+    const raw = typeof secret === 'string' ? encoder.encode(secret) : secret;
+    return crypto.subtle.importKey('raw', raw, {name: 'HMAC', hash: 'SHA-256'}, false, ['sign']);
+}
+
+async function hmacBytes(key, text) {
+    return new Uint8Array(await crypto.subtle.sign('HMAC', key, encoder.encode(text)));
+}
+
+async function hmac(key, text) {
+    return Array.from(await hmacBytes(key, text), (b) => b.toString(16).padStart(2, '0')).join('');
+}
+
+async function handshake(secret) {
+    const secretKey = await hmacKey(secret);
+    const nonce = Array.from(crypto.getRandomValues(new Uint8Array(16)), (b) => b.toString(16).padStart(2, '0')).join('');
+    const ts = String(Math.floor(Date.now() / 1000));
+    const sig = await hmac(secretKey, ts + '\n' + nonce);
+    auth = {key: await hmacKey(await hmacBytes(secretKey, 'session\n' + nonce)), seq: 0};
+    return '?ts=' + ts + '&nonce=' + nonce + '&sig=' + sig;
+}
+
+document.getElementById('secret').addEventListener('change', () => {
+    if (socket) {
+        socket.close();
+    }
+});
+
+function setStatus(online) {
+    const status = document.getElementById('status');
+    status.textContent = online ? 'online' : 'offline';
+    status.className = online ? 'online' : 'offline';
+}
+
+const handlers = {
+    fly_map: (text) => { flyMap = parseOBJ(text); drawMap(); },
+    pos: (text) => {
+        pos = parseOBJ(text);
+        if (pos.vertices.length > 0) {
+            track.push(pos.vertices[0]);
+            showTelemetry({position: pos.vertices[0].map((c) => c.toFixed(2)).join(' ')});
+        }
+        drawMap();
+    },
+    pose: (_, msg) => {
+        const p = msg.Payload;
+        pos = poseOBJ(p);
+        track.push(pos.vertices[0]);
+        showTelemetry({
+            position: [p.x, p.y, p.z].map((c) => c.toFixed(2)).join(' '),
+            yaw: p.yaw,
+            velocity: [p.vx, p.vy, p.vz].join(' '),
+            battery: p.battery + '%',
+        });
+        drawMap();
+    },
+    telemetry: (_, msg) => {
+        const t = msg.Payload;
+        const errors = Object.entries(t.errors).filter(([, set]) => set).map(([name]) => name);
+        if (t.battery.low) errors.push('batteryLow');
+        if (t.battery.critical) errors.push('batteryCritical');
+        showTelemetry({
+            height: t.height.toFixed(1) + ' m',
+            battery: t.battery.percentage + '% (' + t.battery.milliVolts + ' mV)',
+            state: (t.flying ? 'flying' : t.onGround ? 'on ground' : 'idle') + (t.hover ? ', hover' : '') +
+                ', mode ' + t.flyMode,
+            'flight time left': t.flyTimeLeft,
+            temperature: t.imu.temperature,
+            wifi: t.wifi.strength + ' (interference ' + t.wifi.interference + ')',
+            'mvo velocity': [t.mvo.vx, t.mvo.vy, t.mvo.vz].join(' '),
+            light: t.lightStrength,
+            errors: errors.length > 0 ? errors.join(', ') : 'none',
+        });
+    },
+    log: (text) => appendLog(text),
+    link: (text) => {
+        const link = JSON.parse(text);
+        showTelemetry({link: link.State + ', rtt ' + link.RTTMs.toFixed(0) + ' ms'});
+    },
+    session: (_, msg) => {
+        if (!msg.Payload.Left) {
+            session = msg.Payload;
+            showControl();
+        }
+    },
+    control: (text) => { controller = text; showControl(); },
+    webrtc: (_, msg) => answerWebRTC(msg.Payload),
+    encoder: (_, msg) => {
+        const h = msg.Payload;
+        showTelemetry({
+            encoder: (h.running ? 'running, ' + h.fps.toFixed(1) + ' fps' : 'stopped') +
+                ', restarts ' + h.restarts + ', dropped ' + h.dropped + (h.lastError ? ', ' + h.lastError : ''),
+        });
+    },
+    video_settings: (_, msg) => {
+        const v = msg.Payload;
+        // size and gop are set only when the stream is encoded
+        const encoded = v.width ? ', ' + v.width + 'x' + v.height + ', gop ' + v.gop : '';
+        showTelemetry({'video settings': v.camera + ', bitrate ' + v.bitrate + encoded});
+    },
+    video_stats: (_, msg) => {
+        const v = msg.Payload;
+        const health = document.getElementById('video-health');
+        health.textContent = v.health;
+        health.className = v.health;
+        showTelemetry({
+            video: (v.bitrate / 1000).toFixed(0) + ' kbit/s, ' + v.fps.toFixed(1) + ' fps, gaps ' + v.gaps +
+                ', stalls ' + v.stalls + (v.uploadLatencyMs !== undefined ? ', upload ' + v.uploadLatencyMs + ' ms' : ''),
+        });
+    },
+};
+
+// Control arbitration, used when the pilot accepts UI connections itself.
+
+let session = null;
+let controller = '';
+
+function showControl() {
+    let text = '';
+    if (session !== null) {
+        text = session.Role + ', ' + (controller === '' ? 'nobody has control' :
+            controller === session.ID ? 'you have control' : 'session ' + controller + ' has control');
+    }
+    document.getElementById('control').textContent = text;
+}
+
+document.getElementById('take').addEventListener('click', () => send('control', 'take'));
+document.getElementById('release').addEventListener('click', () => send('control', 'release'));
+document.getElementById('force').addEventListener('click', () => send('control', 'force'));
+
+function handleMessage(msg) {
+    const handler = handlers[msg.Type];
+    if (handler) {
+        handler(decodeContent(msg.Content), msg);
+    }
+}
+
+const telemetry = {};
+
+function showTelemetry(values) {
+    Object.assign(telemetry, values);
+    document.getElementById('telemetry').textContent = Object.entries(telemetry)
+        .map(([key, value]) => key + ': ' + value)
+        .join('\n');
+}
+
+function appendLog(text) {
+    const log = document.getElementById('log');
+    log.textContent += text + '\n';
+    log.scrollTop = log.scrollHeight;
+    document.getElementById('action').textContent = text;
+}
+
+// Keyboard controls, "D" + key on press and "U" + key on release.
+
+const keys = new Set('qeswadrfulhn0123456789'.split(''));
+
+// typing into inputs doesn't fly the drone
+function isControlKey(event) {
+    return keys.has(event.key) && !(event.target instanceof HTMLInputElement);
+}
+
+document.addEventListener('keydown', (event) => {
+    if (event.repeat || !isControlKey(event)) {
+        return;
+    }
+    send('cmd', 'D' + event.key);
+});
+
+document.addEventListener('keyup', (event) => {
+    if (!isControlKey(event)) {
+        return;
+    }
+    send('cmd', 'U' + event.key);
+});
+
+// Command buttons, e.g. recording, send the command in data-cmd attribute.
+
+document.querySelectorAll('#commands button').forEach((button) => {
+    button.addEventListener('click', () => send('cmd', button.dataset.cmd));
+});
+
+document.getElementById('command').addEventListener('keydown', (event) => {
+    const input = event.target;
+    if (event.key === 'Enter' && input.value.trim() !== '') {
+        send('cmd', input.value.trim());
+        input.value = '';
+    }
+});
+
+// Map
+
+let flyMap = {vertices: [], lines: [], faces: [], checkpoints: 0};
+let pos = {vertices: [], lines: [], faces: [], checkpoints: 0};
+const track = [];
+const maxTrack = 2000;
+
+// parseOBJ reads the first object as checkpoints and the following ones as obstacles, see flymap.WriteMap.
+function parseOBJ(text) {
+    const obj = {vertices: [], lines: [], faces: [], checkpoints: 0};
+    let objects = 0;
+    for (const line of text.split('\n')) {
+        const fields = line.trim().split(/\s+/);
+        switch (fields[0]) {
+            case 'o':
+                objects++;
+                break;
+            case 'v':
+                obj.vertices.push(fields.slice(1, 4).map(Number));
+                if (objects <= 1) {
+                    obj.checkpoints = obj.vertices.length;
+                }
+                break;
+            case 'l':
+                obj.lines.push(fields.slice(1, 3).map((i) => parseInt(i, 10) - 1));
+                break;
+            case 'f':
+                if (objects <= 1) {
+                    break; // e.g. the arrow of the position
+                }
+                obj.faces.push(fields.slice(1).map((i) => parseInt(i.split('/')[0], 10) - 1));
+                break;
+        }
+    }
+    return obj;
+}
+
+// poseOBJ builds the same arrow as navigator.Position.GetOBJ.
+function poseOBJ(p) {
+    const location = [p.x, p.y, p.z];
+    const rotate = (degrees) => {
+        const r = (p.yaw + degrees) * Math.PI / 180;
+        return [location[0] + Math.cos(r), location[1] + Math.sin(r), location[2]];
+    };
+    return {vertices: [location, rotate(0), rotate(-135), rotate(135)], lines: [[0, 1], [1, 2], [1, 3], [3, 0], [2, 0]], faces: [], checkpoints: 0};
+}
+
+// project mirrors vector.V3D.To2D perspective.
+function project(v) {
+    return [v[0] - 0.4 * v[2], v[1] + 0.3 * v[2]];
+}
+
+function drawMap() {
+    if (track.length > maxTrack) {
+        track.splice(0, track.length - maxTrack);
+    }
+    const canvas = document.getElementById('map');
+    const ctx = canvas.getContext('2d');
+    ctx.fillStyle = '#000';
+    ctx.fillRect(0, 0, canvas.width, canvas.height);
+
+    const points = flyMap.vertices.concat(pos.vertices, track).map(project);
+    if (points.length === 0) {
+        return;
+    }
+    let minX = Infinity, minY = Infinity, maxX = -Infinity, maxY = -Infinity;
+    for (const [x, y] of points) {
+        minX = Math.min(minX, x); maxX = Math.max(maxX, x);
+        minY = Math.min(minY, y); maxY = Math.max(maxY, y);
+    }
+    const margin = 20;
+    const scale = Math.min(
+        (canvas.width - 2 * margin) / Math.max(maxX - minX, 1),
+        (canvas.height - 2 * margin) / Math.max(maxY - minY, 1));
+    const toCanvas = (v) => {
+        const [x, y] = project(v);
+        return [margin + (x - minX) * scale, canvas.height - margin - (y - minY) * scale];
+    };
+
+    ctx.strokeStyle = '#555';
+    ctx.beginPath();
+    track.forEach((v, i) => {
+        const [x, y] = toCanvas(v);
+        i === 0 ? ctx.moveTo(x, y) : ctx.lineTo(x, y);
+    });
+    ctx.stroke();
+
+    drawOBJ(ctx, flyMap, toCanvas, '#2a6', true);
+    drawOBJ(ctx, pos, toCanvas, '#fc3', false);
+}
+
+function drawOBJ(ctx, obj, toCanvas, color, labels) {
+    // obstacles
+    ctx.strokeStyle = '#a33';
+    ctx.fillStyle = 'rgba(170, 51, 51, 0.2)';
+    for (const face of obj.faces) {
+        if (face.some((i) => !obj.vertices[i])) {
+            continue;
+        }
+        ctx.beginPath();
+        face.forEach((i, n) => {
+            const [x, y] = toCanvas(obj.vertices[i]);
+            n === 0 ? ctx.moveTo(x, y) : ctx.lineTo(x, y);
+        });
+        ctx.closePath();
+        ctx.fill();
+        ctx.stroke();
+    }
+    ctx.strokeStyle = color;
+    ctx.fillStyle = color;
+    for (const [from, to] of obj.lines) {
+        if (!obj.vertices[from] || !obj.vertices[to]) {
+            continue;
+        }
+        const [x1, y1] = toCanvas(obj.vertices[from]);
+        const [x2, y2] = toCanvas(obj.vertices[to]);
+        ctx.beginPath();
+        ctx.moveTo(x1, y1);
+        ctx.lineTo(x2, y2);
+        ctx.stroke();
+    }
+    if (labels) {
+        obj.vertices.slice(0, obj.checkpoints).forEach((v, i) => {
+            const [x, y] = toCanvas(v);
+            ctx.fillRect(x - 2, y - 2, 4, 4);
+            ctx.fillText(String(i + 1), x + 4, y - 4);
+        });
+    }
+}
+
+// Video, the pilot tells how to play it, see webui.Server.HandleVideo.
+
+let videoMode = 'dash';
+
+async function startVideo() {
+    try {
+        const resp = await fetch('/drone/video/mode', {cache: 'no-store'});
+        if (resp.ok) {
+            videoMode = (await resp.text()).trim();
+        }
+    } catch (e) {
+        console.log('video mode: ' + e);
+    }
+    switch (videoMode) {
+    case 'webrtc':
+        startWebRTC();
+        break;
+    case 'h264':
+        playH264();
+        break;
+    case 'mjpeg':
+        replaceVideo('img').src = '/drone/video/live';
+        break;
+    default:
+        playVideo();
+    }
+}
+
+// replaceVideo puts an element of another kind in place of the video element.
+function replaceVideo(tag) {
+    const video = document.getElementById('video');
+    const element = document.createElement(tag);
+    element.id = 'video';
+    video.replaceWith(element);
+    return element;
+}
+
+// WebRTC, the offer and the answer are sent with all ICE candidates, see videosender.Signal.
+
+let rtc = null;
+
+async function startWebRTC() {
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+        return; // started again when connected
+    }
+    if (rtc !== null) {
+        rtc.pc.close();
+    }
+    const pc = new RTCPeerConnection();
+    rtc = {pc, id: Math.random().toString(36).slice(2)};
+    pc.addTransceiver('video', {direction: 'recvonly'});
+    pc.ontrack = (event) => {
+        const video = document.getElementById('video');
+        video.srcObject = event.streams[0] || new MediaStream([event.track]);
+        video.play().catch(() => {});
+    };
+    pc.onconnectionstatechange = () => {
+        if (pc.connectionState === 'failed' && rtc !== null && rtc.pc === pc) {
+            setTimeout(startWebRTC, 1000);
+        }
+    };
+    await pc.setLocalDescription(await pc.createOffer());
+    await new Promise((resolve) => {
+        if (pc.iceGatheringState === 'complete') {
+            resolve();
+            return;
+        }
+        pc.addEventListener('icegatheringstatechange', () => {
+            if (pc.iceGatheringState === 'complete') {
+                resolve();
+            }
+        });
+    });
+    sendPayload('webrtc', {id: rtc.id, type: 'offer', sdp: pc.localDescription.sdp});
+}
+
+function answerWebRTC(signal) {
+    if (rtc === null || signal.id !== rtc.id || signal.type !== 'answer') {
+        return; // answer to another page
+    }
+    if (signal.error) {
+        console.log('webrtc: ' + signal.error);
+        return;
+    }
+    rtc.pc.setRemoteDescription({type: 'answer', sdp: signal.sdp}).catch((e) => console.log('webrtc: ' + e));
+}
+
+// Raw H.264 access units over websocket decoded with WebCodecs.
+
+function playH264() {
+    if (!window.VideoDecoder) {
+        console.log('h264: WebCodecs are not supported');
+        return;
+    }
+    const canvas = replaceVideo('canvas');
+    const ctx = canvas.getContext('2d');
+    const decoder = new VideoDecoder({
+        output: (frame) => {
+            canvas.width = frame.displayWidth;
+            canvas.height = frame.displayHeight;
+            ctx.drawImage(frame, 0, 0);
+            frame.close();
+        },
+        error: (e) => console.log('h264: ' + e),
+    });
+
+    const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const ws = new WebSocket(scheme + '//' + location.host + '/drone/video/live');
+    ws.binaryType = 'arraybuffer';
+    ws.onmessage = (event) => {
+        const data = new Uint8Array(event.data);
+        const units = nalUnits(data);
+        const key = units.some((nalu) => (nalu[0] & 0x1f) === 5);
+        if (decoder.state !== 'configured') {
+            const sps = units.find((nalu) => (nalu[0] & 0x1f) === 7);
+            if (!key || !sps) {
+                return;
+            }
+            const hex = (b) => b.toString(16).padStart(2, '0');
+            decoder.configure({codec: 'avc1.' + hex(sps[1]) + hex(sps[2]) + hex(sps[3]), optimizeForLatency: true});
+        }
+        decoder.decode(new EncodedVideoChunk({type: key ? 'key' : 'delta', timestamp: performance.now() * 1000, data}));
+    };
+    ws.onclose = () => {
+        decoder.close();
+        setTimeout(playH264, 1000);
+    };
+}
+
+// nalUnits splits an Annex B byte stream into NAL units.
+function nalUnits(data) {
+    const units = [];
+    let start = -1;
+    for (let i = 0; i + 2 < data.length; i++) {
+        if (data[i] === 0 && data[i + 1] === 0 && data[i + 2] === 1) {
+            if (start >= 0) {
+                units.push(data.subarray(start, i));
+            }
+            start = i + 3;
+            i += 2;
+        }
+    }
+    if (start >= 0) {
+        units.push(data.subarray(start));
+    }
+    return units;
+}
+
+// DASH, a tiny player for the manifest written by the pilot's packager or ffmpeg's dash muxer.
+
+const videoBase = '/drone/video/fs/';
+
+async function playVideo() {
+    const video = document.getElementById('video');
+    if (!window.MediaSource) {
+        return;
+    }
+    for (;;) {
+        try {
+            await playManifest(video);
+        } catch (e) {
+            console.log('video: ' + e);
+        }
+        await sleep(1000);
+    }
+}
+
+async function fetchManifest() {
+    const resp = await fetch(videoBase + 'feed', {cache: 'no-store'});
+    if (!resp.ok) {
+        throw new Error('no manifest');
+    }
+    const xml = new DOMParser().parseFromString(await resp.text(), 'application/xml');
+    const representation = xml.querySelector('Representation');
+    const template = xml.querySelector('SegmentTemplate');
+    if (!representation || !template) {
+        throw new Error('unsupported manifest');
+    }
+    const id = representation.getAttribute('id');
+    const start = parseInt(template.getAttribute('startNumber') || '1', 10);
+    let count = 0;
+    xml.querySelectorAll('SegmentTimeline S').forEach((s) => {
+        count += 1 + parseInt(s.getAttribute('r') || '0', 10);
+    });
+    return {
+        mime: (representation.getAttribute('mimeType') || template.parentNode.getAttribute('mimeType') || 'video/mp4') +
+            '; codecs="' + (representation.getAttribute('codecs') || 'avc1.42e01e') + '"',
+        init: fillTemplate(template.getAttribute('initialization'), id, 0),
+        media: (n) => fillTemplate(template.getAttribute('media'), id, n),
+        last: start + count - 1,
+    };
+}
+
+function fillTemplate(template, id, number) {
+    return template
+        .replace('$RepresentationID$', id)
+        .replace(/\$Number(%0(\d+)d)?\$/, (_, __, width) => String(number).padStart(parseInt(width || '0', 10), '0'));
+}
+
+async function playManifest(video) {
+    let manifest = await fetchManifest();
+    const mediaSource = new MediaSource();
+    video.src = URL.createObjectURL(mediaSource);
+    await new Promise((resolve) => mediaSource.addEventListener('sourceopen', resolve, {once: true}));
+    const buffer = mediaSource.addSourceBuffer(manifest.mime);
+    const init = manifest.init;
+    await append(buffer, await fetchSegment(init));
+
+    let next = manifest.last;
+    for (;;) {
+        const segment = await fetchSegment(manifest.media(next)).catch(() => null);
+        if (segment === null) {
+            await sleep(100);
+            manifest = await fetchManifest();
+            if (manifest.init !== init) {
+                throw new Error('stream parameters changed'); // e.g. camera mode, start with the new init segment
+            }
+            if (manifest.last > next + 10) {
+                next = manifest.last; // fell behind, jump to the live edge
+            }
+            continue;
+        }
+        await append(buffer, segment);
+        if (buffer.buffered.length > 0) {
+            const end = buffer.buffered.end(buffer.buffered.length - 1);
+            if (end - video.currentTime > 1) {
+                video.currentTime = end - 0.2;
+            }
+        }
+        video.play().catch(() => {});
+        next++;
+    }
+}
+
+async function fetchSegment(name) {
+    const resp = await fetch(videoBase + name, {cache: 'no-store'});
+    if (!resp.ok) {
+        throw new Error('segment ' + name + ' not found');
+    }
+    return resp.arrayBuffer();
+}
+
+function append(buffer, data) {
+    return new Promise((resolve, reject) => {
+        buffer.addEventListener('updateend', resolve, {once: true});
+        buffer.addEventListener('error', reject, {once: true});
+        buffer.appendBuffer(data);
+    });
+}
+
+function sleep(ms) {
+    return new Promise((resolve) => setTimeout(resolve, ms));
+}
+
+connect();
+drawMap();
+startVideo();
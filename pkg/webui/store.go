@@ -0,0 +1,106 @@
+package webui
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// fileStore keeps files uploaded by the pilot, e.g. DASH manifest and segments, in memory.
+type fileStore struct {
+	mux      sync.RWMutex
+	maxFiles int
+	files    map[string][]byte
+	order    []string // upload order, used to evict old segments
+}
+
+func newFileStore(maxFiles int) *fileStore {
+	return &fileStore{
+		maxFiles: maxFiles,
+		files:    make(map[string][]byte),
+	}
+}
+
+func (fs *fileStore) put(name string, data []byte) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if _, ok := fs.files[name]; !ok {
+		fs.order = append(fs.order, name)
+	}
+	fs.files[name] = data
+	for len(fs.order) > fs.maxFiles {
+		delete(fs.files, fs.order[0])
+		fs.order = fs.order[1:]
+	}
+}
+
+func (fs *fileStore) get(name string) ([]byte, bool) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	data, ok := fs.files[name]
+	return data, ok
+}
+
+func (fs *fileStore) delete(name string) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	delete(fs.files, name)
+	for i, n := range fs.order {
+		if n == name {
+			fs.order = append(fs.order[:i], fs.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// ServeHTTP accepts PUT/POST uploads and DELETE requests from ffmpeg's dash muxer and serves stored files on GET.
+// Files are uploaded by the pilot, so only this machine may change them.
+func (fs *fileStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !isLocal(r.RemoteAddr) {
+		http.Error(w, "files are uploaded from the same host only", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFileSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fs.put(name, data)
+	case http.MethodDelete:
+		fs.delete(name)
+	case http.MethodGet, http.MethodHead:
+		data, ok := fs.get(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType(name))
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".m4s", ".mp4":
+		return "video/mp4"
+	case "", ".mpd":
+		return "application/dash+xml"
+	case ".jpg":
+		return "image/jpeg"
+	case ".json":
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,62 @@
+package webui
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FileStoreSuite struct {
+	suite.Suite
+
+	store *fileStore
+}
+
+func TestFileStoreSuite(t *testing.T) {
+	suite.Run(t, new(FileStoreSuite))
+}
+
+func (s *FileStoreSuite) SetupTest() {
+	s.store = newFileStore(2)
+}
+
+func (s *FileStoreSuite) serve(method, name, remoteAddr string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/"+name, bytes.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	s.store.ServeHTTP(w, r)
+	return w
+}
+
+func (s *FileStoreSuite) TestUploads() {
+	const local, remote = "127.0.0.1:40000", "192.0.2.10:40000"
+
+	s.Equal(http.StatusOK, s.serve(http.MethodPut, "1.m4s", local, []byte("segment")).Code)
+	w := s.serve(http.MethodGet, "1.m4s", remote, nil)
+	s.Equal("segment", w.Body.String(), "anyone watches")
+	s.Equal("video/mp4", w.Header().Get("Content-Type"))
+
+	s.Equal(http.StatusForbidden, s.serve(http.MethodPut, "1.m4s", remote, []byte("fake")).Code)
+	s.Equal(http.StatusForbidden, s.serve(http.MethodDelete, "1.m4s", remote, nil).Code)
+	s.Equal("segment", s.serve(http.MethodGet, "1.m4s", remote, nil).Body.String())
+
+	s.Equal(http.StatusBadRequest, s.serve(http.MethodPost, "2.m4s", local, make([]byte, maxFileSize+1)).Code)
+	s.Equal(http.StatusNotFound, s.serve(http.MethodGet, "2.m4s", local, nil).Code)
+
+	s.Equal(http.StatusOK, s.serve(http.MethodDelete, "1.m4s", local, nil).Code)
+	s.Equal(http.StatusNotFound, s.serve(http.MethodGet, "1.m4s", local, nil).Code)
+}
+
+func (s *FileStoreSuite) TestEviction() {
+	for _, name := range []string{"1.m4s", "2.m4s", "3.m4s"} {
+		s.store.put(name, []byte(name))
+	}
+	_, ok := s.store.get("1.m4s")
+	s.False(ok, "the oldest file is evicted")
+	data, ok := s.store.get("3.m4s")
+	s.True(ok)
+	s.Equal("3.m4s", string(data))
+}
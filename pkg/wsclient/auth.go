@@ -0,0 +1,278 @@
+package wsclient
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/einherij/pilot/pkg/operator"
+)
+
+// Handshake headers, browsers can't set headers on websocket requests so the same values are accepted as query parameters.
+const (
+	HeaderOperator  = "X-Pilot-Operator"
+	HeaderTimestamp = "X-Pilot-Timestamp"
+	HeaderNonce     = "X-Pilot-Nonce"
+	HeaderSignature = "X-Pilot-Signature"
+)
+
+// handshakeWindow is the maximum clock difference accepted in a handshake, nonces are remembered for this time.
+const handshakeWindow = time.Minute
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotSigned    = errors.New("message isn't signed")
+	ErrBadSignature = errors.New("wrong message signature")
+	ErrReplayed     = errors.New("message sequence number is reused")
+	ErrNoSecret     = errors.New("shared secret isn't set")
+)
+
+// signedTypes are accepted only if signed by an authorized session. Session events set roles of operators,
+// so in client mode they must come from the handler server, in server mode clients can't send them at all.
+var signedTypes = map[MessageType]bool{
+	MTCmd:     true,
+	MTControl: true,
+	MTSession: true,
+}
+
+// Auth signs and verifies the websocket handshake and commands with a secret shared by the pilot and the server.
+// Operators connecting to the pilot in server mode may have their own secrets and roles, see Credential.
+//
+// The handshake signature is HMAC-SHA256(secret, timestamp + "\n" + nonce), timestamp is unix seconds.
+// Each connection gets a session key HMAC-SHA256(secret, "session\n" + nonce), commands are signed with it as
+// HMAC-SHA256(key, seq + "\n" + type + "\n" + session + "\n" + len(content) + "\n" + content + payload), where len is
+// in bytes, seq must grow within the session to prevent replays.
+type Auth struct {
+	secret      []byte
+	credentials map[string]Credential // by operator name
+
+	mux    sync.Mutex
+	nonces map[string]time.Time // nonces of accepted handshakes
+}
+
+// Credential of an operator, the operator's name is sent in the handshake.
+// The shared secret is the credential of the operator with empty name and admin role.
+type Credential struct {
+	Operator string
+	Secret   string
+	Role     operator.Role
+}
+
+// NewAuth returns nil if the secret is empty and there are no credentials, nil Auth accepts everything.
+func NewAuth(secret string, credentials ...Credential) *Auth {
+	if secret == "" && len(credentials) == 0 {
+		return nil
+	}
+	a := &Auth{
+		secret:      []byte(secret),
+		credentials: make(map[string]Credential),
+		nonces:      make(map[string]time.Time),
+	}
+	if secret != "" {
+		a.credentials[""] = Credential{Secret: secret, Role: operator.RoleAdmin}
+	}
+	for _, c := range credentials {
+		a.credentials[c.Operator] = c
+	}
+	return a
+}
+
+// ParseCredentials parses comma separated "name:role:secret" credentials.
+func ParseCredentials(s string) ([]Credential, error) {
+	var credentials []Credential
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(item), ":", 3)
+		if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("broken credential %q, expected name:role:secret", item)
+		}
+		role, err := operator.ParseRole(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, Credential{Operator: fields[0], Secret: fields[2], Role: role})
+	}
+	return credentials, nil
+}
+
+func (a *Auth) mac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for i, part := range parts {
+		if i > 0 {
+			h.Write([]byte("\n"))
+		}
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// CheckClient tells if the auth can sign connections to the handler server. Only the shared secret
+// signs them, credentials of operators are for server mode, and anyone could compute keys of an empty secret.
+func (a *Auth) CheckClient() error {
+	if a != nil && len(a.secret) == 0 {
+		return ErrNoSecret
+	}
+	return nil
+}
+
+// handshake returns the headers authorizing a new connection and the session of the connection.
+func (a *Auth) handshake() (http.Header, *session, error) {
+	if err := a.CheckClient(); err != nil {
+		return nil, nil, err
+	}
+	nonceBytes := make([]byte, 16)
+	_, _ = rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header := make(http.Header)
+	header.Set(HeaderTimestamp, timestamp)
+	header.Set(HeaderNonce, nonce)
+	header.Set(HeaderSignature, hex.EncodeToString(a.mac(a.secret, []byte(timestamp), []byte(nonce))))
+	return header, a.newSession(a.secret, nonce), nil
+}
+
+// verifyHandshake checks the request of a new connection and returns its session and the operator's credential.
+func (a *Auth) verifyHandshake(r *http.Request) (*session, Credential, error) {
+	get := func(header, param string) string {
+		if v := r.Header.Get(header); v != "" {
+			return v
+		}
+		return r.URL.Query().Get(param)
+	}
+	timestamp, nonce, signature := get(HeaderTimestamp, "ts"), get(HeaderNonce, "nonce"), get(HeaderSignature, "sig")
+
+	credential, ok := a.credentials[get(HeaderOperator, "op")]
+	if !ok {
+		return nil, Credential{}, fmt.Errorf("%w: unknown operator", ErrUnauthorized)
+	}
+	secret := []byte(credential.Secret)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, Credential{}, fmt.Errorf("%w: broken timestamp", ErrUnauthorized)
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > handshakeWindow || age < -handshakeWindow {
+		return nil, Credential{}, fmt.Errorf("%w: timestamp is out of window", ErrUnauthorized)
+	}
+	expected := a.mac(secret, []byte(timestamp), []byte(nonce))
+	if sig, err := hex.DecodeString(signature); err != nil || !hmac.Equal(sig, expected) {
+		return nil, Credential{}, fmt.Errorf("%w: wrong signature", ErrUnauthorized)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	now := time.Now()
+	for n, expires := range a.nonces {
+		if now.After(expires) {
+			delete(a.nonces, n)
+		}
+	}
+	if _, ok := a.nonces[nonce]; ok {
+		return nil, Credential{}, fmt.Errorf("%w: nonce is reused", ErrUnauthorized)
+	}
+	a.nonces[nonce] = now.Add(2 * handshakeWindow)
+	return a.newSession(secret, nonce), credential, nil
+}
+
+func (a *Auth) newSession(secret []byte, nonce string) *session {
+	return &session{
+		key: a.mac(secret, []byte("session"), []byte(nonce)),
+	}
+}
+
+// session signs and verifies messages of a single connection.
+type session struct {
+	key []byte
+
+	mux     sync.Mutex
+	seq     uint64 // last sequence number used to sign
+	lastSeq uint64 // last verified sequence number
+}
+
+func (s *session) signature(msg Message) []byte {
+	h := hmac.New(sha256.New, s.key)
+	// the session is signed too, so a signed command can't be passed off as one of another operator
+	_, _ = fmt.Fprintf(h, "%d\n%s\n%s\n%d\n", msg.Seq, msg.Type, msg.Session, len(msg.Content))
+	h.Write(msg.Content)
+	h.Write(msg.Payload)
+	return h.Sum(nil)
+}
+
+func (s *session) sign(msg Message) Message {
+	s.mux.Lock()
+	s.seq++
+	msg.Seq = s.seq
+	s.mux.Unlock()
+
+	msg.Sig = hex.EncodeToString(s.signature(msg))
+	return msg
+}
+
+// verify checks signature of the message if its type requires it.
+func (s *session) verify(msg Message) error {
+	if !signedTypes[msg.Type] {
+		return nil
+	}
+	if msg.Sig == "" {
+		return ErrNotSigned
+	}
+	sig, err := hex.DecodeString(msg.Sig)
+	if err != nil || !hmac.Equal(sig, s.signature(msg)) {
+		return ErrBadSignature
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if msg.Seq <= s.lastSeq {
+		return ErrReplayed
+	}
+	s.lastSeq = msg.Seq
+	return nil
+}
+
+// LoadTLSConfig builds TLS configuration from PEM files, empty paths are skipped.
+// For a client caFile verifies the server and cert/key is the client certificate,
+// for a server cert/key is the server certificate and caFile verifies client certificates.
+func LoadTLSConfig(caFile, certFile, keyFile string, server bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		if server {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
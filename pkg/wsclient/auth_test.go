@@ -0,0 +1,119 @@
+package wsclient
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/operator"
+)
+
+type AuthSuite struct {
+	suite.Suite
+}
+
+func TestAuthSuite(t *testing.T) {
+	suite.Run(t, new(AuthSuite))
+}
+
+func (s *AuthSuite) TestHandshake() {
+	auth := NewAuth("secret")
+	header, clientSession, err := auth.handshake()
+	s.Require().NoError(err)
+
+	r := httptest.NewRequest("GET", "/drone/ws/", nil)
+	r.Header = header
+	serverSession, credential, err := auth.verifyHandshake(r)
+	s.Require().NoError(err)
+	s.Equal(clientSession.key, serverSession.key)
+	s.Equal(operator.RoleAdmin, credential.Role)
+
+	_, _, err = auth.verifyHandshake(r)
+	s.ErrorIs(err, ErrUnauthorized, "nonce can't be reused")
+
+	header, _, _ = auth.handshake()
+	r = httptest.NewRequest("GET", "/drone/ws/", nil)
+	r.Header = header
+	_, _, err = NewAuth("other").verifyHandshake(r)
+	s.ErrorIs(err, ErrUnauthorized)
+
+	q := "?ts=" + header.Get(HeaderTimestamp) + "&nonce=" + header.Get(HeaderNonce) + "&sig=" + header.Get(HeaderSignature)
+	_, _, err = auth.verifyHandshake(httptest.NewRequest("GET", "/drone/ws/"+q, nil))
+	s.NoError(err, "query parameters are accepted")
+}
+
+func (s *AuthSuite) TestOperators() {
+	credentials, err := ParseCredentials("alice:pilot:one, bob:viewer:two")
+	s.Require().NoError(err)
+	auth := NewAuth("", credentials...)
+
+	header, _, _ := NewAuth("two").handshake()
+	header.Set(HeaderOperator, "bob")
+	r := httptest.NewRequest("GET", "/drone/ws/", nil)
+	r.Header = header
+	_, credential, err := auth.verifyHandshake(r)
+	s.Require().NoError(err)
+	s.Equal(operator.RoleViewer, credential.Role)
+
+	header, _, _ = NewAuth("two").handshake()
+	header.Set(HeaderOperator, "alice")
+	r.Header = header
+	_, _, err = auth.verifyHandshake(r)
+	s.ErrorIs(err, ErrUnauthorized, "secret of another operator")
+
+	// operators' credentials don't make the pilot sign its connections to the handler server
+	s.ErrorIs(auth.CheckClient(), ErrNoSecret)
+	_, _, err = auth.handshake()
+	s.ErrorIs(err, ErrNoSecret)
+	s.NoError(NewAuth("secret", credentials...).CheckClient())
+	s.NoError((*Auth)(nil).CheckClient())
+
+	_, err = ParseCredentials("alice:pilot")
+	s.Error(err)
+	_, err = ParseCredentials("alice:root:secret")
+	s.Error(err)
+}
+
+func (s *AuthSuite) TestSignedMessages() {
+	_, sess, _ := NewAuth("secret").handshake()
+
+	s.NoError(sess.verify(Message{Type: MTLog, Content: []byte("not signed")}))
+	s.ErrorIs(sess.verify(Message{Type: MTCmd, Content: []byte("Du")}), ErrNotSigned)
+
+	first := sess.sign(Message{Type: MTCmd, Content: []byte("Du")})
+	second := sess.sign(Message{Type: MTCmd, Content: []byte("Dl")})
+	s.NoError(sess.verify(first))
+	s.ErrorIs(sess.verify(first), ErrReplayed)
+
+	tampered := second
+	tampered.Content = []byte("Du")
+	s.ErrorIs(sess.verify(tampered), ErrBadSignature)
+	tampered = second
+	tampered.Session = "admin"
+	s.ErrorIs(sess.verify(tampered), ErrBadSignature, "session of another operator")
+	tampered = second
+	tampered.Payload = []byte(`{}`)
+	s.ErrorIs(sess.verify(tampered), ErrBadSignature)
+	s.NoError(sess.verify(second))
+
+	s.ErrorIs(sess.verify(Message{Type: MTSession, Payload: []byte(`{"ID":"x","Role":"admin"}`)}), ErrNotSigned,
+		"roles come from the handler server only")
+
+	_, other, _ := NewAuth("secret").handshake()
+	s.ErrorIs(other.verify(sess.sign(Message{Type: MTCmd})), ErrBadSignature)
+}
+
+func (s *AuthSuite) TestWebSocketURL() {
+	for serverURL, expected := range map[string]string{
+		"http://example.com/":       "ws://example.com/drone/ws/",
+		"https://example.com":       "wss://example.com/drone/ws/",
+		"https://example.com/pilot": "wss://example.com/pilot/drone/ws/",
+	} {
+		wsURL, err := webSocketURL(serverURL)
+		s.NoError(err)
+		s.Equal(expected, wsURL)
+	}
+	_, err := webSocketURL("ftp://example.com")
+	s.Error(err)
+}
@@ -0,0 +1,80 @@
+package wsclient
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Hello is exchanged right after connecting to negotiate optional message types.
+// The pilot offers the types it can send, the other side answers with the types it accepts.
+type Hello struct {
+	Offer  []MessageType `json:",omitempty"`
+	Accept []MessageType `json:",omitempty"`
+}
+
+// OptionalTypes are sent only to peers accepting them, other types are always sent.
+var OptionalTypes = []MessageType{MTFlyMap, MTPos, MTPose, MTTelemetry, MTEncoder, MTVideoStats, MTVideoSettings}
+
+// legacyTypes are accepted by peers that didn't send Hello.
+var legacyTypes = []MessageType{MTFlyMap, MTPos}
+
+func helloMessage(hello Hello) Message {
+	payload, _ := json.Marshal(hello)
+	return Message{Type: MTHello, Payload: payload}
+}
+
+// acceptSet tracks which optional message types the peer accepts.
+type acceptSet struct {
+	mux    sync.RWMutex
+	accept map[MessageType]bool
+}
+
+func newAcceptSet() *acceptSet {
+	a := new(acceptSet)
+	a.reset()
+	return a
+}
+
+// reset falls back to legacy types, e.g. after reconnect.
+func (a *acceptSet) reset() {
+	a.set(legacyTypes)
+}
+
+func (a *acceptSet) set(types []MessageType) {
+	accept := make(map[MessageType]bool, len(types))
+	for _, t := range types {
+		accept[t] = true
+	}
+	a.mux.Lock()
+	a.accept = accept
+	a.mux.Unlock()
+}
+
+// update applies peer's Hello, returns false if the message isn't a valid Hello.
+func (a *acceptSet) update(msg Message) bool {
+	var hello Hello
+	if err := json.Unmarshal(msg.Payload, &hello); err != nil {
+		return false
+	}
+	a.set(hello.Accept)
+	return true
+}
+
+func (a *acceptSet) accepts(t MessageType) bool {
+	if !isOptional(t) {
+		return true
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return a.accept[t]
+}
+
+func isOptional(t MessageType) bool {
+	for _, optional := range OptionalTypes {
+		if t == optional {
+			return true
+		}
+	}
+	return false
+}
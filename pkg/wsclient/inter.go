@@ -0,0 +1,11 @@
+package wsclient
+
+import "context"
+
+// Messenger is implemented by both the Client dialing the handler server and the Server accepting UI connections.
+type Messenger interface {
+	SendMessage(message Message)
+	ReceiveMessage(ctx context.Context) Message
+	// Accepts reports whether the other side negotiated receiving messages of the type, see Hello.
+	Accepts(t MessageType) bool
+}
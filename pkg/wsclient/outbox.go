@@ -0,0 +1,144 @@
+package wsclient
+
+import (
+	"sync"
+)
+
+// Policy defines how outgoing messages of a type are queued while the connection can't keep up.
+type Policy int
+
+const (
+	// PolicyBuffered keeps up to bufferSize messages, dropping the oldest ones.
+	PolicyBuffered Policy = iota
+	// PolicyLatest keeps only the latest message, e.g. position updates where old values are useless.
+	PolicyLatest
+	// PolicyGuaranteed never drops messages, they are resent after reconnect if writing failed.
+	PolicyGuaranteed
+)
+
+const bufferSize = 100
+
+// Policies is the queueing policy per message type, types not listed use PolicyBuffered.
+var Policies = map[MessageType]Policy{
+	MTFlyMap: PolicyLatest,
+	MTPos:    PolicyLatest,
+	MTLink:   PolicyLatest,
+	MTAck:    PolicyGuaranteed,
+	MTLog:    PolicyBuffered,
+}
+
+// OutboxStats are counters of the outgoing queue exposed for diagnostics.
+type OutboxStats struct {
+	Queued  int
+	Sent    map[MessageType]uint64
+	Dropped map[MessageType]uint64
+}
+
+// outbox is a non-blocking queue of outgoing messages. Guaranteed messages are sent first,
+// then the latest values, then buffered messages.
+type outbox struct {
+	mux        sync.Mutex
+	ready      chan struct{}
+	guaranteed []Message
+	latest     map[MessageType]Message
+	latestKeys []MessageType // order in which latest values were queued
+	buffered   []Message
+	sent       map[MessageType]uint64
+	dropped    map[MessageType]uint64
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		ready:   make(chan struct{}, 1),
+		latest:  make(map[MessageType]Message),
+		sent:    make(map[MessageType]uint64),
+		dropped: make(map[MessageType]uint64),
+	}
+}
+
+func (o *outbox) push(msg Message) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	switch Policies[msg.Type] {
+	case PolicyGuaranteed:
+		o.guaranteed = append(o.guaranteed, msg)
+	case PolicyLatest:
+		if _, ok := o.latest[msg.Type]; ok {
+			o.dropped[msg.Type]++
+		} else {
+			o.latestKeys = append(o.latestKeys, msg.Type)
+		}
+		o.latest[msg.Type] = msg
+	default:
+		if len(o.buffered) >= bufferSize {
+			o.dropped[o.buffered[0].Type]++
+			o.buffered = o.buffered[1:]
+		}
+		o.buffered = append(o.buffered, msg)
+	}
+	o.notify()
+}
+
+// requeue puts back a message that failed to be written, only guaranteed messages are kept.
+func (o *outbox) requeue(msg Message) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if Policies[msg.Type] != PolicyGuaranteed {
+		o.dropped[msg.Type]++
+		return
+	}
+	o.sent[msg.Type]--
+	o.guaranteed = append([]Message{msg}, o.guaranteed...)
+	o.notify()
+}
+
+// pop returns the next message to send.
+func (o *outbox) pop() (Message, bool) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	var msg Message
+	switch {
+	case len(o.guaranteed) > 0:
+		msg, o.guaranteed = o.guaranteed[0], o.guaranteed[1:]
+	case len(o.latestKeys) > 0:
+		var t MessageType
+		t, o.latestKeys = o.latestKeys[0], o.latestKeys[1:]
+		msg = o.latest[t]
+		delete(o.latest, t)
+	case len(o.buffered) > 0:
+		msg, o.buffered = o.buffered[0], o.buffered[1:]
+	default:
+		return Message{}, false
+	}
+	o.sent[msg.Type]++
+	return msg, true
+}
+
+// notify wakes up the writer, must be called with o.mux held.
+func (o *outbox) notify() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) stats() OutboxStats {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	stats := OutboxStats{
+		Queued:  len(o.guaranteed) + len(o.latest) + len(o.buffered),
+		Sent:    make(map[MessageType]uint64, len(o.sent)),
+		Dropped: make(map[MessageType]uint64, len(o.dropped)),
+	}
+	for t, n := range o.sent {
+		stats.Sent[t] = n
+	}
+	for t, n := range o.dropped {
+		stats.Dropped[t] = n
+	}
+	return stats
+}
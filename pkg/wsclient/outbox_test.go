@@ -0,0 +1,65 @@
+package wsclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OutboxSuite struct {
+	suite.Suite
+}
+
+func TestOutboxSuite(t *testing.T) {
+	suite.Run(t, new(OutboxSuite))
+}
+
+func (s *OutboxSuite) popAll(o *outbox) (messages []string) {
+	for {
+		msg, ok := o.pop()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, string(msg.Type)+":"+string(msg.Content))
+	}
+}
+
+func (s *OutboxSuite) TestPolicies() {
+	o := newOutbox()
+	o.push(Message{Type: MTLog, Content: []byte("1")})
+	o.push(Message{Type: MTPos, Content: []byte("1")})
+	o.push(Message{Type: MTPos, Content: []byte("2")})
+	o.push(Message{Type: MTAck, Content: []byte("Du")})
+	o.push(Message{Type: MTLog, Content: []byte("2")})
+
+	s.Equal([]string{"ack:Du", "pos:2", "log:1", "log:2"}, s.popAll(o))
+	stats := o.stats()
+	s.Equal(uint64(1), stats.Dropped[MTPos])
+	s.Equal(uint64(2), stats.Sent[MTLog])
+	s.Equal(0, stats.Queued)
+}
+
+func (s *OutboxSuite) TestBufferedLimit() {
+	o := newOutbox()
+	for i := 0; i < bufferSize+5; i++ {
+		o.push(Message{Type: MTLog})
+	}
+	s.Len(s.popAll(o), bufferSize)
+	s.Equal(uint64(5), o.stats().Dropped[MTLog])
+}
+
+func (s *OutboxSuite) TestRequeue() {
+	o := newOutbox()
+	o.push(Message{Type: MTAck, Content: []byte("Du")})
+	o.push(Message{Type: MTAck, Content: []byte("Dl")})
+	o.push(Message{Type: MTPos})
+
+	msg, _ := o.pop()
+	o.requeue(msg)
+	msg, _ = o.pop()
+	o.requeue(Message{Type: MTPos})
+
+	s.Equal("Du", string(msg.Content))
+	s.Equal([]string{"ack:Dl", "pos:"}, s.popAll(o))
+	s.Equal(uint64(1), o.stats().Dropped[MTPos])
+}
@@ -2,45 +2,119 @@ package wsclient
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/operator"
 )
 
 type Client struct {
 	serverURL   string
-	sendChan    chan interface{}
+	auth        *Auth
+	dialer      *websocket.Dialer
+	outbox      *outbox
+	accept      *acceptSet
 	receiveChan chan interface{}
+	status      atomic.Pointer[Status]
 }
 
 type MessageType string
 
 const (
-	MTUndefined = ""
-	MTFlyMap    = "fly_map"
-	MTPos       = "pos"
-	MTLog       = "log"
-	MTCmd       = "cmd"
+	MTUndefined     = ""
+	MTFlyMap        = "fly_map"
+	MTPos           = "pos"
+	MTLog           = "log"
+	MTCmd           = "cmd"
+	MTAck           = "ack"            // acknowledgement of an executed command, content is the command
+	MTSession       = "session"        // operator.Event of a session joining or leaving
+	MTControl       = "control"        // "take"/"force"/"release" requests from sessions, id of the controlling session to them
+	MTLink          = "link"           // JSON encoded Status of the connection to the handler server
+	MTHello         = "hello"          // Hello negotiating optional message types
+	MTPose          = "pose"           // compact navigator.Pose, sent instead of OBJ position to peers accepting it
+	MTTelemetry     = "telemetry"      // telemetry.Snapshot of the flight data
+	MTWebRTC        = "webrtc"         // videosender.Signal negotiating a WebRTC video connection
+	MTEncoder       = "encoder"        // videosender.Health of the external video encoder
+	MTVideoStats    = "video_stats"    // videosender.StatsSnapshot of the video path
+	MTVideoSettings = "video_settings" // active videosender.Settings
 )
 
+// Message is sent as JSON. Content is base64 encoded by encoding/json,
+// so compact messages put their JSON encoded data into Payload instead.
 type Message struct {
 	Type    MessageType
-	Content []byte
+	Content []byte          `json:",omitempty"`
+	Payload json.RawMessage `json:",omitempty"`
+	Session string          `json:",omitempty"` // operator session the message comes from or is addressed to
+	Seq     uint64          `json:",omitempty"` // sequence number of a signed message, see Auth
+	Sig     string          `json:",omitempty"` // signature of a signed message, see Auth
+}
+
+type ConnState string
+
+const (
+	StateConnecting   ConnState = "connecting"
+	StateConnected    ConnState = "connected"
+	StateReconnecting ConnState = "reconnecting"
+	StateStopped      ConnState = "stopped"
+)
+
+// Status describes the connection to the handler server.
+type Status struct {
+	State ConnState
+	Since time.Time     // time of the last state change
+	RTT   time.Duration // round trip time measured by the last ping
 }
 
-func New(serverURL string) *Client {
-	return &Client{
-		serverURL:   serverURL,
-		sendChan:    make(chan interface{}, 1),
+const (
+	writeWait  = 2 * time.Second // time allowed to write a message
+	pingPeriod = 2 * time.Second // interval of pings sent to the server
+	pongWait   = 3 * pingPeriod  // connection is considered dead if nothing was read for this time
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// New creates a client of the handler server. With non-nil auth the handshake is signed and
+// only signed commands are accepted, tlsConfig is used for wss connections and may be nil.
+func New(serverURL string, auth *Auth, tlsConfig *tls.Config) *Client {
+	c := &Client{
+		serverURL: serverURL,
+		auth:      auth,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:  tlsConfig,
+		},
+		outbox:      newOutbox(),
+		accept:      newAcceptSet(),
 		receiveChan: make(chan interface{}, 1),
 	}
+	c.status.Store(&Status{State: StateConnecting, Since: time.Now()})
+	return c
 }
 
+// SendMessage queues the message according to its type's Policy, it never blocks.
+// Optional messages not accepted by the server are dropped.
 func (c *Client) SendMessage(message Message) {
-	c.sendChan <- message
+	if !c.accept.accepts(message.Type) {
+		return
+	}
+	c.outbox.push(message)
+}
+
+// Accepts reports whether the server accepts messages of the type.
+func (c *Client) Accepts(t MessageType) bool {
+	return c.accept.accepts(t)
 }
 
 func (c *Client) ReceiveMessage(ctx context.Context) Message {
@@ -56,66 +130,239 @@ func (c *Client) ReceiveMessage(ctx context.Context) Message {
 	}
 }
 
+// Status returns the current state of the connection to the handler server.
+func (c *Client) Status() Status {
+	return *(c.status.Load())
+}
+
+// Stats returns counters of the outgoing queue.
+func (c *Client) Stats() OutboxStats {
+	return c.outbox.stats()
+}
+
+func (c *Client) setState(state ConnState) {
+	status := *(c.status.Load())
+	if status.State == state {
+		return
+	}
+	status.State = state
+	status.Since = time.Now()
+	c.status.Store(&status)
+	logrus.Warnf("websocket client %s", state)
+}
+
+func (c *Client) setRTT(rtt time.Duration) {
+	status := *(c.status.Load())
+	status.RTT = rtt
+	c.status.Store(&status)
+}
+
+// Run keeps a single live connection to the server, reconnecting with exponential backoff when it dies.
 func (c *Client) Run(ctx context.Context) {
 	logrus.Warnf("started websocket client")
-	const reconnectInterval = 5 * time.Second
-	timer := time.NewTimer(0)
-	wsURL := "ws" + strings.TrimPrefix(c.serverURL, "http") + "drone/ws/"
+	wsURL, err := webSocketURL(c.serverURL)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error building web socket url: %w", err))
+		<-ctx.Done()
+		return
+	}
+	if err := c.auth.CheckClient(); err != nil {
+		logrus.Error(fmt.Errorf("error signing connections to server: %w", err))
+		<-ctx.Done()
+		return
+	}
+	backoff := minBackoff
 	for {
-		select {
-		case <-ctx.Done():
-			timer.Stop()
+		var (
+			header http.Header
+			sess   *session
+		)
+		if c.auth != nil {
+			header, sess, _ = c.auth.handshake()
+		}
+		conn, _, err := c.dialer.DialContext(ctx, wsURL, header)
+		if err == nil {
+			backoff = minBackoff
+			c.setState(StateConnected)
+			// sessions of the previous connection are gone
+			select {
+			case c.receiveChan <- sessionMessage(operator.Event{Reset: true}):
+			case <-ctx.Done():
+			}
+			c.serve(ctx, conn, sess)
+		} else if ctx.Err() == nil {
+			logrus.Error(fmt.Errorf("error connecting to server's web socket: %w", err))
+		}
+		if ctx.Err() != nil {
+			c.setState(StateStopped)
 			logrus.Warnf("stopped websocket client")
 			return
-		case <-timer.C:
-			func() {
-				conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
-				if err != nil {
-					logrus.Error(fmt.Errorf("error connecting to server's web socket: %w", err))
-					return
-				}
-				defer func() { _ = conn.Close() }()
-
-				go c.receiveMessages(ctx, conn)
-				go c.sendMessages(ctx, conn)
-			}()
-			timer.Reset(reconnectInterval)
 		}
+		c.setState(StateReconnecting)
+
+		// full jitter keeps many pilots from reconnecting to the server at once
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// webSocketURL converts the http(s) URL of the handler server to the ws(s) URL of its drone endpoint.
+func webSocketURL(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/drone/ws/"
+	return u.String(), nil
+}
+
+// serve exchanges messages over the connection until it dies or ctx is done.
+// Commands not signed by the session are rejected if the session isn't nil.
+func (c *Client) serve(ctx context.Context, conn *websocket.Conn, sess *session) {
+	connCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		_ = conn.Close()
+		<-done
+	}()
+
+	c.accept.reset()
+	if err := c.writeMessage(conn, helloMessage(Hello{Offer: OptionalTypes})); err != nil {
+		logrus.Error(fmt.Errorf("error writing message to web socket: %w", err))
+		return
 	}
 
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(appData string) error {
+		if sent, err := time.Parse(time.RFC3339Nano, appData); err == nil {
+			c.setRTT(time.Since(sent))
+		}
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go func() {
+		defer close(done)
+		c.sendMessages(connCtx, conn)
+	}()
+	c.receiveMessages(connCtx, conn, sess)
 }
 
-func (c *Client) receiveMessages(ctx context.Context, conn *websocket.Conn) {
+func (c *Client) receiveMessages(ctx context.Context, conn *websocket.Conn, sess *session) {
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			var msg Message
-			if err := conn.ReadJSON(&msg); err != nil {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() == nil {
 				logrus.Error(fmt.Errorf("error reading message from web socket: %w", err))
-				return
 			}
-			select {
-			case c.receiveChan <- msg:
-			case <-time.After(200 * time.Millisecond):
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		if sess != nil {
+			if err := sess.verify(msg); err != nil {
+				logrus.Warnf("rejected %q message: %s", msg.Type, err)
 				continue
 			}
-
+		}
+		if msg.Type == MTHello {
+			if !c.accept.update(msg) {
+				logrus.Warnf("broken hello message")
+			}
+			continue
+		}
+		select {
+		case c.receiveChan <- msg:
+		case <-ctx.Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+			continue
 		}
 	}
 }
 
 func (c *Client) sendMessages(ctx context.Context, conn *websocket.Conn) {
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+	if err := c.flush(conn); err != nil {
+		logrus.Error(fmt.Errorf("error writing message to web socket: %w", err))
+		_ = conn.Close()
+		return
+	}
 	for {
 		select {
 		case <-ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
 			return
-		case msg := <-c.sendChan:
-			if err := conn.WriteJSON(msg); err != nil {
+		case <-pingTicker.C:
+			ping := []byte(time.Now().Format(time.RFC3339Nano))
+			if err := conn.WriteControl(websocket.PingMessage, ping, time.Now().Add(writeWait)); err != nil {
+				logrus.Error(fmt.Errorf("error pinging web socket: %w", err))
+				_ = conn.Close()
+				return
+			}
+			if err := c.writeMessage(conn, c.linkMessage()); err != nil {
 				logrus.Error(fmt.Errorf("error writing message to web socket: %w", err))
+				_ = conn.Close()
+				return
+			}
+		case <-c.outbox.ready:
+			if err := c.flush(conn); err != nil {
+				logrus.Error(fmt.Errorf("error writing message to web socket: %w", err))
+				_ = conn.Close()
 				return
 			}
 		}
 	}
 }
+
+// flush writes all queued messages, a message that failed to be written is requeued.
+func (c *Client) flush(conn *websocket.Conn) error {
+	for {
+		msg, ok := c.outbox.pop()
+		if !ok {
+			return nil
+		}
+		if err := c.writeMessage(conn, msg); err != nil {
+			c.outbox.requeue(msg)
+			return err
+		}
+	}
+}
+
+func (c *Client) writeMessage(conn *websocket.Conn, msg interface{}) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteJSON(msg)
+}
+
+// linkMessage reports the connection status to the other side, so the UI can show the link quality.
+func (c *Client) linkMessage() Message {
+	status := c.Status()
+	content, _ := json.Marshal(struct {
+		State   ConnState
+		Since   time.Time
+		RTTMs   float64
+		Dropped map[MessageType]uint64
+	}{
+		State:   status.State,
+		Since:   status.Since,
+		RTTMs:   float64(status.RTT) / float64(time.Millisecond),
+		Dropped: c.outbox.stats().Dropped,
+	})
+	return Message{Type: MTLink, Content: content}
+}
@@ -0,0 +1,56 @@
+package wsclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientSuite struct {
+	suite.Suite
+}
+
+func TestClientSuite(t *testing.T) {
+	suite.Run(t, new(ClientSuite))
+}
+
+func (s *ClientSuite) TestReconnect() {
+	var (
+		upgrader    websocket.Upgrader
+		connections atomic.Int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		s.Require().NoError(err)
+		defer func() { _ = conn.Close() }()
+		if connections.Add(1) == 1 {
+			return // drop the first connection
+		}
+		s.NoError(conn.WriteJSON(Message{Type: MTCmd, Content: []byte("Du")}))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := New(srv.URL+"/", nil, nil)
+	go client.Run(ctx)
+
+	msg := client.ReceiveMessage(ctx)
+	for msg.Type == MTSession {
+		msg = client.ReceiveMessage(ctx)
+	}
+	s.Equal("Du", string(msg.Content))
+	s.Equal(int32(2), connections.Load())
+	s.Equal(StateConnected, client.Status().State)
+}
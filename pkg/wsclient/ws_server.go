@@ -0,0 +1,314 @@
+package wsclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/einherij/pilot/pkg/operator"
+)
+
+// Server accepts websocket connections from UI clients instead of dialing the handler server.
+// Outgoing messages are sent to every connected client or to the one their Session addresses.
+// Received messages get Session of the client, joining and leaving clients are announced
+// with MTSession messages carrying operator.Event, so the controller can arbitrate control.
+type Server struct {
+	addr        string
+	auth        *Auth
+	tlsConfig   *tls.Config
+	upgrader    websocket.Upgrader
+	receiveChan chan interface{}
+	pingPeriod  time.Duration // clients are dead if they don't answer pings for 3 periods, see pongWait
+
+	mux     sync.Mutex
+	lastID  int
+	clients map[*serverConn]struct{}
+}
+
+type serverConn struct {
+	id      string
+	role    operator.Role
+	conn    *websocket.Conn
+	session *session // nil if authorization is disabled
+	outbox  *outbox
+	accept  *acceptSet
+	done    chan struct{}
+}
+
+// NewServer creates a websocket server listening on addr.
+// If addr is empty the server doesn't listen on its own and is expected to be mounted as http.Handler.
+// With non-nil auth only clients with signed handshake are accepted and their commands must be signed,
+// tlsConfig makes the server listen for wss connections.
+func NewServer(addr string, auth *Auth, tlsConfig *tls.Config) *Server {
+	return &Server{
+		addr:        addr,
+		auth:        auth,
+		tlsConfig:   tlsConfig,
+		receiveChan: make(chan interface{}, 1),
+		pingPeriod:  pingPeriod,
+		clients:     make(map[*serverConn]struct{}),
+	}
+}
+
+func (s *Server) SendMessage(message Message) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for c := range s.clients {
+		if message.Session == "" || message.Session == c.id {
+			s.sendTo(c, message)
+		}
+	}
+}
+
+func (s *Server) ReceiveMessage(ctx context.Context) Message {
+	select {
+	case <-ctx.Done():
+		return Message{}
+	case msgInterface := <-s.receiveChan:
+		msg, ok := (msgInterface).(Message)
+		if !ok {
+			return Message{}
+		}
+		return msg
+	}
+}
+
+func (s *Server) Run(ctx context.Context) {
+	logrus.Warnf("started websocket server")
+	var srv *http.Server
+	if s.addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/drone/ws/", s)
+		srv = &http.Server{Addr: s.addr, Handler: mux, TLSConfig: s.tlsConfig}
+		go func() {
+			var err error
+			if s.tlsConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Error(fmt.Errorf("error serving web socket: %w", err))
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	if srv != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}
+	s.mux.Lock()
+	for c := range s.clients {
+		_ = c.conn.Close()
+	}
+	s.mux.Unlock()
+	logrus.Warnf("stopped websocket server")
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		sess       *session
+		credential = Credential{Role: operator.RoleAdmin} // everybody is admin without authorization
+	)
+	if s.auth != nil {
+		var err error
+		if sess, credential, err = s.auth.verifyHandshake(r); err != nil {
+			logrus.Warnf("rejected web socket connection from %s: %s", r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.Error(fmt.Errorf("error upgrading web socket connection: %w", err))
+		return
+	}
+	c := s.addClient(conn, sess, credential.Role)
+	// a half-open connection fails the read, so the client leaves and releases control
+	_ = conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+	})
+	event := operator.Event{Session: operator.Session{ID: c.id, Operator: credential.Operator, Role: c.role}}
+	s.SendMessage(sessionMessage(event))
+	s.deliver(r.Context(), sessionMessage(event))
+	defer func() {
+		s.removeClient(c)
+		event.Left = true
+		s.deliver(context.Background(), sessionMessage(event))
+	}()
+
+	go s.sendMessages(c)
+	s.receiveMessages(r.Context(), c)
+}
+
+func (s *Server) pongWait() time.Duration {
+	return 3 * s.pingPeriod
+}
+
+// sessionMessage addresses the event to the session it describes.
+func sessionMessage(event operator.Event) Message {
+	payload, _ := json.Marshal(event)
+	return Message{Type: MTSession, Session: event.ID, Payload: payload}
+}
+
+// deliver passes the message to ReceiveMessage without dropping it.
+func (s *Server) deliver(ctx context.Context, msg Message) {
+	select {
+	case s.receiveChan <- msg:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Server) addClient(conn *websocket.Conn, sess *session, role operator.Role) *serverConn {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.lastID++
+	c := &serverConn{
+		id:      strconv.Itoa(s.lastID),
+		role:    role,
+		conn:    conn,
+		session: sess,
+		outbox:  newOutbox(),
+		accept:  newAcceptSet(),
+		done:    make(chan struct{}),
+	}
+	s.clients[c] = struct{}{}
+	logrus.Warnf("web socket client %s connected", c.id)
+	s.sendTo(c, helloMessage(Hello{Offer: OptionalTypes}))
+	return c
+}
+
+func (s *Server) removeClient(c *serverConn) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.clients, c)
+	close(c.done)
+	_ = c.conn.Close()
+	logrus.Warnf("web socket client %s disconnected", c.id)
+}
+
+// sendTo queues the message for the client according to its type's Policy. Must be called with s.mux held.
+func (s *Server) sendTo(c *serverConn, message Message) {
+	if !c.accept.accepts(message.Type) {
+		return
+	}
+	c.outbox.push(message)
+}
+
+// Accepts reports whether any of the connected clients accepts messages of the type.
+func (s *Server) Accepts(t MessageType) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for c := range s.clients {
+		if c.accept.accepts(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns counters of the outgoing queues summed over connected clients.
+func (s *Server) Stats() OutboxStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	total := OutboxStats{
+		Sent:    make(map[MessageType]uint64),
+		Dropped: make(map[MessageType]uint64),
+	}
+	for c := range s.clients {
+		stats := c.outbox.stats()
+		total.Queued += stats.Queued
+		for t, n := range stats.Sent {
+			total.Sent[t] += n
+		}
+		for t, n := range stats.Dropped {
+			total.Dropped[t] += n
+		}
+	}
+	return total
+}
+
+func (s *Server) receiveMessages(ctx context.Context, c *serverConn) {
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logrus.Error(fmt.Errorf("error reading message from web socket client %s: %w", c.id, err))
+			}
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+		if c.session != nil {
+			if err := c.session.verify(msg); err != nil {
+				logrus.Warnf("rejected %q message from web socket client %s: %s", msg.Type, c.id, err)
+				continue
+			}
+		}
+		if msg.Type == MTHello {
+			if !c.accept.update(msg) {
+				logrus.Warnf("broken hello message from web socket client %s", c.id)
+			}
+			continue
+		}
+		if msg.Type == MTSession {
+			// sessions are announced by the server only, a client could give itself any role
+			logrus.Warnf("rejected session message from web socket client %s", c.id)
+			continue
+		}
+		msg.Session = c.id
+		select {
+		case s.receiveChan <- msg:
+		case <-ctx.Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+			continue
+		}
+	}
+}
+
+func (s *Server) sendMessages(c *serverConn) {
+	pingTicker := time.NewTicker(s.pingPeriod)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-pingTicker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				logrus.Error(fmt.Errorf("error pinging web socket client %s: %w", c.id, err))
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.outbox.ready:
+			for {
+				msg, ok := c.outbox.pop()
+				if !ok {
+					break
+				}
+				_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteJSON(msg); err != nil {
+					logrus.Error(fmt.Errorf("error writing message to web socket client %s: %w", c.id, err))
+					_ = c.conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package wsclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/einherij/pilot/pkg/operator"
+)
+
+type ServerSuite struct {
+	suite.Suite
+
+	server *Server
+	http   *httptest.Server
+}
+
+func TestServerSuite(t *testing.T) {
+	suite.Run(t, new(ServerSuite))
+}
+
+func (s *ServerSuite) SetupTest() {
+	s.server = NewServer("", nil, nil)
+	s.http = httptest.NewServer(s.server)
+}
+
+func (s *ServerSuite) TearDownTest() {
+	s.http.Close()
+}
+
+// dial connects a client and returns its session id.
+func (s *ServerSuite) dial() (*websocket.Conn, string) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.http.URL, "http"), nil)
+	s.Require().NoError(err)
+	var event operator.Event
+	s.Require().NoError(json.Unmarshal(s.read(conn, MTSession).Payload, &event))
+	s.Equal(event, s.receive(MTSession), "controller is told about the session")
+	return conn, event.ID
+}
+
+func (s *ServerSuite) read(conn *websocket.Conn, messageType MessageType) Message {
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		var msg Message
+		s.Require().NoError(conn.ReadJSON(&msg))
+		if msg.Type == messageType {
+			return msg
+		}
+	}
+}
+
+func (s *ServerSuite) receive(messageType MessageType) operator.Event {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg := s.server.ReceiveMessage(ctx)
+	s.Require().Equal(messageType, msg.Type)
+	var event operator.Event
+	_ = json.Unmarshal(msg.Payload, &event)
+	return event
+}
+
+func (s *ServerSuite) TestFanOut() {
+	first, _ := s.dial()
+	second, _ := s.dial()
+
+	s.server.SendMessage(Message{Type: MTLog, Content: []byte("hello")})
+	s.Equal("hello", string(s.read(first, MTLog).Content))
+	s.Equal("hello", string(s.read(second, MTLog).Content))
+}
+
+func (s *ServerSuite) TestSessions() {
+	first, firstID := s.dial()
+	second, secondID := s.dial()
+	s.NotEqual(firstID, secondID)
+
+	s.server.SendMessage(Message{Type: MTLog, Session: secondID, Content: []byte("to second")})
+	s.server.SendMessage(Message{Type: MTLog, Content: []byte("to all")})
+	s.Equal("to all", string(s.read(first, MTLog).Content))
+	s.Equal("to second", string(s.read(second, MTLog).Content))
+
+	s.NoError(second.WriteJSON(Message{Type: MTSession, Payload: []byte(`{"ID":"x","Role":"admin"}`)}))
+	s.NoError(second.WriteJSON(Message{Type: MTCmd, Session: firstID, Content: []byte("Du")}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg := s.server.ReceiveMessage(ctx)
+	s.Equal(MessageType(MTCmd), msg.Type)
+	s.Equal(secondID, msg.Session, "session can't be spoofed")
+	s.Empty(msg.Payload, "sessions can't be injected")
+
+	s.NoError(first.Close())
+	event := s.receive(MTSession)
+	s.True(event.Left)
+	s.Equal(firstID, event.ID)
+}
+
+func (s *ServerSuite) TestDeadClient() {
+	s.server.pingPeriod = 50 * time.Millisecond
+	alive, _ := s.dial()
+	_, deadID := s.dial()
+	// pings are answered while the client reads
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	event := s.receive(MTSession)
+	s.True(event.Left, "the client not answering pings leaves and releases control")
+	s.Equal(deadID, event.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.Equal(Message{}, s.server.ReceiveMessage(ctx), "the client answering pings stays")
+	s.NoError(alive.Close())
+}
+
+func (s *ServerSuite) TestNegotiation() {
+	conn, _ := s.dial()
+	s.True(s.server.Accepts(MTPos))
+	s.False(s.server.Accepts(MTPose))
+
+	s.NoError(conn.WriteJSON(helloMessage(Hello{Accept: []MessageType{MTPose}})))
+	s.Eventually(func() bool { return s.server.Accepts(MTPose) }, time.Second, 10*time.Millisecond)
+	s.False(s.server.Accepts(MTPos))
+
+	s.server.SendMessage(Message{Type: MTPos, Content: []byte("v 0 0 0")})
+	s.server.SendMessage(Message{Type: MTPose, Payload: []byte(`{"x":1}`)})
+	s.server.SendMessage(Message{Type: MTLog, Content: []byte("done")})
+	var received []MessageType
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		var msg Message
+		s.Require().NoError(conn.ReadJSON(&msg))
+		received = append(received, msg.Type)
+		if msg.Type == MTLog {
+			break
+		}
+	}
+	s.Equal([]MessageType{MTPose, MTLog}, received)
+}